@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// parseAnyTLSURL reads an AnyTLS link:
+//
+//	anytls://password@host:port/?sni=sni&insecure=1&idle_session_check_interval=30s&idle_session_timeout=30s&min_idle_session=5#tag
+//
+// Durations without a unit are seconds. The padding scheme is chosen by the
+// server, so a padding parameter is logged and ignored.
+func parseAnyTLSURL(u *url.URL) (*ServerOutbound, error) {
+	host, port, err := linkEndpoint(u)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("missing password for %s", host)
+	}
+
+	q := u.Query()
+
+	ob := &ServerOutbound{
+		BaseOutbound: BaseOutbound{Type: "anytls"},
+		Server:       host,
+		ServerPort:   port,
+		Password:     u.User.Username(),
+		TLS: &TLSOptions{
+			Enabled:    true,
+			ServerName: q.Get("sni"),
+			Insecure:   q.Get("insecure") == "1" || q.Get("allowInsecure") == "1",
+			ALPN:       parseALPN(q),
+			ECH:        parseECH(q),
+			UTLS:       parseUTLS(q),
+		},
+	}
+
+	parseReality(q, ob.TLS)
+
+	if ob.IdleSessionCheckInterval, err = anyTLSDuration(q, "idle_session_check_interval"); err != nil {
+		return nil, fmt.Errorf("%s: %v", host, err)
+	}
+	if ob.IdleSessionTimeout, err = anyTLSDuration(q, "idle_session_timeout"); err != nil {
+		return nil, fmt.Errorf("%s: %v", host, err)
+	}
+	if v := q.Get("min_idle_session"); v != "" {
+		if ob.MinIdleSession, err = strconv.Atoi(v); err != nil || ob.MinIdleSession < 0 {
+			return nil, fmt.Errorf("%s: invalid min_idle_session %q", host, v)
+		}
+	}
+
+	for _, name := range []string{"padding", "padding_scheme", "padding-scheme"} {
+		if q.Has(name) {
+			log.Printf("%s: ignoring %s, the server decides the padding scheme", host, name)
+		}
+	}
+
+	return ob, nil
+}
+
+func anyTLSDuration(q url.Values, name string) (Duration, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return Duration(time.Duration(secs) * time.Second), nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return Duration(d), nil
+}
+
+// anyTLSLink is the inverse of parseAnyTLSURL.
+func anyTLSLink(ob *ServerOutbound) string {
+	q := url.Values{}
+	if tls := ob.TLS; tls != nil {
+		if tls.ServerName != "" {
+			q.Set("sni", tls.ServerName)
+		}
+		if tls.Insecure {
+			q.Set("insecure", "1")
+		}
+		setALPNParam(q, tls)
+		setECHParam(q, tls)
+		setUTLSParam(q, tls)
+		setRealityParam(q, tls)
+	}
+	if ob.IdleSessionCheckInterval != 0 {
+		q.Set("idle_session_check_interval", time.Duration(ob.IdleSessionCheckInterval).String())
+	}
+	if ob.IdleSessionTimeout != 0 {
+		q.Set("idle_session_timeout", time.Duration(ob.IdleSessionTimeout).String())
+	}
+	if ob.MinIdleSession != 0 {
+		q.Set("min_idle_session", strconv.Itoa(ob.MinIdleSession))
+	}
+
+	u := url.URL{
+		Scheme:   "anytls",
+		User:     url.User(ob.Password),
+		Host:     net.JoinHostPort(ob.Server, strconv.Itoa(ob.ServerPort)),
+		Path:     "/",
+		RawQuery: q.Encode(),
+		Fragment: ob.Tag,
+	}
+	return u.String()
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestParseAnyTLSURL(t *testing.T) {
+	runLinkTests(t, []linkTest{
+		{
+			name: "full",
+			link: "anytls://secret@any.example.com:443?sni=sni.example.com&insecure=1&alpn=h2&fp=chrome&idle_session_check_interval=30s&idle_session_timeout=60&min_idle_session=2#ANY%2001",
+			want: `{"type": "anytls", "tag": "ANY 01", "server": "any.example.com", "server_port": 443, "password": "secret",
+				"idle_session_check_interval": "30s", "idle_session_timeout": "1m0s", "min_idle_session": 2,
+				"tls": {"enabled": true, "server_name": "sni.example.com", "insecure": true, "alpn": ["h2"],
+					"utls": {"enabled": true, "fingerprint": "chrome"}}}`,
+		},
+		{
+			name: "reality",
+			link: "anytls://secret@any.example.com:443?sni=sni.example.com&pbk=abc&sid=01#reality",
+			want: `{"type": "anytls", "tag": "reality", "server": "any.example.com", "server_port": 443, "password": "secret",
+				"tls": {"enabled": true, "server_name": "sni.example.com", "insecure": false,
+					"utls": {"enabled": true, "fingerprint": "chrome"}, "reality": {"enabled": true, "public_key": "abc", "short_id": "01"}}}`,
+		},
+		{
+			name: "padding ignored",
+			link: "anytls://secret@any.example.com:443?padding_scheme=stop%3D8#pad",
+			want: `{"type": "anytls", "tag": "pad", "server": "any.example.com", "server_port": 443, "password": "secret",
+				"tls": {"enabled": true, "insecure": false}}`,
+		},
+		{name: "no password", link: "anytls://any.example.com:443"},
+		{name: "negative timeout", link: "anytls://secret@any.example.com:443?idle_session_timeout=-5s"},
+		{name: "bad duration", link: "anytls://secret@any.example.com:443?idle_session_check_interval=soon"},
+		{name: "bad min idle session", link: "anytls://secret@any.example.com:443?min_idle_session=-1"},
+	})
+}
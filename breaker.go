@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const breakerFile = "breakers.json"
+
+// breakerState is the failure history of one source.
+type breakerState struct {
+	Failures  int       `json:"failures"`
+	OpenedAt  time.Time `json:"opened_at,omitzero"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// breakers maps source names to their state. Sources without an entry are
+// healthy.
+type breakers map[string]*breakerState
+
+func loadBreakers(stateDir string) (breakers, error) {
+	b := make(breakers)
+
+	data, err := os.ReadFile(filepath.Join(stateDir, breakerFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return b, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b breakers) save(stateDir string) error {
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(stateDir, breakerFile), b)
+}
+
+// allow reports whether name may be fetched now. An open breaker lets a
+// single recovery fetch through once the cooldown has passed.
+func (b breakers) allow(name string, cfg CircuitBreakerConfig, now time.Time) bool {
+	st := b[name]
+	if cfg.Threshold == 0 || st == nil || st.Failures < cfg.Threshold {
+		return true
+	}
+
+	if now.Sub(st.OpenedAt) >= time.Duration(cfg.Cooldown) {
+		log.Printf("source %s: circuit open since %s, probing for recovery", name, st.OpenedAt.Format(time.RFC3339))
+		return true
+	}
+
+	log.Printf("source %s: circuit open after %d failures, skipping until %s (last error: %s)",
+		name, st.Failures, st.OpenedAt.Add(time.Duration(cfg.Cooldown)).Format(time.RFC3339), st.LastError)
+	return false
+}
+
+func (b breakers) failure(name string, err error, cfg CircuitBreakerConfig, now time.Time) {
+	st := b[name]
+	if st == nil {
+		st = &breakerState{}
+		b[name] = st
+	}
+
+	st.Failures++
+	st.LastError = err.Error()
+
+	// a failed recovery fetch restarts the cooldown
+	if cfg.Threshold > 0 && st.Failures >= cfg.Threshold {
+		if st.Failures == cfg.Threshold {
+			log.Printf("source %s: %d consecutive failures, opening circuit", name, st.Failures)
+		}
+		st.OpenedAt = now
+	}
+}
+
+func (b breakers) success(name string) {
+	if st, ok := b[name]; ok && st.Failures > 0 {
+		log.Printf("source %s: recovered after %d failures", name, st.Failures)
+	}
+	delete(b, name)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakers(t *testing.T) {
+	cfg := CircuitBreakerConfig{Threshold: 2, Cooldown: Duration(time.Hour)}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	errDown := errors.New("down")
+
+	// each step fails or succeeds a fetch of "a" at start+at if it was
+	// allowed, and checks whether it was
+	steps := []struct {
+		at    time.Duration
+		fail  bool
+		allow bool
+	}{
+		{0, true, true},
+		{time.Minute, true, true},
+		{2 * time.Minute, true, false},
+		{time.Hour, true, false},
+		{time.Hour + time.Minute, true, true},
+		{time.Hour + 2*time.Minute, false, false},
+		{2*time.Hour + time.Minute, false, true},
+		{2*time.Hour + 2*time.Minute, false, true},
+	}
+
+	b := make(breakers)
+	for i, step := range steps {
+		now := start.Add(step.at)
+		if got := b.allow("a", cfg, now); got != step.allow {
+			t.Fatalf("step %d: allow() = %v, want %v", i, got, step.allow)
+		}
+		if !step.allow {
+			continue
+		}
+		if step.fail {
+			b.failure("a", errDown, cfg, now)
+		} else {
+			b.success("a")
+		}
+	}
+	if len(b) != 0 {
+		t.Errorf("recovered source still has state %+v", b["a"])
+	}
+}
+
+func TestBreakersDisabled(t *testing.T) {
+	cfg := CircuitBreakerConfig{Cooldown: Duration(time.Hour)}
+	now := time.Now()
+
+	b := make(breakers)
+	for range 5 {
+		b.failure("a", errors.New("down"), cfg, now)
+	}
+	if !b.allow("a", cfg, now) {
+		t.Error("allow() = false without a threshold")
+	}
+	if st := b["a"]; st.Failures != 5 || !st.OpenedAt.IsZero() || st.LastError != "down" {
+		t.Errorf("state = %+v", st)
+	}
+	if !b.allow("b", CircuitBreakerConfig{Threshold: 1}, now) {
+		t.Error("allow() = false for a source without failures")
+	}
+}
+
+func TestBreakersSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	b, err := loadBreakers(dir)
+	if err != nil || len(b) != 0 {
+		t.Fatalf("loadBreakers() without a file = %v, %v", b, err)
+	}
+
+	opened := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b.failure("a", errors.New("down"), CircuitBreakerConfig{Threshold: 1}, opened)
+	b.failure("b", errors.New("slow"), CircuitBreakerConfig{Threshold: 2}, opened)
+	if err := b.save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadBreakers(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJSON(t, "loadBreakers()", loaded, `{
+		"a": {"failures": 1, "opened_at": "2024-01-01T00:00:00Z", "last_error": "down"},
+		"b": {"failures": 1, "last_error": "slow"}
+	}`)
+}
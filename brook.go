@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// parseBrookURL reads a Brook link, whose host names the kind of server
+// and whose query holds the address:
+//
+//	brook://server?server=host:port&password=pw#tag
+//	brook://wsserver?wsserver=ws://host:port/ws&password=pw#tag
+//	brook://wssserver?wssserver=wss://host:port/ws&password=pw#tag
+//	brook://socks5?socks5=socks5://host:port&username=u&password=pw#tag
+//
+// socks5 servers become socks outbounds. The brook protocol itself has no
+// sing-box outbound, so the other kinds are kept as brook nodes for the
+// filter stage to reject.
+func parseBrookURL(u *url.URL) (*ServerOutbound, error) {
+	q := u.Query()
+	kind := u.Host
+	addr := q.Get(kind)
+	if addr == "" {
+		return nil, fmt.Errorf("brook %s link without a %s address", kind, kind)
+	}
+
+	switch kind {
+	case "socks5":
+		su, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		host, port, err := linkEndpoint(su)
+		if err != nil {
+			return nil, err
+		}
+		return &ServerOutbound{
+			BaseOutbound: BaseOutbound{Type: "socks"},
+			Server:       host,
+			ServerPort:   port,
+			Username:     q.Get("username"),
+			Password:     q.Get("password"),
+		}, nil
+
+	case "server", "quicserver":
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, err
+		}
+		return newBrookOutbound(host, port, q.Get("password")), nil
+
+	case "wsserver", "wssserver":
+		wu, err := url.Parse(addr)
+		if err != nil {
+			return nil, err
+		}
+		host, port, err := linkEndpoint(wu)
+		if err != nil {
+			return nil, err
+		}
+		return newBrookOutbound(host, port, q.Get("password")), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported brook kind %q", kind)
+	}
+}
+
+func newBrookOutbound(host string, port int, password string) *ServerOutbound {
+	return &ServerOutbound{
+		BaseOutbound: BaseOutbound{Type: "brook"},
+		Server:       host,
+		ServerPort:   port,
+		Password:     password,
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestParseBrookURL(t *testing.T) {
+	runLinkTests(t, []linkTest{
+		{
+			name: "server",
+			link: "brook://server?server=1.2.3.4%3A9999&password=secret#BR",
+			want: `{"type": "brook", "tag": "BR", "server": "1.2.3.4", "server_port": 9999, "password": "secret"}`,
+		},
+		{
+			name: "quic server",
+			link: "brook://quicserver?quicserver=brook.example.com%3A443&password=secret#BR",
+			want: `{"type": "brook", "tag": "BR", "server": "brook.example.com", "server_port": 443, "password": "secret"}`,
+		},
+		{
+			name: "websocket server",
+			link: "brook://wsserver?wsserver=ws%3A%2F%2Fbrook.example.com%3A80&password=secret#BR",
+			want: `{"type": "brook", "tag": "BR", "server": "brook.example.com", "server_port": 80, "password": "secret"}`,
+		},
+		{
+			name: "socks5",
+			link: "brook://socks5?socks5=socks5%3A%2F%2F1.2.3.4%3A1080&username=u&password=p#S5",
+			want: `{"type": "socks", "tag": "S5", "server": "1.2.3.4", "server_port": 1080, "username": "u", "password": "p"}`,
+		},
+		{name: "no address", link: "brook://server?password=secret"},
+		{name: "no port", link: "brook://server?server=1.2.3.4&password=secret"},
+		{name: "unknown kind", link: "brook://relay?relay=1.2.3.4%3A9999"},
+	})
+}
@@ -0,0 +1,679 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type buildOptions struct {
+	Config          configOptions
+	TagHash         bool
+	MaxTagLength    int
+	Sort            bool
+	Renumber        bool
+	SplitRegions    bool
+	SingleFile      singleFileMode
+	StateDir        string
+	Probe           bool
+	PinInsecure     pinMode
+	Target          targetVersion
+	Platform        platformFlag
+	Offline         bool
+	RuleSetDir      string
+	ExportDir       string
+	PatchDir        string
+	StageDir        string
+	TextfileDir     string
+	SubscriptionOut string
+	QRDir           string
+	Reload          reloadOptions
+	Fetch           fetchOptions
+	Force           bool
+}
+
+func buildCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	opts := &buildOptions{}
+	registerBuildFlags(fs, opts)
+	fs.BoolVar(&opts.Force, "force", false, "build even when every source answered a conditional request with 304")
+
+	return func(ctx context.Context) error {
+		return build(ctx, opts)
+	}
+}
+
+const defaultExportDir = "/etc/sing-box"
+
+// registerBuildFlags is shared by every command that runs a build.
+func registerBuildFlags(fs *flag.FlagSet, opts *buildOptions) {
+	registerConfigFlags(fs, &opts.Config)
+	fs.BoolVar(&opts.TagHash, "tag-hash", false, "append the stable node id to every server tag")
+	fs.IntVar(&opts.MaxTagLength, "max-tag-length", 0, "shorten longer server tags to this many characters, keeping the region and appending the node id (0 disables)")
+	fs.BoolVar(&opts.Sort, "sort", false, "order regions and nodes by name instead of provider order")
+	fs.BoolVar(&opts.Renumber, "renumber", false, "rename nodes to \"<region> 01\" and up, numbered in node id order so the numbers do not depend on the provider's")
+	fs.BoolVar(&opts.SplitRegions, "split-regions", false, "write one servers-<region>.json per region instead of servers.json")
+	fs.StringVar(&opts.StateDir, "state-dir", "state", "directory for the build report and other runtime state")
+	fs.Var(&opts.SingleFile, "single-file", "merge generated outbounds into outbounds.json, or with =config export one merged config.json")
+	fs.BoolVar(&opts.Probe, "probe", false, "measure TCP connect latency to every node and record it in the state directory")
+	fs.Var(&opts.Target, "target-version", "sing-box version to generate configs for, e.g. 1.11; options it does not support are left out (default latest)")
+	fs.Var(&opts.Platform, "platform", "apply the limits of a constrained platform: ios or tvos")
+	fs.Var(&opts.PinInsecure, "pin-insecure", "record the certificate of every insecure node in the state directory, or with =tls pin it in the tls block instead of skipping verification")
+	fs.BoolVar(&opts.Offline, "offline", false, "never touch the network: use the cached subscription and bundled rule-sets only")
+	fs.StringVar(&opts.RuleSetDir, "ruleset-dir", "rulesets", "directory of bundled rule-set files used by -offline")
+	fs.StringVar(&opts.ExportDir, "export-dir", "", "directory the final sing-box configs are exported to (default export.dir from the config, else the sing-box conffile directory on OpenWrt, else "+defaultExportDir+")")
+	fs.StringVar(&opts.StageDir, "stage-dir", "", "hand the configs to \"msbc install\" through this directory instead of exporting and reloading")
+	fs.StringVar(&opts.TextfileDir, "textfile-dir", "", "write msbc.prom for the node_exporter textfile collector into this directory after every successful build")
+	fs.StringVar(&opts.SubscriptionOut, "subscription-out", "", "also write the processed nodes as a base64 share link subscription to this file")
+	fs.StringVar(&opts.QRDir, "qr-dir", "", "write a QR code of every node's share link into this directory, removing other .png files there")
+	fs.StringVar(&opts.PatchDir, "patch-dir", "patches", "directory of <name>.merge.json and <name>.patch.json files applied to the exported <name>.json")
+	registerReloadFlags(fs, &opts.Reload)
+	registerFetchFlags(fs, &opts.Fetch)
+}
+
+// parseOutbounds converts share links into deduplicated outbounds.
+func parseOutbounds(lines []string) []ServerOutbound {
+	return dedupOutbounds(parseLines(lines), nil)
+}
+
+// parseLines converts share links into outbounds, skipping lines that cannot
+// be converted. Comment and metadata lines are skipped quietly.
+func parseLines(lines []string) []ServerOutbound {
+	outbounds := make([]ServerOutbound, 0)
+
+	lines, blocks := splitWireGuardBlocks(lines)
+	for _, block := range blocks {
+		nodes, err := parseWireGuardINI(block, "")
+		if err != nil {
+			log.Printf("skipping invalid block: %v", err)
+			continue
+		}
+		outbounds = append(outbounds, nodes...)
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || isMetadataLine(line) {
+			continue
+		}
+
+		ob, err := parseShareLink(line)
+		if err != nil {
+			log.Printf("skipping invalid line: %v", err)
+			continue
+		}
+		if ob == nil {
+			continue
+		}
+
+		outbounds = append(outbounds, *ob)
+	}
+
+	return outbounds
+}
+
+// dedupOutbounds keeps one node per server:port in the position of its first
+// occurrence. The node from the source with the highest priority wins, and
+// among equal priorities the last occurrence does.
+func dedupOutbounds(all []ServerOutbound, priorities map[string]int) []ServerOutbound {
+	outbounds := make([]ServerOutbound, 0, len(all))
+	indexMap := make(map[string]int)
+
+	for _, ob := range all {
+		key := outboundKey(ob.Server, ob.ServerPort)
+
+		if idx, exists := indexMap[key]; exists {
+			prev := &outbounds[idx]
+			if priorities[ob.Source] >= priorities[prev.Source] {
+				buildTrace.note(prev, "dropped as a duplicate of %s from source %s (priority %d over %d)",
+					ob.ID, ob.Source, priorities[ob.Source], priorities[prev.Source])
+				buildTrace.note(&ob, "replaced duplicate %s from source %s", prev.ID, prev.Source)
+				outbounds[idx] = ob
+			} else {
+				buildTrace.note(&ob, "dropped as a duplicate of %s from source %s (priority %d over %d)",
+					prev.ID, prev.Source, priorities[prev.Source], priorities[ob.Source])
+			}
+		} else {
+			indexMap[key] = len(outbounds)
+			outbounds = append(outbounds, ob)
+		}
+	}
+
+	log.Printf("parsed %d unique servers", len(outbounds))
+	return outbounds
+}
+
+// groupRegions assigns every outbound its region and returns the regions in
+// order of first appearance along with the tags belonging to each. Nodes that
+// are alone in their region are renamed to the region itself.
+func groupRegions(outbounds []ServerOutbound) ([]string, map[string][]string) {
+	regionTags := make(map[string][]string)
+	regionIndex := make(map[string]int)
+	regionOrder := make([]string, 0)
+
+	for i, ob := range outbounds {
+		region := extractRegion(ob.Tag)
+		outbounds[i].Region = region
+		buildTrace.note(&outbounds[i], "assigned to region %s", region)
+
+		if _, exists := regionIndex[region]; !exists {
+			regionIndex[region] = len(regionOrder)
+			regionOrder = append(regionOrder, region)
+		}
+
+		regionTags[region] = append(regionTags[region], ob.Tag)
+	}
+
+	for _, region := range regionOrder {
+		tags := regionTags[region]
+		if len(tags) == 1 {
+			originalTag := tags[0]
+
+			for i := range outbounds {
+				if outbounds[i].Tag == originalTag {
+					outbounds[i].Tag = region
+					buildTrace.renamed(&outbounds[i], originalTag, "alone in its region")
+					break
+				}
+			}
+
+			regionTags[region][0] = region
+		}
+	}
+
+	return regionOrder, regionTags
+}
+
+// renameTags replaces every node tag with the result of rename, keeping the
+// region membership lists in sync. why ends up in the trace.
+func renameTags(outbounds []ServerOutbound, regionTags map[string][]string, why string, rename func(ob *ServerOutbound) string) {
+	renamed := make(map[string]string, len(outbounds))
+	for i := range outbounds {
+		tag := rename(&outbounds[i])
+		renamed[outbounds[i].Tag] = tag
+		from := outbounds[i].Tag
+		outbounds[i].Tag = tag
+		buildTrace.renamed(&outbounds[i], from, why)
+	}
+
+	for _, tags := range regionTags {
+		for i, tag := range tags {
+			tags[i] = renamed[tag]
+		}
+	}
+}
+
+// renumberTags names the nodes of every region "<region> NN", numbering
+// them in the order of their ids. The ids are hashes of the endpoint, so the
+// numbers stay put however the provider orders or numbers its list. Group
+// members follow the new numbering.
+func renumberTags(outbounds []ServerOutbound, regionTags map[string][]string) {
+	byRegion := make(map[string][]*ServerOutbound)
+	for i := range outbounds {
+		ob := &outbounds[i]
+		byRegion[ob.Region] = append(byRegion[ob.Region], ob)
+	}
+
+	for region, members := range byRegion {
+		// a lone node is already named after its region
+		if len(members) == 1 {
+			continue
+		}
+
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].ID < members[j].ID
+		})
+
+		width := max(2, len(strconv.Itoa(len(members))))
+		tags := make([]string, 0, len(members))
+		for i, ob := range members {
+			from := ob.Tag
+			ob.Tag = fmt.Sprintf("%s %0*d", region, width, i+1)
+			buildTrace.renamed(ob, from, "-renumber")
+			tags = append(tags, ob.Tag)
+		}
+		regionTags[region] = tags
+	}
+}
+
+// truncateTag shortens a tag longer than max characters. The region is kept
+// as a prefix where it fits and the node id is appended, so shortened tags
+// stay recognizable and unique.
+func truncateTag(ob *ServerOutbound, max int) string {
+	tag := []rune(ob.Tag)
+	if len(tag) <= max {
+		return ob.Tag
+	}
+
+	suffix := " [" + ob.ID + "]"
+	if strings.HasSuffix(ob.Tag, suffix) {
+		tag = []rune(strings.TrimSuffix(ob.Tag, suffix))
+	}
+
+	keep := max - len(suffix)
+	if keep <= 0 {
+		return ob.ID
+	}
+
+	region := []rune(ob.Region)
+	if len(region) < keep && strings.HasPrefix(ob.Tag, ob.Region) {
+		// keep the region whole and cut what follows it
+		rest := []rune(strings.TrimSpace(string(tag[len(region):])))
+		if avail := keep - len(region) - 1; avail > 0 && len(rest) > 0 {
+			return string(region) + " " + strings.TrimSpace(string(rest[:min(avail, len(rest))])) + suffix
+		}
+		return string(region) + suffix
+	}
+
+	return strings.TrimSpace(string(tag[:min(keep, len(tag))])) + suffix
+}
+
+func build(ctx context.Context, opts *buildOptions) error {
+	offlineMode = opts.Offline
+
+	cfg, err := loadConfig(&opts.Config)
+	if err != nil {
+		return err
+	}
+	if err := setupLogging(cfg.Log); err != nil {
+		return err
+	}
+
+	payloads, err := loadSources(ctx, cfg, opts.StateDir, &opts.Fetch)
+	if err != nil {
+		return err
+	}
+
+	if !opts.Force && unchangedSinceBuild(opts.StateDir, payloads) {
+		log.Printf("no source changed since the last build, nothing to do")
+		return nil
+	}
+
+	if err := generate(ctx, opts, cfg, payloads); err != nil {
+		return err
+	}
+	return saveBuiltSources(opts.StateDir, payloads)
+}
+
+// generate turns the source payloads into sing-box configs and exports them.
+func generate(ctx context.Context, opts *buildOptions, cfg *Config, payloads []sourcePayload) error {
+	start := time.Now()
+
+	buildTrace = newTrace()
+	defer func() { buildTrace = nil }()
+
+	for _, p := range payloads {
+		if p.Meta.Usage != nil {
+			log.Printf("source %s: plan status: %s", p.Source.Name, p.Meta.Usage)
+		}
+		for _, warning := range expiryWarnings(cfg.Expiry, p.Meta.Usage, time.Now()) {
+			log.Printf("warning: source %s: %s", p.Source.Name, warning)
+		}
+	}
+	if err := checkExpired(cfg.Expiry, payloads, time.Now()); err != nil {
+		return err
+	}
+
+	outbounds, err := parseSources(ctx, activePayloads(cfg.Expiry, payloads), &cfg.Subconverter)
+	if err != nil {
+		return err
+	}
+
+	wireguard, err := loadWireGuardFiles(cfg.WireGuard)
+	if err != nil {
+		return err
+	}
+	outbounds = append(outbounds, wireguard...)
+
+	if opts.Sort {
+		sortOutbounds(outbounds)
+	}
+
+	applyTLSDefaults(&cfg.TLS, outbounds)
+	if err := applyPacketEncoding(cfg.PacketEncoding, outbounds); err != nil {
+		return err
+	}
+	outbounds = downgradeOutbounds(outbounds, opts.Target)
+
+	if cfg.Resolve.Enabled {
+		if err := requireNetwork("resolving node addresses"); err != nil {
+			return err
+		}
+		if err := resolveOutbounds(ctx, outbounds); err != nil {
+			return err
+		}
+		outbounds = filterFamily(cfg.Resolve, outbounds)
+	}
+
+	outbounds, rejected := filterOutbounds(cfg.Filter, cfg.Sources, outbounds)
+
+	outbounds = opts.Platform.profile.limitOutbounds(outbounds)
+
+	regionOrder, regionTags := groupRegions(outbounds)
+	applyRoutingMarks(&cfg.RoutingMark, outbounds)
+	applyGRPCDefaults(&cfg.GRPC, outbounds)
+	applyMultiplex(&cfg.Multiplex, outbounds)
+
+	if opts.Renumber {
+		renumberTags(outbounds, regionTags)
+	}
+
+	latency, err := loadLatency(opts.StateDir)
+	if err != nil {
+		return err
+	}
+	if opts.Probe {
+		if err := requireNetwork("probing node latency"); err != nil {
+			return err
+		}
+		latency, err = probeLatency(ctx, outbounds)
+		if err != nil {
+			return err
+		}
+		if err := saveLatency(opts.StateDir, latency); err != nil {
+			return err
+		}
+	}
+
+	if opts.TagHash {
+		renameTags(outbounds, regionTags, "-tag-hash", func(ob *ServerOutbound) string {
+			return ob.Tag + " [" + ob.ID + "]"
+		})
+	}
+
+	if len(cfg.Latency.Tiers) > 0 {
+		renameTags(outbounds, regionTags, "latency tier", func(ob *ServerOutbound) string {
+			return latencyTag(cfg.Latency.Tiers, ob, latency)
+		})
+	}
+
+	if opts.MaxTagLength > 0 {
+		renameTags(outbounds, regionTags, "-max-tag-length", func(ob *ServerOutbound) string {
+			return truncateTag(ob, opts.MaxTagLength)
+		})
+	}
+
+	if opts.PinInsecure != pinOff {
+		if err := requireNetwork("capturing certificates"); err != nil {
+			return err
+		}
+		previous, err := loadPins(opts.StateDir)
+		if err != nil {
+			return err
+		}
+		pins, err := capturePins(ctx, outbounds, previous)
+		if err != nil {
+			return err
+		}
+		if err := savePins(opts.StateDir, pins); err != nil {
+			return err
+		}
+		if opts.PinInsecure == pinTLS {
+			applyPins(outbounds, pins)
+		}
+	}
+
+	err = os.MkdirAll("config", 0755)
+	if err != nil {
+		return err
+	}
+
+	written := make(map[string]struct{})
+
+	if opts.SingleFile == singleFileOff {
+		names, err := writeServers("config", outbounds, opts.SplitRegions)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			written[name] = struct{}{}
+		}
+	}
+
+	var groupOutbounds []GroupOutbound
+
+	for _, region := range regionOrder {
+		tags := regionTags[region]
+
+		if len(tags) <= 1 {
+			continue
+		}
+
+		autoTag := region + "-auto"
+
+		urltest := GroupOutbound{
+			SelectorOutbound: SelectorOutbound{
+				BaseOutbound: BaseOutbound{
+					Type: "urltest",
+					Tag:  autoTag,
+				},
+				Outbounds: opts.Platform.profile.urltestMembers(tags),
+			},
+			Interval:                  cfg.URLTest.Interval,
+			Tolerance:                 cfg.URLTest.Tolerance,
+			InterruptExistConnections: false,
+		}
+
+		selector := GroupOutbound{
+			SelectorOutbound: SelectorOutbound{
+				BaseOutbound: BaseOutbound{
+					Type: "selector",
+					Tag:  region,
+				},
+				Outbounds: append([]string{autoTag}, tags...),
+			},
+			InterruptExistConnections: true,
+		}
+
+		groupOutbounds = append(groupOutbounds, urltest, selector)
+	}
+
+	fast := fastTags(&cfg.Latency, outbounds, latency)
+	if len(fast) > 0 {
+		if _, clash := regionTags[cfg.Latency.fastGroup()]; clash {
+			return fmt.Errorf("latency.fast_group %q is also a region", cfg.Latency.fastGroup())
+		}
+
+		groupOutbounds = append(groupOutbounds, GroupOutbound{
+			SelectorOutbound: SelectorOutbound{
+				BaseOutbound: BaseOutbound{
+					Type: "urltest",
+					Tag:  cfg.Latency.fastGroup(),
+				},
+				Outbounds: opts.Platform.profile.urltestMembers(fast),
+			},
+			Interval:  cfg.URLTest.Interval,
+			Tolerance: cfg.URLTest.Tolerance,
+		})
+	}
+
+	log.Printf("parsed %d server groups", len(groupOutbounds))
+
+	groupsCfg := GroupsConfig{
+		Outbounds: groupOutbounds,
+	}
+
+	if opts.SingleFile == singleFileOff {
+		err = writeJSON("config/groups.json", groupsCfg)
+		if err != nil {
+			return err
+		}
+
+		written["groups.json"] = struct{}{}
+		log.Printf("wrote config/groups.json")
+	}
+
+	selectors, err := loadSelectors("config/selectors.scheme.json")
+	if err != nil {
+		return err
+	}
+
+	// single-node regions have no group, so selectors reference the node itself
+	regionMembers := make([]string, 0, len(regionOrder)+1)
+	if len(fast) > 0 {
+		regionMembers = append(regionMembers, cfg.Latency.fastGroup())
+	}
+	for _, region := range regionOrder {
+		tags := regionTags[region]
+		if len(tags) == 1 {
+			regionMembers = append(regionMembers, tags[0])
+			continue
+		}
+		regionMembers = append(regionMembers, region)
+	}
+
+	for i, ob := range selectors {
+		sel, ok := ob.(SelectorOutbound)
+		if !ok {
+			continue
+		}
+
+		sel.Outbounds = appendUnique(sel.Outbounds, regionMembers)
+		selectors[i] = sel
+	}
+
+	selectorsCfg := struct {
+		Outbounds []any `json:"outbounds"`
+	}{
+		Outbounds: selectors,
+	}
+
+	if opts.SingleFile == singleFileOff {
+		err = writeJSON("config/selectors.json", selectorsCfg)
+		if err != nil {
+			return err
+		}
+
+		written["selectors.json"] = struct{}{}
+		log.Printf("wrote config/selectors.json")
+	} else {
+		servers, endpoints := splitEndpoints(outbounds)
+		merged := expandOutbounds(servers)
+		for _, ob := range groupOutbounds {
+			merged = append(merged, ob)
+		}
+		merged = append(merged, selectors...)
+
+		err = writeJSON("config/outbounds.json", struct {
+			Outbounds []any            `json:"outbounds"`
+			Endpoints []ServerOutbound `json:"endpoints,omitempty"`
+		}{
+			Outbounds: merged,
+			Endpoints: endpoints,
+		})
+		if err != nil {
+			return err
+		}
+
+		written["outbounds.json"] = struct{}{}
+		log.Printf("wrote config/outbounds.json")
+	}
+
+	err = pruneGenerated("config", written)
+	if err != nil {
+		return err
+	}
+
+	report := newReport(cfg, payloads, outbounds, rejected)
+	err = writeReport(opts.StateDir, report)
+	if err != nil {
+		return err
+	}
+
+	buildTrace.finish(outbounds, groupOutbounds, selectors)
+	if err := buildTrace.save(opts.StateDir); err != nil {
+		return err
+	}
+
+	if opts.SubscriptionOut != "" {
+		if err := writeSubscription(opts.SubscriptionOut, outbounds); err != nil {
+			return err
+		}
+	}
+	if opts.QRDir != "" {
+		if err := writeQRCodes(opts.QRDir, outbounds); err != nil {
+			return err
+		}
+	}
+
+	if opts.Offline {
+		err = localizeRuleSets("config", opts.RuleSetDir, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	exportDir := cmp.Or(opts.ExportDir, cfg.Export.Dir, openwrtExportDir(), defaultExportDir)
+
+	if opts.StageDir != "" {
+		// localized rule-sets would point into the staging directory
+		if opts.Offline {
+			return errors.New("-stage-dir cannot be combined with -offline")
+		}
+
+		exportDir, err = beginStage(opts.StageDir)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(exportDir)
+	}
+
+	err = exportPatched(ctx, "config", exportDir, opts.PatchDir, opts.Config.Env, opts.SingleFile == singleFileConfig)
+	if err == nil && opts.Offline {
+		err = localizeRuleSets(exportDir, opts.RuleSetDir, true)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export configs: %v", err)
+	}
+
+	if err := opts.Platform.profile.checkBudget(exportDir); err != nil {
+		return err
+	}
+
+	if cfg.Encrypt.Dir != "" {
+		if err := encryptExport(ctx, &cfg.Encrypt, exportDir); err != nil {
+			return fmt.Errorf("failed to encrypt configs: %v", err)
+		}
+	}
+
+	// hosts that fail are reported in state/fleet.json and retried by the
+	// next build
+	if len(cfg.Fleet) > 0 {
+		if err := pushFleet(ctx, cfg.Fleet, opts.StateDir, exportDir); err != nil {
+			return fmt.Errorf("failed to push configs to the fleet: %v", err)
+		}
+	}
+
+	// the history is best effort, the next build commits whatever is current
+	if cfg.Git.Repo != "" {
+		if err := exportGit(ctx, &cfg.Git, opts.StateDir, exportDir); err != nil {
+			log.Printf("warning: failed to commit configs: %v", err)
+		}
+	}
+
+	if opts.StageDir != "" {
+		if err := commitStage(opts.StageDir, exportDir); err != nil {
+			return err
+		}
+	} else if err := reloadSingBox(ctx, &opts.Reload); err != nil {
+		return fmt.Errorf("failed to reload sing-box: %v", err)
+	}
+
+	if opts.TextfileDir != "" {
+		if err := writeTextfile(opts.TextfileDir, report, time.Since(start)); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("built %d nodes in %d regions from %d sources (%d rejected) in %s",
+		len(outbounds), len(regionOrder), len(report.Sources), len(rejected), time.Since(start).Round(time.Millisecond))
+	log.Printf("all done")
+	return nil
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var clashProxiesRe = regexp.MustCompile(`(?m)^proxies:`)
+
+// isClashConfig reports whether body is a Clash config or proxy-provider
+// file, both of which list their nodes under a top-level proxies key.
+func isClashConfig(body []byte) bool {
+	return clashProxiesRe.Match(body)
+}
+
+// decodeClash turns the proxies of a Clash document into share links, so
+// they take the same path through parseShareLink as the links of a base64
+// subscription. Proxies msbc cannot read are skipped, with the reasons
+// returned for the caller to log.
+func decodeClash(body []byte) (lines, skipped []string, err error) {
+	doc, err := parseYAML(body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid clash yaml: %v", err)
+	}
+
+	root, _ := doc.(map[string]any)
+	proxies, ok := root["proxies"].([]any)
+	if !ok {
+		return nil, nil, errors.New("invalid clash yaml: proxies is not a list")
+	}
+
+	for i, item := range proxies {
+		p, ok := item.(map[string]any)
+		if !ok {
+			skipped = append(skipped, fmt.Sprintf("proxy %d: not a mapping", i))
+			continue
+		}
+
+		link, err := clashLink(clashProxy(p))
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("proxy %q: %v", clashProxy(p).str("name"), err))
+			continue
+		}
+		lines = append(lines, link)
+	}
+	return lines, skipped, nil
+}
+
+// clashProxy is one entry of a proxies list.
+type clashProxy map[string]any
+
+func (p clashProxy) str(key string) string {
+	s, _ := p[key].(string)
+	return s
+}
+
+func (p clashProxy) bool(key string) bool {
+	return p.str(key) == "true"
+}
+
+// list reads a sequence, or a comma separated string.
+func (p clashProxy) list(key string) []string {
+	switch v := p[key].(type) {
+	case string:
+		return splitList(v)
+	case []any:
+		var items []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+		return items
+	}
+	return nil
+}
+
+func (p clashProxy) opts(key string) clashProxy {
+	m, _ := p[key].(map[string]any)
+	return m
+}
+
+// clashLink builds the share link of a Clash proxy.
+func clashLink(p clashProxy) (string, error) {
+	server := p.str("server")
+	port, err := strconv.Atoi(p.str("port"))
+	if server == "" || err != nil {
+		return "", errors.New("missing server or port")
+	}
+
+	ob := &ServerOutbound{
+		BaseOutbound: BaseOutbound{Tag: p.str("name")},
+		Server:       server,
+		ServerPort:   port,
+		Username:     p.str("username"),
+		Password:     p.str("password"),
+	}
+
+	switch typ := p.str("type"); typ {
+	case "ss":
+		ob.Type = "shadowsocks"
+		ob.Method = p.str("cipher")
+		if err := clashPlugin(p, ob); err != nil {
+			return "", err
+		}
+
+	case "ssr":
+		ob.Type = "shadowsocksr"
+		ob.Method = p.str("cipher")
+		ob.Protocol = p.str("protocol")
+		ob.ProtocolParam = p.str("protocol-param")
+		ob.Obfs.Value = p.str("obfs")
+		ob.ObfsParam = p.str("obfs-param")
+
+	case "trojan":
+		ob.Type = "trojan"
+		ob.TLS = clashTLS(p)
+		if ob.Transport, err = clashTransport(p); err != nil {
+			return "", err
+		}
+
+	case "hysteria":
+		ob.Type = "hysteria"
+		ob.AuthStr = cmp.Or(p.str("auth-str"), p.str("auth_str"))
+		ob.UpMbps = clashMbps(p.str("up"))
+		ob.DownMbps = clashMbps(p.str("down"))
+		ob.Obfs.Value = p.str("obfs")
+		ob.TLS = clashTLS(p)
+		if protocol := p.str("protocol"); protocol != "" && protocol != "udp" {
+			return "", fmt.Errorf("unsupported protocol %q, sing-box only speaks udp", protocol)
+		}
+
+	case "hysteria2":
+		ob.Type = "hysteria2"
+		if obfs := p.str("obfs"); obfs != "" {
+			ob.Obfs = ObfsOptions{Type: obfs, Password: p.str("obfs-password")}
+		}
+		ob.TLS = clashTLS(p)
+
+	case "tuic":
+		if p.str("token") != "" {
+			return "", errors.New("TUIC v4 token nodes are not supported, sing-box only speaks v5")
+		}
+		ob.Type = "tuic"
+		ob.UUID = p.str("uuid")
+		ob.CongestionControl = p.str("congestion-controller")
+		ob.UDPRelayMode = p.str("udp-relay-mode")
+		ob.TLS = clashTLS(p)
+
+	case "wireguard":
+		wg := map[string]string{
+			"privatekey":   p.str("private-key"),
+			"publickey":    p.str("public-key"),
+			"presharedkey": cmp.Or(p.str("pre-shared-key"), p.str("preshared-key")),
+			"address":      strings.Join(slices.DeleteFunc([]string{p.str("ip"), p.str("ipv6")}, func(s string) bool { return s == "" }), ","),
+			"allowedips":   strings.Join(p.list("allowed-ips"), ","),
+			"mtu":          p.str("mtu"),
+			"reserved":     strings.Join(p.list("reserved"), ","),
+			"keepalive":    p.str("persistent-keepalive"),
+		}
+		wob, err := newWireGuardOutbound(server, port, wg)
+		if err != nil {
+			return "", err
+		}
+		wob.Tag = ob.Tag
+		ob = wob
+
+	case "socks5":
+		if p.bool("tls") {
+			return "", errors.New("socks over tls has no sing-box outbound")
+		}
+		ob.Type = "socks"
+
+	case "http":
+		ob.Type = "http"
+		if p.bool("tls") {
+			ob.TLS = clashTLS(p)
+		}
+
+	case "anytls":
+		ob.Type = "anytls"
+		ob.TLS = clashTLS(p)
+		// the options are seconds, which anyTLSDuration reads as well
+		q := url.Values{
+			"idle-session-check-interval": {p.str("idle-session-check-interval")},
+			"idle-session-timeout":        {p.str("idle-session-timeout")},
+		}
+		if ob.IdleSessionCheckInterval, err = anyTLSDuration(q, "idle-session-check-interval"); err != nil {
+			return "", err
+		}
+		if ob.IdleSessionTimeout, err = anyTLSDuration(q, "idle-session-timeout"); err != nil {
+			return "", err
+		}
+		ob.MinIdleSession, _ = strconv.Atoi(p.str("min-idle-session"))
+
+	case "ssh":
+		ob.Type = "ssh"
+		ob.User, ob.Username = ob.Username, ""
+		// mihomo takes either the key itself or the path of its file
+		if key := p.str("private-key"); strings.Contains(key, "PRIVATE KEY") {
+			ob.PrivateKey = key
+		} else {
+			ob.PrivateKeyPath = key
+		}
+		ob.PrivateKeyPassphrase = p.str("private-key-passphrase")
+		ob.HostKey = p.list("host-key")
+		ob.HostKeyAlgorithms = p.list("host-key-algorithms")
+
+	case "snell":
+		ob.Type = "snell"
+		ob.Password = p.str("psk")
+		ob.Version = p.str("version")
+
+	case "mieru":
+		if transport := p.str("transport"); transport != "" && transport != "TCP" {
+			return "", fmt.Errorf("unsupported transport %q", transport)
+		}
+		ob.Type = "mieru"
+		ob.Multiplexing = p.str("multiplexing")
+
+	// msbc has no outbound of its own for these, their links take the
+	// subconverter path like those of a base64 subscription
+	case "vmess", "vless":
+		if p.str("uuid") == "" {
+			return "", errors.New("missing uuid")
+		}
+		if ob.Transport, err = clashTransport(p); err != nil {
+			return "", err
+		}
+		if p.bool("tls") || p.opts("reality-opts") != nil {
+			ob.TLS = clashTLS(p)
+		}
+		if typ == "vmess" {
+			return clashVMessLink(p, ob)
+		}
+		return clashVLESSLink(p, ob), nil
+
+	default:
+		return "", fmt.Errorf("msbc cannot read %s nodes", typ)
+	}
+
+	return shareLink(ob)
+}
+
+// clashVMessLink writes a vmess proxy as the base64 JSON link of v2rayN,
+// which is what the subconverter reads.
+func clashVMessLink(p clashProxy, ob *ServerOutbound) (string, error) {
+	v := map[string]string{
+		"v":    "2",
+		"ps":   ob.Tag,
+		"add":  ob.Server,
+		"port": strconv.Itoa(ob.ServerPort),
+		"id":   p.str("uuid"),
+		"aid":  cmp.Or(p.str("alterId"), "0"),
+		"scy":  cmp.Or(p.str("cipher"), "auto"),
+		"net":  "tcp",
+		"type": "none",
+	}
+
+	q := url.Values{}
+	setTransportParams(q, ob.Transport)
+	if typ := q.Get("type"); typ != "" {
+		// v2rayN calls the http transport h2 and keeps the grpc service
+		// name in path
+		v["net"] = typ
+		if typ == "http" {
+			v["net"] = "h2"
+		}
+		v["host"] = q.Get("host")
+		v["path"] = cmp.Or(q.Get("path"), q.Get("serviceName"))
+	}
+
+	if tls := ob.TLS; tls != nil {
+		v["tls"] = "tls"
+		v["sni"] = tls.ServerName
+		v["alpn"] = strings.Join(tls.ALPN, ",")
+		if tls.UTLS != nil {
+			v["fp"] = tls.UTLS.Fingerprint
+		}
+		if tls.Insecure {
+			v["allowInsecure"] = "1"
+		}
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return "vmess://" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// clashVLESSLink writes a vless proxy as the link Xray and v2rayN share.
+func clashVLESSLink(p clashProxy, ob *ServerOutbound) string {
+	q := url.Values{"encryption": {"none"}}
+	if flow := p.str("flow"); flow != "" {
+		q.Set("flow", flow)
+	}
+
+	if tls := ob.TLS; tls != nil {
+		q.Set("security", "tls")
+		if tls.ServerName != "" {
+			q.Set("sni", tls.ServerName)
+		}
+		if tls.Insecure {
+			q.Set("allowInsecure", "1")
+		}
+		setALPNParam(q, tls)
+		setUTLSParam(q, tls)
+		setRealityParam(q, tls)
+	}
+	setTransportParams(q, ob.Transport)
+
+	// read back by linkPacketEncodings, the subconverter drops it
+	enc := p.str("packet-encoding")
+	switch {
+	case p.bool("xudp"):
+		enc = "xudp"
+	case p.bool("packet-addr"):
+		enc = "packetaddr"
+	}
+	if enc != "" {
+		q.Set("packetEncoding", enc)
+	}
+
+	u := url.URL{
+		Scheme:   "vless",
+		User:     url.User(p.str("uuid")),
+		Host:     net.JoinHostPort(ob.Server, strconv.Itoa(ob.ServerPort)),
+		RawQuery: q.Encode(),
+		Fragment: ob.Tag,
+	}
+	return u.String()
+}
+
+// clashPlugin maps the plugin of a Clash ss proxy onto the SIP003 plugin
+// string a link carries.
+func clashPlugin(p clashProxy, ob *ServerOutbound) error {
+	opts := p.opts("plugin-opts")
+
+	switch plugin := p.str("plugin"); plugin {
+	case "":
+		return nil
+
+	case "obfs":
+		ob.Plugin = "obfs-local"
+		ob.PluginOpts = "obfs=" + opts.str("mode")
+		if host := opts.str("host"); host != "" {
+			ob.PluginOpts += ";obfs-host=" + host
+		}
+
+	case "v2ray-plugin":
+		ob.Plugin = "v2ray-plugin"
+		parts := []string{"mode=" + cmp.Or(opts.str("mode"), "websocket")}
+		if opts.bool("tls") {
+			parts = append(parts, "tls")
+		}
+		if host := opts.str("host"); host != "" {
+			parts = append(parts, "host="+host)
+		}
+		if path := opts.str("path"); path != "" {
+			parts = append(parts, "path="+path)
+		}
+		ob.PluginOpts = strings.Join(parts, ";")
+
+	case "shadow-tls":
+		var err error
+		// mihomo defaults to version 2
+		ob.ShadowTLS, err = newShadowTLS(cmp.Or(opts.str("version"), "2"), opts.str("password"), opts.str("host"))
+		return err
+
+	default:
+		return fmt.Errorf("unsupported plugin %q", plugin)
+	}
+	return nil
+}
+
+// clashMbps reads a bandwidth such as "100 Mbps" or 100. A missing or
+// unreadable one is left for parseHysteriaURL to reject.
+func clashMbps(v string) int {
+	fields := strings.Fields(v)
+	if len(fields) == 0 {
+		return 0
+	}
+	mbps, _ := strconv.Atoi(strings.TrimSuffix(strings.ToLower(fields[0]), "mbps"))
+	return mbps
+}
+
+func clashTLS(p clashProxy) *TLSOptions {
+	tls := &TLSOptions{
+		Enabled:    true,
+		ServerName: cmp.Or(p.str("sni"), p.str("servername"), p.str("peer")),
+		Insecure:   p.bool("skip-cert-verify"),
+		ALPN:       p.list("alpn"),
+	}
+
+	if fp := p.str("client-fingerprint"); fp != "" {
+		tls.UTLS = parseUTLS(url.Values{"fp": {fp}})
+	}
+	if reality := p.opts("reality-opts"); reality != nil {
+		parseReality(url.Values{"pbk": {reality.str("public-key")}, "sid": {reality.str("short-id")}}, tls)
+	}
+	if ech := p.opts("ech-opts"); ech.bool("enable") {
+		tls.ECH = parseECH(url.Values{"ech": {cmp.Or(ech.str("config"), "1")}})
+	}
+
+	return tls
+}
+
+// clashTransport reads the network of a proxy and its options into the
+// link parameters parseTransport takes.
+func clashTransport(p clashProxy) (*TransportOptions, error) {
+	q := url.Values{}
+
+	switch network := p.str("network"); network {
+	case "", "tcp":
+		return nil, nil
+
+	case "ws":
+		opts := p.opts("ws-opts")
+		q.Set("type", "ws")
+		if opts.bool("v2ray-http-upgrade") {
+			q.Set("type", "httpupgrade")
+		}
+		q.Set("path", opts.str("path"))
+		q.Set("host", opts.opts("headers").str("Host"))
+		if ed := opts.str("max-early-data"); ed != "" && ed != "0" {
+			q.Set("ed", ed)
+			q.Set("eh", opts.str("early-data-header-name"))
+		}
+
+	case "grpc":
+		q.Set("type", "grpc")
+		q.Set("serviceName", p.opts("grpc-opts").str("grpc-service-name"))
+
+	case "h2":
+		opts := p.opts("h2-opts")
+		q.Set("type", "http")
+		q.Set("path", opts.str("path"))
+		q.Set("host", strings.Join(opts.list("host"), ","))
+
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+
+	return parseTransport(q)
+}
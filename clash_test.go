@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const clashTestProxies = `proxies:
+  - name: "SS 01"
+    type: ss
+    server: ss.example.com
+    port: 8388
+    cipher: aes-128-gcm
+    password: pw
+  - name: VMESS 01
+    type: vmess
+    server: vmess.example.com
+    port: 443
+    uuid: 2dd61d93-75d8-4da4-ac0e-6aece7eac365
+    alterId: 0
+    cipher: auto
+    tls: true
+    servername: cdn.example.com
+    network: ws
+    ws-opts:
+      path: /ray
+      headers:
+        Host: cdn.example.com
+  - name: VLESS 01
+    type: vless
+    server: vless.example.com
+    port: 443
+    uuid: 2dd61d93-75d8-4da4-ac0e-6aece7eac365
+    flow: xtls-rprx-vision
+    tls: true
+    servername: www.example.com
+    client-fingerprint: chrome
+    reality-opts: {public-key: pbk, short-id: "01"}
+    xudp: true
+  - name: VLESS 02
+    type: vless
+    server: vless.example.com
+    port: 8443
+    uuid: 2dd61d93-75d8-4da4-ac0e-6aece7eac365
+    network: grpc
+    grpc-opts: {grpc-service-name: svc}
+  - name: VMESS 02
+    type: vmess
+    server: vmess.example.com
+    port: 80
+  - name: SNELL 01
+    type: snell
+    server: snell.example.com
+    port: 443
+    psk: psk
+  - name: DIRECT
+    type: direct
+`
+
+func TestDecodeClash(t *testing.T) {
+	lines, skipped, err := decodeClash([]byte(clashTestProxies))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vmess, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(lines[1], "vmess://"))
+	tests := []struct {
+		name, got, want string
+	}{
+		{"ss", lines[0], "ss://YWVzLTEyOC1nY206cHc@ss.example.com:8388#SS%2001"},
+		{"vmess", string(vmess), `{"add":"vmess.example.com","aid":"0","alpn":"","host":"cdn.example.com","id":"2dd61d93-75d8-4da4-ac0e-6aece7eac365",` +
+			`"net":"ws","path":"/ray","port":"443","ps":"VMESS 01","scy":"auto","sni":"cdn.example.com","tls":"tls","type":"none","v":"2"}`},
+		{"vless reality", lines[2], "vless://2dd61d93-75d8-4da4-ac0e-6aece7eac365@vless.example.com:443?encryption=none&flow=xtls-rprx-vision&fp=chrome&packetEncoding=xudp&pbk=pbk&security=reality&sid=01&sni=www.example.com#VLESS%2001"},
+		{"vless grpc", lines[3], "vless://2dd61d93-75d8-4da4-ac0e-6aece7eac365@vless.example.com:8443?encryption=none&serviceName=svc&type=grpc#VLESS%2002"},
+		{"snell", lines[4], "snell://psk@snell.example.com:443#SNELL%2001"},
+	}
+	if len(lines) != len(tests) {
+		t.Fatalf("decodeClash() = %d links, want %d: %q", len(lines), len(tests), lines)
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, tt.got, tt.want)
+		}
+	}
+
+	wantSkipped := []string{`proxy "VMESS 02": missing uuid`, `proxy "DIRECT": missing server or port`}
+	if strings.Join(skipped, "\n") != strings.Join(wantSkipped, "\n") {
+		t.Errorf("skipped %q, want %q", skipped, wantSkipped)
+	}
+}
+
+// vmess and vless proxies reach the subconverter, and come back as nodes
+// next to the ones msbc reads itself.
+func TestParseSourcesClashSubconverter(t *testing.T) {
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = strings.Split(r.URL.Query().Get("url"), "|")
+		var outbounds []string
+		for _, link := range sent {
+			typ, _, _ := strings.Cut(link, "://")
+			tag := "VMESS 01"
+			if typ == "vless" {
+				tag = "VLESS 0" + fmt.Sprint(len(outbounds))
+			}
+			outbounds = append(outbounds, fmt.Sprintf(`{"type": %q, "tag": %q, "server": "%s.example.com", "server_port": %d}`, typ, tag, typ, 443+len(outbounds)))
+		}
+		fmt.Fprintf(w, `{"outbounds": [%s]}`, strings.Join(outbounds, ","))
+	}))
+	defer srv.Close()
+
+	payloads := []sourcePayload{{Source: SourceConfig{Name: "clash", Format: "clash"}, Body: []byte(clashTestProxies)}}
+	outbounds, err := parseSources(context.Background(), payloads, &SubconverterConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sent) != 3 || !strings.HasPrefix(sent[0], "vmess://") || !strings.HasPrefix(sent[1], "vless://") {
+		t.Errorf("sent %q to the subconverter, want the vmess and vless links", sent)
+	}
+
+	var tags []string
+	for _, ob := range outbounds {
+		tags = append(tags, ob.Type+" "+ob.Tag)
+	}
+	want := "shadowsocks SS 01, snell SNELL 01, vmess VMESS 01, vless VLESS 01, vless VLESS 02"
+	if got := strings.Join(tags, ", "); got != want {
+		t.Errorf("parseSources() = %s, want %s", got, want)
+	}
+	if enc := string(outbounds[3].Extra["packet_encoding"]); enc != `"xudp"` {
+		t.Errorf("VLESS 01 packet_encoding = %s, want the xudp of the proxy", enc)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// completionFlag describes a flag of a command for completion scripts.
+type completionFlag struct {
+	name    string
+	usage   string
+	isBool  bool
+	command string
+}
+
+func completionCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: msbc completion bash|zsh|fish\n\n")
+		fmt.Fprintf(fs.Output(), "bash:  source <(msbc completion bash)\n")
+		fmt.Fprintf(fs.Output(), "zsh:   msbc completion zsh > \"${fpath[1]}/_msbc\"\n")
+		fmt.Fprintf(fs.Output(), "fish:  msbc completion fish > ~/.config/fish/completions/msbc.fish\n")
+	}
+
+	return func(_ context.Context) error {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return fmt.Errorf("expected exactly one shell")
+		}
+
+		switch fs.Arg(0) {
+		case "bash":
+			return writeBashCompletion(os.Stdout)
+		case "zsh":
+			return writeZshCompletion(os.Stdout)
+		case "fish":
+			return writeFishCompletion(os.Stdout)
+		}
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", fs.Arg(0))
+	}
+}
+
+// commandFlags collects the flags of cmd by running its setup against a
+// throwaway flag set, so completions can never drift from the real flags.
+func commandFlags(cmd command) []completionFlag {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	cmd.setup(fs)
+
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			name:    f.Name,
+			usage:   f.Usage,
+			isBool:  ok && b.IsBoolFlag(),
+			command: cmd.name,
+		})
+	})
+	return flags
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+func flagWords(flags []completionFlag) string {
+	words := make([]string, 0, len(flags))
+	for _, f := range flags {
+		words = append(words, "-"+f.name)
+	}
+	return strings.Join(words, " ")
+}
+
+func writeBashCompletion(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("# bash completion for msbc\n")
+	b.WriteString("_msbc() {\n")
+	b.WriteString("\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("\tif [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(&b, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(commandNames(), " ")+" "+flagWords(commandFlags(commands[0])))
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("\tcase \"${COMP_WORDS[1]}\" in\n")
+	for _, cmd := range commands {
+		words := strings.TrimSpace(strings.Join(cmd.args, " ") + " " + flagWords(commandFlags(cmd)))
+		fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", cmd.name, words)
+	}
+	fmt.Fprintf(&b, "\t*) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", flagWords(commandFlags(commands[0])))
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n")
+	b.WriteString("complete -o default -F _msbc msbc\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeZshCompletion(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("#compdef msbc\n\n")
+	b.WriteString("_msbc() {\n")
+	b.WriteString("\tlocal -a commands\n")
+	b.WriteString("\tcommands=(\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "\t\t'%s:%s'\n", cmd.name, zshEscape(cmd.summary))
+	}
+	b.WriteString("\t)\n\n")
+	b.WriteString("\tif (( CURRENT == 2 )); then\n")
+	b.WriteString("\t\t_describe 'command' commands\n")
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\tfi\n\n")
+	b.WriteString("\tlocal cmd=$words[2]\n")
+	b.WriteString("\tshift words\n")
+	b.WriteString("\t(( CURRENT-- ))\n\n")
+	b.WriteString("\tcase $cmd in\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "\t%s)\n\t\t_arguments \\\n", cmd.name)
+		for _, f := range commandFlags(cmd) {
+			spec := fmt.Sprintf("-%s[%s]", f.name, zshEscape(f.usage))
+			if !f.isBool {
+				spec += ":value:_files"
+			}
+			fmt.Fprintf(&b, "\t\t\t'%s' \\\n", spec)
+		}
+		if len(cmd.args) > 0 {
+			fmt.Fprintf(&b, "\t\t\t'*:argument:(%s)'\n\t\t;;\n", strings.Join(cmd.args, " "))
+		} else {
+			b.WriteString("\t\t\t'*:file:_files'\n\t\t;;\n")
+		}
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n\n")
+	b.WriteString("if [ \"$funcstack[1]\" = \"_msbc\" ]; then\n")
+	b.WriteString("\t_msbc \"$@\"\n")
+	b.WriteString("else\n")
+	b.WriteString("\tcompdef _msbc msbc\n")
+	b.WriteString("fi\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeFishCompletion(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("# fish completion for msbc\n")
+	b.WriteString("complete -c msbc -f\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "complete -c msbc -n '__fish_use_subcommand' -a %s -d '%s'\n", cmd.name, fishEscape(cmd.summary))
+	}
+
+	for _, cmd := range commands {
+		if len(cmd.args) > 0 {
+			fmt.Fprintf(&b, "complete -c msbc -n '__fish_seen_subcommand_from %s' -a '%s'\n", cmd.name, strings.Join(cmd.args, " "))
+		}
+
+		for _, f := range commandFlags(cmd) {
+			line := fmt.Sprintf("complete -c msbc -n '__fish_seen_subcommand_from %s' -o %s -d '%s'", cmd.name, f.name, fishEscape(f.usage))
+			if !f.isBool {
+				line += " -r -F"
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func zshEscape(s string) string {
+	r := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:")
+	return r.Replace(s)
+}
+
+func fishEscape(s string) string {
+	return strings.ReplaceAll(s, "'", "\\'")
+}
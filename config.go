@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the optional msbc.json file. Everything in it has a default, so
+// a missing file behaves like the original environment-only setup.
+type Config struct {
+	Sources        []SourceConfig       `json:"sources,omitempty"`
+	UserAgent      string               `json:"user_agent,omitempty"`
+	Fetch          FetchConfig          `json:"fetch"`
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker"`
+	Retry          RetryConfig          `json:"retry"`
+	Cache          CacheConfig          `json:"cache"`
+	Expiry         ExpiryConfig         `json:"expiry"`
+	URLTest        URLTestConfig        `json:"urltest"`
+	Export         ExportConfig         `json:"export"`
+	Resolve        ResolveConfig        `json:"resolve"`
+	Filter         FilterConfig         `json:"filter"`
+	Log            LogConfig            `json:"log"`
+	Subconverter   SubconverterConfig   `json:"subconverter"`
+	RoutingMark    RoutingMarkConfig    `json:"routing_mark"`
+	Git            GitConfig            `json:"git"`
+	Encrypt        EncryptConfig        `json:"encrypt"`
+	Fleet          []FleetHost          `json:"fleet,omitempty"`
+	Latency        LatencyConfig        `json:"latency"`
+	GRPC           GRPCConfig           `json:"grpc"`
+	TLS            TLSConfig            `json:"tls"`
+	Multiplex      MultiplexOptions     `json:"multiplex"`
+	// PacketEncoding sets packet_encoding on vmess and vless nodes; the
+	// first rule whose Match regexp matches the tag applies.
+	PacketEncoding []PacketEncodingRule `json:"packet_encoding,omitempty"`
+	// WireGuard lists files of WireGuard configs, in INI format or as
+	// wireguard:// links, whose peers join the nodes of the sources.
+	WireGuard []string `json:"wireguard,omitempty"`
+}
+
+// URLTestConfig is copied into every generated urltest group; zero values
+// keep the sing-box defaults.
+type URLTestConfig struct {
+	Interval  Duration `json:"interval,omitempty"`
+	Tolerance int      `json:"tolerance,omitempty"`
+}
+
+// ExportConfig holds defaults for flags of the same name. An explicit
+// -export-dir wins over Dir.
+type ExportConfig struct {
+	Dir string `json:"dir,omitempty"`
+}
+
+// SourceConfig describes one subscription. When two sources provide the
+// same server, the node from the source with the higher priority is kept.
+type SourceConfig struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Priority int      `json:"priority,omitempty"`
+	Enabled  *bool    `json:"enabled,omitempty"`
+	Interval Duration `json:"interval,omitempty"`
+	// Mirrors are tried in order when URL fails or answers with a body
+	// that does not decode into nodes.
+	Mirrors []string `json:"mirrors,omitempty"`
+	// Format pins the format of the body, one of sourceFormats, for
+	// providers whose body is detected wrongly. Empty detects it.
+	Format string `json:"format,omitempty"`
+	// UserAgent overrides the global one, for panels that pick the format
+	// of their response by it or block unknown clients.
+	UserAgent string `json:"user_agent,omitempty"`
+	// BearerToken is sent as "Authorization: Bearer <token>", for private
+	// panels that take the token in a header rather than the url.
+	BearerToken string `json:"bearer_token,omitempty"`
+	// Headers are sent with every request, e.g. Cookie or X-Api-Key, and
+	// win over UserAgent and BearerToken.
+	Headers map[string]string `json:"headers,omitempty"`
+	// TagPrefix is put in front of the tag of every node of the source.
+	TagPrefix string `json:"tag_prefix,omitempty"`
+	// Include and Exclude are regexps matched against the tags of the
+	// source's nodes, prefix included. A node is kept when it matches
+	// Include, if set, and does not match Exclude.
+	Include string `json:"include,omitempty"`
+	Exclude string `json:"exclude,omitempty"`
+}
+
+// enabled reports whether the source should be used; sources are enabled
+// unless explicitly turned off.
+func (s *SourceConfig) enabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// CircuitBreakerConfig controls when a failing source stops being fetched.
+// After Threshold consecutive failures the source is skipped until Cooldown
+// has passed, then a single recovery fetch decides whether it comes back.
+// A threshold of 0 disables the breaker.
+type CircuitBreakerConfig struct {
+	Threshold int      `json:"threshold"`
+	Cooldown  Duration `json:"cooldown"`
+}
+
+// CacheConfig controls the cached subscriptions. A source that cannot be
+// fetched falls back to its cache while that is younger than MaxAge; zero
+// disables the fallback.
+type CacheConfig struct {
+	MaxAge Duration `json:"max_age"`
+}
+
+// ExpiryConfig sets when a build warns about a plan running out, based on
+// what the provider reports. Zero disables the respective warning. With
+// SuppressExhausted, nodes of a source without traffic left are dropped
+// until the provider reports a reset. With AbortExpired, a build fails
+// while any source reports an expired plan.
+type ExpiryConfig struct {
+	WarnDays          int      `json:"warn_days"`
+	WarnTraffic       ByteSize `json:"warn_traffic"`
+	SuppressExhausted bool     `json:"suppress_exhausted,omitempty"`
+	AbortExpired      bool     `json:"abort_expired,omitempty"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		CircuitBreaker: CircuitBreakerConfig{
+			Threshold: 3,
+			Cooldown:  Duration(time.Hour),
+		},
+		Fetch: defaultFetchConfig(),
+		Retry: defaultRetryConfig(),
+		Cache: CacheConfig{
+			MaxAge: Duration(72 * time.Hour),
+		},
+		Expiry: ExpiryConfig{
+			WarnDays:    7,
+			WarnTraffic: 1 << 30,
+		},
+		Filter: FilterConfig{
+			PrivateAddresses: "warn",
+			Confusables:      "drop",
+		},
+	}
+}
+
+// Duration is a time.Duration written as a string such as "90s" or "6h".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("expected duration string such as \"30s\" or \"1h\"")
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// ByteSize is a byte count written as a string such as "512MB" or "10GiB".
+// Units are binary either way, matching how providers count traffic.
+type ByteSize int64
+
+var byteUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+
+func (b ByteSize) String() string {
+	v := float64(b)
+	i := 0
+	for v >= 1024 && i < len(byteUnits)-1 {
+		v /= 1024
+		i++
+	}
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64) + byteUnits[i]
+}
+
+func (b ByteSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("expected size string such as \"512MB\" or \"10GB\"")
+	}
+
+	v, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+
+	*b = v
+	return nil
+}
+
+func parseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	unit := strings.ToUpper(strings.TrimSpace(s[i:]))
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+
+	shift, ok := map[string]uint{"": 0, "K": 10, "M": 20, "G": 30, "T": 40, "P": 50}[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", s)
+	}
+	return ByteSize(n * float64(uint64(1)<<shift)), nil
+}
+
+// configOptions selects the config file and the layers applied on top of it.
+type configOptions struct {
+	Path string
+	Env  string
+	UCI  string
+	Set  overrides
+}
+
+func registerConfigFlags(fs *flag.FlagSet, opts *configOptions) {
+	fs.StringVar(&opts.Path, "config", "msbc.json", "path of the optional msbc config file")
+	fs.StringVar(&opts.Env, "env", "", "merge the overlay msbc.<env>.json next to the config file over it, e.g. home or travel")
+	fs.StringVar(&opts.UCI, "uci", defaultUCIPath(), "read options and sources from this uci config, e.g. /etc/config/msbc (default on OpenWrt)")
+	fs.Var(&opts.Set, "set", "override a config `key=value`, e.g. urltest.interval=1m (repeatable)")
+}
+
+// overlayPath returns the overlay file of env next to path, so msbc.json with
+// env "travel" becomes msbc.travel.json.
+func overlayPath(path, env string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + env + ext
+}
+
+// loadConfig reads the config file on top of the defaults, then merges the
+// environment overlay (an RFC 7386 merge patch), the uci config and the -set
+// overrides over it. A missing config file is not an error, a missing overlay is. Unknown
+// keys are errors too, since they are almost always typos.
+func loadConfig(opts *configOptions) (*Config, error) {
+	cfg := defaultConfig()
+	path := opts.Path
+
+	// ${VAR} references are expanded in every file layer alike, the base
+	// as well as the -env overlay
+	data, err := readTemplate(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		data = []byte("{}")
+	}
+
+	doc, err := decodeDocument(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decodeConfig(path, data, cfg); err != nil {
+		return nil, err
+	}
+
+	// each layer is decoded on its own so errors name the layer that
+	// introduced them
+	relayer := func(label string) error {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+
+		cfg = defaultConfig()
+		path = label
+		return decodeConfig(label, data, cfg)
+	}
+
+	if opts.Env != "" {
+		overlay := overlayPath(opts.Path, opts.Env)
+
+		data, err := readTemplate(overlay)
+		if err != nil {
+			return nil, fmt.Errorf("environment %s: %v", opts.Env, err)
+		}
+		patch, err := decodeDocument(overlay, data)
+		if err != nil {
+			return nil, err
+		}
+
+		doc = mergePatch(doc, patch)
+		if err := relayer(overlay); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.UCI != "" {
+		patch, err := uciConfigLayer(opts.UCI)
+		if err != nil {
+			return nil, err
+		}
+
+		doc = mergePatch(doc, patch)
+		if err := relayer(opts.UCI); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(opts.Set) > 0 {
+		const label = "-set"
+
+		if err := opts.Set.apply(label, doc); err != nil {
+			return nil, err
+		}
+		if err := relayer(label); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.validate(path); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func decodeConfig(path string, data []byte, cfg *Config) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return configError(path, err)
+	}
+	return nil
+}
+
+// overrides collects repeated -set key=value flags. Keys are dotted paths
+// into msbc.json such as urltest.interval or sources.0.url; values are read
+// as JSON when they parse as such and as strings otherwise.
+type overrides []string
+
+func (o *overrides) String() string {
+	return strings.Join(*o, " ")
+}
+
+func (o *overrides) Set(v string) error {
+	if key, _, ok := strings.Cut(v, "="); !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", v)
+	}
+	*o = append(*o, v)
+	return nil
+}
+
+func (o overrides) apply(label string, doc any) error {
+	var errs ValidationErrors
+
+	for _, set := range o {
+		key, raw, _ := strings.Cut(set, "=")
+
+		var value any
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			value = raw
+		}
+
+		if pointer, err := setPath(doc, strings.Split(key, "."), value); err != nil {
+			errs.add(label, pointer, nil, "%s: %v", key, err)
+		}
+	}
+
+	return errs.Err()
+}
+
+// setPath stores value at the dotted path parts inside doc, creating
+// intermediate objects as needed. On failure it returns the pointer of the
+// node it could not descend into.
+func setPath(doc any, parts []string, value any) (string, error) {
+	pointer := ""
+
+	for i, part := range parts {
+		last := i == len(parts)-1
+
+		switch v := doc.(type) {
+		case map[string]any:
+			if last {
+				v[part] = value
+				return "", nil
+			}
+			if _, ok := v[part]; !ok {
+				v[part] = make(map[string]any)
+			}
+			doc = v[part]
+		case []any:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return pointer, fmt.Errorf("no element %q", part)
+			}
+			if last {
+				v[idx] = value
+				return "", nil
+			}
+			doc = v[idx]
+		default:
+			return pointer, fmt.Errorf("cannot set a field inside %s", jsonTypeName(doc))
+		}
+
+		pointer = pointerJoin(pointer, part)
+	}
+
+	return "", nil
+}
+
+// configError turns errors from typed decoding into ValidationErrors with a
+// JSON pointer where encoding/json tells us the location.
+func configError(path string, err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return ValidationErrors{{
+			File:    path,
+			Pointer: fieldPointer(typeErr.Field),
+			Value:   typeErr.Value,
+			Message: "expected " + typeErr.Type.String(),
+		}}
+	}
+
+	msg := err.Error()
+	if field, ok := strings.CutPrefix(msg, "json: unknown field "); ok {
+		return ValidationErrors{{File: path, Value: field, Message: "unknown field"}}
+	}
+
+	return ValidationErrors{{File: path, Message: strings.TrimPrefix(msg, "json: ")}}
+}
+
+// fieldPointer converts encoding/json's dotted field path ("sources.0.url")
+// into a JSON pointer.
+func fieldPointer(field string) string {
+	if field == "" {
+		return ""
+	}
+
+	pointer := ""
+	for _, part := range strings.Split(field, ".") {
+		pointer = pointerJoin(pointer, part)
+	}
+	return pointer
+}
+
+func (cfg *Config) validate(path string) error {
+	var errs ValidationErrors
+	seen := make(map[string]bool)
+
+	for i, src := range cfg.Sources {
+		pointer := pointerJoin("/sources", i)
+
+		if src.Name == "" {
+			errs.add(path, pointerJoin(pointer, "name"), nil, "missing source name")
+		} else if seen[src.Name] {
+			errs.add(path, pointerJoin(pointer, "name"), src.Name, "duplicate source name")
+		}
+		seen[src.Name] = true
+
+		if src.URL == "" {
+			errs.add(path, pointerJoin(pointer, "url"), nil, "missing source url")
+		}
+		for j, mirror := range src.Mirrors {
+			if mirror == "" {
+				errs.add(path, pointerJoin(pointerJoin(pointer, "mirrors"), j), nil, "missing mirror url")
+			}
+		}
+		if src.Format != "" && !slices.Contains(sourceFormats, src.Format) {
+			errs.add(path, pointerJoin(pointer, "format"), src.Format, "expected one of %s", strings.Join(sourceFormats, ", "))
+		}
+		for name := range src.Headers {
+			if name == "" || strings.ContainsAny(name, " :\r\n") {
+				errs.add(path, pointerJoin(pointer, "headers"), name, "invalid header name")
+			}
+		}
+		if _, err := regexp.Compile(src.Include); err != nil {
+			errs.add(path, pointerJoin(pointer, "include"), src.Include, "invalid regexp: %v", err)
+		}
+		if _, err := regexp.Compile(src.Exclude); err != nil {
+			errs.add(path, pointerJoin(pointer, "exclude"), src.Exclude, "invalid regexp: %v", err)
+		}
+	}
+
+	if cfg.CircuitBreaker.Threshold < 0 {
+		errs.add(path, "/circuit_breaker/threshold", cfg.CircuitBreaker.Threshold, "must not be negative")
+	}
+	for _, d := range []struct {
+		key   string
+		value Duration
+	}{
+		{"timeout", cfg.Fetch.Timeout},
+		{"connect_timeout", cfg.Fetch.ConnectTimeout},
+		{"response_timeout", cfg.Fetch.ResponseTimeout},
+		{"keep_alive", cfg.Fetch.KeepAlive},
+	} {
+		if d.value < 0 {
+			errs.add(path, "/fetch/"+d.key, d.value, "must not be negative")
+		}
+	}
+	if cfg.Fetch.MaxRedirects < 0 {
+		errs.add(path, "/fetch/max_redirects", cfg.Fetch.MaxRedirects, "must not be negative")
+	}
+	if doh := cfg.Fetch.DoH; doh != "" {
+		if u, err := url.Parse(doh); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs.add(path, "/fetch/doh", doh, "expected an https url such as https://1.1.1.1/dns-query")
+		}
+	}
+	if cfg.Fetch.Concurrency < 1 {
+		errs.add(path, "/fetch/concurrency", cfg.Fetch.Concurrency, "must be at least 1")
+	}
+	if cfg.Cache.MaxAge < 0 {
+		errs.add(path, "/cache/max_age", cfg.Cache.MaxAge, "must not be negative")
+	}
+	if cfg.Retry.Attempts < 1 {
+		errs.add(path, "/retry/attempts", cfg.Retry.Attempts, "must be at least 1")
+	}
+	if cfg.Retry.Backoff < 0 {
+		errs.add(path, "/retry/backoff", cfg.Retry.Backoff, "must not be negative")
+	}
+	if cfg.Retry.MaxBackoff < cfg.Retry.Backoff {
+		errs.add(path, "/retry/max_backoff", cfg.Retry.MaxBackoff, "must not be below backoff")
+	}
+	for i, code := range cfg.Retry.Statuses {
+		if code < 100 || code > 599 {
+			errs.add(path, pointerJoin("/retry/statuses", i), code, "expected an HTTP status code")
+		}
+	}
+	if !slices.Contains(resolveFamilies, cfg.Resolve.Family) {
+		errs.add(path, "/resolve/family", cfg.Resolve.Family, "expected ipv4, ipv6, prefer-ipv4 or prefer-ipv6")
+	}
+	if !slices.Contains(filterActions, cfg.Filter.PrivateAddresses) {
+		errs.add(path, "/filter/private_addresses", cfg.Filter.PrivateAddresses, "expected drop, warn or allow")
+	}
+	if !slices.Contains(filterActions, cfg.Filter.Confusables) {
+		errs.add(path, "/filter/confusables", cfg.Filter.Confusables, "expected drop, warn or allow")
+	}
+	for i, entry := range cfg.Filter.DenyHosts {
+		if strings.Trim(entry, "*.") == "" {
+			errs.add(path, pointerJoin("/filter/deny_hosts", i), entry, "expected a hostname or domain")
+		}
+	}
+	if !validLogOutput(cfg.Log.Output) {
+		errs.add(path, "/log/output", cfg.Log.Output, "expected stderr, syslog or journald")
+	}
+	if cfg.Expiry.WarnDays < 0 {
+		errs.add(path, "/expiry/warn_days", cfg.Expiry.WarnDays, "must not be negative")
+	}
+	if dir := cfg.Git.Dir; filepath.IsAbs(dir) || !filepath.IsLocal(cmp.Or(dir, ".")) {
+		errs.add(path, "/git/dir", dir, "expected a relative directory inside the repository")
+	}
+	for i, tier := range cfg.Latency.Tiers {
+		pointer := pointerJoin("/latency/tiers", i)
+		if tier.MaxMS <= 0 {
+			errs.add(path, pointerJoin(pointer, "max_ms"), tier.MaxMS, "must be positive")
+		} else if i > 0 && tier.MaxMS <= cfg.Latency.Tiers[i-1].MaxMS {
+			errs.add(path, pointerJoin(pointer, "max_ms"), tier.MaxMS, "tiers must be ordered from fastest to slowest")
+		}
+		if tier.Marker == "" {
+			errs.add(path, pointerJoin(pointer, "marker"), nil, "missing marker")
+		}
+	}
+	if fp := cfg.TLS.Fingerprint; fp != "" && !slices.Contains(utlsFingerprints, fp) {
+		errs.add(path, "/tls/fingerprint", fp, "expected one of %s", strings.Join(utlsFingerprints, ", "))
+	}
+	for i, rule := range cfg.TLS.Fragment {
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			errs.add(path, pointerJoin(pointerJoin("/tls/fragment", i), "match"), rule.Match, "invalid regexp: %v", err)
+		}
+	}
+	for i, rule := range cfg.PacketEncoding {
+		pointer := pointerJoin("/packet_encoding", i)
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			errs.add(path, pointerJoin(pointer, "match"), rule.Match, "invalid regexp: %v", err)
+		}
+		if !slices.Contains(packetEncodings, rule.Encoding) {
+			errs.add(path, pointerJoin(pointer, "encoding"), rule.Encoding, "expected packetaddr or xudp")
+		}
+	}
+	if p := cfg.Multiplex.Protocol; p != "" && !slices.Contains(multiplexProtocols, p) {
+		errs.add(path, "/multiplex/protocol", p, "expected smux, yamux or h2mux")
+	}
+	if b := cfg.Multiplex.Brutal; b != nil && b.Enabled && (b.UpMbps <= 0 || b.DownMbps <= 0) {
+		errs.add(path, "/multiplex/brutal", nil, "up_mbps and down_mbps must be positive")
+	}
+	if cfg.Latency.FastMaxMS < 0 {
+		errs.add(path, "/latency/fast_max_ms", cfg.Latency.FastMaxMS, "must not be negative")
+	}
+	hosts := make(map[string]bool)
+	for i, host := range cfg.Fleet {
+		pointer := pointerJoin("/fleet", i)
+
+		if host.Name == "" {
+			errs.add(path, pointerJoin(pointer, "name"), nil, "missing host name")
+		} else if hosts[host.Name] {
+			errs.add(path, pointerJoin(pointer, "name"), host.Name, "duplicate host name")
+		}
+		hosts[host.Name] = true
+
+		if host.SSH == "" {
+			errs.add(path, pointerJoin(pointer, "ssh"), nil, "missing ssh address such as root@192.168.1.1")
+		}
+		if host.Port < 0 || host.Port > 65535 {
+			errs.add(path, pointerJoin(pointer, "port"), host.Port, "expected a port between 1 and 65535")
+		}
+	}
+	if tool := cfg.Encrypt.Tool; tool != "" && !slices.Contains(encryptTools, tool) {
+		errs.add(path, "/encrypt/tool", tool, "expected age or gpg")
+	}
+	if cfg.Encrypt.Dir != "" && len(cfg.Encrypt.Recipients) == 0 {
+		errs.add(path, "/encrypt/recipients", nil, "at least one recipient is required with encrypt.dir")
+	}
+	if sc := cfg.Subconverter.URL; sc != "" {
+		if u, err := url.Parse(sc); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add(path, "/subconverter/url", sc, "expected an http or https url")
+		}
+	}
+
+	return errs.Err()
+}
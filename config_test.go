@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigExpandsEnv(t *testing.T) {
+	t.Setenv("MSBC_TEST_TOKEN", "secret")
+	t.Setenv("MSBC_TEST_URL", "https://example.com/sub")
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "msbc.json")
+	files := map[string]string{
+		"msbc.json": `{"sources": [{"name": "paid", "url": "${MSBC_TEST_URL}", "bearer_token": "${MSBC_TEST_TOKEN}"}],
+			"expiry": {"warn_days": ${MSBC_TEST_DAYS:-5}}, "filter": {"deny_hosts": ["$${literal}"]}}`,
+		"msbc.travel.json": `{"user_agent": "${MSBC_TEST_TOKEN}-agent"}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := loadConfig(&configOptions{Path: path, Env: "travel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := cfg.Sources[0]
+	if src.URL != "https://example.com/sub" || src.BearerToken != "secret" {
+		t.Errorf("base layer not expanded: %+v", src)
+	}
+	if cfg.Expiry.WarnDays != 5 {
+		t.Errorf("warn_days = %d, want the default 5", cfg.Expiry.WarnDays)
+	}
+	if cfg.Filter.DenyHosts[0] != "${literal}" {
+		t.Errorf("deny_hosts = %q, want the escaped ${literal}", cfg.Filter.DenyHosts)
+	}
+	if cfg.UserAgent != "secret-agent" {
+		t.Errorf("overlay not expanded: user_agent = %q", cfg.UserAgent)
+	}
+}
+
+func TestLoadConfigUnsetEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "msbc.json")
+	if err := os.WriteFile(path, []byte(`{"user_agent": "${MSBC_TEST_UNSET}"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadConfig(&configOptions{Path: path}); err == nil {
+		t.Error("loadConfig() accepted a reference to an unset variable")
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// format is a representation of a node list that msbc can read, write or
+// both. Either function may be nil when the direction is not supported.
+type format struct {
+	name   string
+	decode func(data []byte) ([]ServerOutbound, error)
+	encode func(outbounds []ServerOutbound) ([]byte, error)
+}
+
+var formats = []format{
+	{name: "base64", decode: decodeBase64Links, encode: encodeBase64Links},
+	{name: "links", decode: decodeLinks, encode: encodeLinks},
+	{name: "sing-box", decode: decodeSingBox, encode: encodeSingBox},
+	{name: "clash", decode: decodeClashProxies},
+}
+
+func findFormat(name string) (*format, error) {
+	names := make([]string, 0, len(formats))
+	for i := range formats {
+		if formats[i].name == name {
+			return &formats[i], nil
+		}
+		names = append(names, formats[i].name)
+	}
+	return nil, fmt.Errorf("unknown format %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+type convertOptions struct {
+	From   string
+	To     string
+	Input  string
+	Output string
+}
+
+func convertCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	opts := &convertOptions{}
+
+	fs.StringVar(&opts.From, "from", "base64", "input format")
+	fs.StringVar(&opts.To, "to", "sing-box", "output format")
+	fs.StringVar(&opts.Input, "in", "-", "input file, - for stdin")
+	fs.StringVar(&opts.Output, "out", "-", "output file, - for stdout")
+
+	return func(_ context.Context) error {
+		return convert(opts)
+	}
+}
+
+func convert(opts *convertOptions) error {
+	from, err := findFormat(opts.From)
+	if err != nil {
+		return err
+	}
+	if from.decode == nil {
+		return fmt.Errorf("format %s cannot be read", from.name)
+	}
+
+	to, err := findFormat(opts.To)
+	if err != nil {
+		return err
+	}
+	if to.encode == nil {
+		return fmt.Errorf("format %s cannot be written", to.name)
+	}
+
+	var data []byte
+	if opts.Input == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(opts.Input)
+	}
+	if err != nil {
+		return err
+	}
+
+	outbounds, err := from.decode(data)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("converting %d nodes from %s to %s", len(outbounds), from.name, to.name)
+
+	out, err := to.encode(outbounds)
+	if err != nil {
+		return err
+	}
+
+	if opts.Output == "-" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return writeFileAtomic(opts.Output, out, 0644)
+}
+
+func decodeBase64Links(data []byte) ([]ServerOutbound, error) {
+	lines, err := decodeSubscription(data, "")
+	if err != nil {
+		return nil, err
+	}
+	return parseOutbounds(lines), nil
+}
+
+func encodeBase64Links(outbounds []ServerOutbound) ([]byte, error) {
+	links, err := encodeLinks(outbounds)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(links)), nil
+}
+
+func decodeLinks(data []byte) ([]ServerOutbound, error) {
+	return parseOutbounds(strings.Split(string(data), "\n")), nil
+}
+
+func encodeLinks(outbounds []ServerOutbound) ([]byte, error) {
+	var b strings.Builder
+	for _, ob := range outbounds {
+		link, err := shareLink(&ob)
+		if err != nil {
+			log.Printf("skipping %q: %v", ob.Tag, err)
+			continue
+		}
+		b.WriteString(link)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String()), nil
+}
+
+func decodeClashProxies(data []byte) ([]ServerOutbound, error) {
+	lines, skipped, err := decodeClash(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, reason := range skipped {
+		log.Printf("skipping clash %s", reason)
+	}
+	return parseOutbounds(lines), nil
+}
+
+// decodeSingBox reads either an {"outbounds": [...]} document or a bare
+// array, keeping the outbounds that describe servers msbc understands.
+func decodeSingBox(data []byte) ([]ServerOutbound, error) {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		var doc SelectorsConfig
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		raws = doc.Outbounds
+	}
+
+	outbounds := make([]ServerOutbound, 0, len(raws))
+	wrappers := make(map[string]shadowTLSOutbound)
+
+	for _, raw := range raws {
+		var base BaseOutbound
+		if err := json.Unmarshal(raw, &base); err != nil {
+			return nil, err
+		}
+
+		// folded back into the node that detours through it below
+		if base.Type == "shadowtls" {
+			var st shadowTLSOutbound
+			if err := json.Unmarshal(raw, &st); err != nil {
+				return nil, err
+			}
+			wrappers[st.Tag] = st
+			continue
+		}
+
+		if !supportedTypes[base.Type] {
+			log.Printf("skipping %s outbound %q", base.Type, base.Tag)
+			continue
+		}
+
+		var ob ServerOutbound
+		if err := json.Unmarshal(raw, &ob); err != nil {
+			return nil, err
+		}
+
+		ob.ID = nodeID(ob.Type, ob.Server, ob.ServerPort, ob.credential())
+		ob.Endpoint = ob.Type == "wireguard"
+		outbounds = append(outbounds, ob)
+	}
+
+	for i := range outbounds {
+		ob := &outbounds[i]
+		if ob.Detour == "" {
+			continue
+		}
+		st, ok := wrappers[ob.Detour]
+		if !ok {
+			log.Printf("%s: dropping unknown detour %q", ob.Tag, ob.Detour)
+			ob.Detour = ""
+			continue
+		}
+		ob.ShadowTLS = &ShadowTLSOptions{Version: st.Version, Password: st.Password}
+		if st.TLS != nil {
+			ob.ShadowTLS.Host = st.TLS.ServerName
+		}
+		ob.Detour = ""
+	}
+
+	return outbounds, nil
+}
+
+func encodeSingBox(outbounds []ServerOutbound) ([]byte, error) {
+	kept := make([]ServerOutbound, 0, len(outbounds))
+	for _, ob := range outbounds {
+		if reason, ok := unrepresentable[ob.Type]; ok {
+			log.Printf("skipping %q: %s", ob.Tag, reason)
+			continue
+		}
+		kept = append(kept, ob)
+	}
+
+	servers, endpoints := splitEndpoints(kept)
+	data, err := json.MarshalIndent(ServersConfig{Outbounds: servers, Endpoints: endpoints}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// setECHParam is the inverse of parseECH. A config_path cannot travel in a
+// link, the node falls back to the DNS lookup.
+func setECHParam(q url.Values, tls *TLSOptions) {
+	if tls.ECH != nil && tls.ECH.Enabled {
+		q.Set("ech", echLinkValue(tls.ECH))
+	}
+}
+
+func echLinkValue(ech *ECHOptions) string {
+	var b strings.Builder
+	for _, line := range ech.Config {
+		if !strings.HasPrefix(line, "-----") {
+			b.WriteString(strings.TrimSpace(line))
+		}
+	}
+	if b.Len() == 0 {
+		return "1"
+	}
+	return b.String()
+}
+
+// shareLink is the inverse of parseShareLink.
+func shareLink(ob *ServerOutbound) (string, error) {
+	switch ob.Type {
+	case "trojan":
+		return trojanLink(ob), nil
+	case "shadowsocks":
+		return shadowsocksLink(ob), nil
+	case "shadowsocksr":
+		return shadowsocksRLink(ob), nil
+	case "hysteria":
+		return hysteriaLink(ob), nil
+	case "hysteria2":
+		return hysteria2Link(ob), nil
+	case "tuic":
+		return tuicLink(ob), nil
+	case "wireguard":
+		return wireguardLink(ob), nil
+	case "socks":
+		return socksLink(ob), nil
+	case "http":
+		return httpProxyLink(ob), nil
+	case "naive":
+		return naiveLink(ob), nil
+	case "anytls":
+		return anyTLSLink(ob), nil
+	case "ssh":
+		return sshLink(ob), nil
+	case "snell":
+		return snellLink(ob), nil
+	case "mieru":
+		return mieruLink(ob), nil
+	}
+	return "", fmt.Errorf("no share link format for %s outbounds", ob.Type)
+}
+
+// trojanLink is the inverse of parseTrojanURL.
+func trojanLink(ob *ServerOutbound) string {
+	q := url.Values{}
+	if tls := ob.TLS; tls != nil {
+		if tls.ServerName != "" {
+			q.Set("sni", tls.ServerName)
+		}
+		if tls.Insecure {
+			q.Set("allowInsecure", "1")
+		}
+		setALPNParam(q, tls)
+		setECHParam(q, tls)
+		setUTLSParam(q, tls)
+		setRealityParam(q, tls)
+	}
+	setTransportParams(q, ob.Transport)
+	setShadowTLSParam(q, ob.ShadowTLS)
+
+	u := url.URL{
+		Scheme:   "trojan",
+		User:     url.User(ob.Password),
+		Host:     net.JoinHostPort(ob.Server, strconv.Itoa(ob.ServerPort)),
+		RawQuery: q.Encode(),
+		Fragment: ob.Tag,
+	}
+	return u.String()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeSingBox(t *testing.T) {
+	doc := `{"outbounds": [
+		{"type": "selector", "tag": "proxy", "outbounds": ["JP 01"]},
+		{"type": "trojan", "tag": "JP 01", "server": "jp.example.com", "server_port": 443, "password": "pw", "tls": {"enabled": true}},
+		{"type": "vmess", "tag": "US 01", "server": "us.example.com", "server_port": 443, "uuid": "id"},
+		{"type": "hysteria2", "tag": "HK 01", "server": "hk.example.com", "server_port": 8443, "password": "pw"},
+		{"type": "direct", "tag": "direct"}
+	]}`
+
+	for _, data := range []string{doc, doc[strings.IndexByte(doc, '[') : strings.LastIndexByte(doc, ']')+1]} {
+		outbounds, err := decodeSingBox([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var tags []string
+		for _, ob := range outbounds {
+			tags = append(tags, ob.Tag)
+			if ob.ID == "" {
+				t.Errorf("%s has no id", ob.Tag)
+			}
+		}
+		if want := []string{"JP 01", "HK 01"}; !slices.Equal(tags, want) {
+			t.Errorf("decodeSingBox() kept %v, want %v", tags, want)
+		}
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	links := "trojan://pw@jp.example.com:443?sni=jp.example.com#JP%2001\n" +
+		"ss://YWVzLTEyOC1nY206cHc@us.example.com:8388#US%2001\n" +
+		"hysteria2://pw@hk.example.com:8443?sni=hk.example.com#HK%2001\n"
+
+	outbounds, err := decodeLinks([]byte(links))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbounds) != 3 {
+		t.Fatalf("decodeLinks() = %d nodes, want 3", len(outbounds))
+	}
+
+	data, err := encodeSingBox(outbounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeSingBox(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded) != len(outbounds) {
+		t.Fatalf("sing-box round trip kept %d of %d nodes", len(decoded), len(outbounds))
+	}
+	for i := range outbounds {
+		if decoded[i].ID != outbounds[i].ID || decoded[i].Tag != outbounds[i].Tag {
+			t.Errorf("node %d: got %s %q, want %s %q", i, decoded[i].ID, decoded[i].Tag, outbounds[i].ID, outbounds[i].Tag)
+		}
+	}
+}
+
+// Every type the link parsers produce is either read back by convert or
+// one sing-box cannot represent.
+func TestSupportedTypes(t *testing.T) {
+	for _, typ := range []string{"trojan", "shadowsocks", "hysteria2", "tuic", "wireguard", "socks", "http", "naive", "anytls", "ssh"} {
+		if !supportedTypes[typ] {
+			t.Errorf("%s is missing from supportedTypes", typ)
+		}
+	}
+	for typ := range unrepresentable {
+		if supportedTypes[typ] {
+			t.Errorf("%s has no sing-box outbound but is in supportedTypes", typ)
+		}
+	}
+}
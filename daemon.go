@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type daemonOptions struct {
+	Build    buildOptions
+	Interval time.Duration
+	Listen   string
+	Pprof    string
+}
+
+func daemonCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	opts := &daemonOptions{}
+	registerBuildFlags(fs, &opts.Build)
+
+	fs.DurationVar(&opts.Interval, "interval", time.Hour, "time between refreshes of sources without their own interval")
+	fs.StringVar(&opts.Listen, "listen", "", "address serving /healthz, e.g. :8080 (disabled when empty)")
+	fs.StringVar(&opts.Pprof, "pprof", "", "loopback address serving net/http/pprof, e.g. 127.0.0.1:6060 (disabled when empty)")
+
+	return func(ctx context.Context) error {
+		return daemon(ctx, opts)
+	}
+}
+
+// buildStatus tracks the outcome of scheduled builds for /healthz.
+type buildStatus struct {
+	mu          sync.Mutex
+	LastRun     time.Time `json:"last_run"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+func (s *buildStatus) record(start time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.LastRun = start
+	if err != nil {
+		s.LastError = err.Error()
+		return
+	}
+	s.LastSuccess = start
+	s.LastError = ""
+}
+
+// ServeHTTP reports ready once a build has succeeded. A later failure keeps
+// the pod ready because the previously exported configs are still valid.
+func (s *buildStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if s.LastSuccess.IsZero() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(s)
+}
+
+// save persists the status so the outcome of the last run survives restarts.
+func (s *buildStatus) save(stateDir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return writeJSON(filepath.Join(stateDir, "daemon.json"), s)
+}
+
+func daemon(ctx context.Context, opts *daemonOptions) error {
+	status := &buildStatus{}
+
+	var servers []*http.Server
+
+	if opts.Pprof != "" {
+		srv, err := startPprof(opts.Pprof)
+		if err != nil {
+			return err
+		}
+		servers = append(servers, srv)
+	}
+
+	var srv *http.Server
+	if opts.Listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", status)
+		srv = &http.Server{Addr: opts.Listen, Handler: mux}
+		servers = append(servers, srv)
+
+		go func() {
+			log.Printf("serving /healthz on %s", opts.Listen)
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	sched := &sourceSchedule{
+		next:   make(map[string]time.Time),
+		hashes: make(map[string]string),
+	}
+
+	for {
+		start := time.Now()
+		err := sched.run(ctx, opts)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Printf("build failed: %v", err)
+		}
+		status.record(start, err)
+
+		wait := sched.until(time.Now(), opts.Interval)
+		log.Printf("next refresh in %s", wait.Round(time.Second))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+			continue
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		break
+	}
+
+	log.Printf("shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown %s: %v", srv.Addr, err)
+		}
+	}
+
+	return status.save(opts.Build.StateDir)
+}
+
+// sourceSchedule refreshes every source on its own interval and rebuilds
+// only when the content of at least one source changed.
+type sourceSchedule struct {
+	next   map[string]time.Time
+	hashes map[string]string
+	built  bool
+}
+
+func (s *sourceSchedule) run(ctx context.Context, opts *daemonOptions) error {
+	offlineMode = opts.Build.Offline
+
+	cfg, err := loadConfig(&opts.Build.Config)
+	if err != nil {
+		return err
+	}
+	if err := setupLogging(cfg.Log); err != nil {
+		return err
+	}
+
+	sources, err := configuredSources(cfg)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var due, idle []SourceConfig
+
+	for _, src := range sources {
+		if next, ok := s.next[src.Name]; ok && now.Before(next) {
+			idle = append(idle, src)
+			continue
+		}
+		due = append(due, src)
+	}
+
+	var fetched []sourcePayload
+	if offlineMode {
+		fetched, err = loadCachedSources(due, opts.Build.StateDir, true)
+	} else {
+		fetched, _, err = fetchSources(ctx, cfg, due, opts.Build.StateDir, &opts.Build.Fetch)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the configured interval wins over the provider's hint, which wins over
+	// the daemon default
+	for _, src := range due {
+		interval := time.Duration(src.Interval)
+		if interval <= 0 {
+			interval = time.Duration(loadSourceMeta(opts.Build.StateDir, src.Name).UpdateInterval)
+		}
+		if interval <= 0 {
+			interval = opts.Interval
+		}
+		s.next[src.Name] = now.Add(interval)
+	}
+
+	changed := make(map[string]string)
+	for _, p := range fetched {
+		if hash := sourceHash(p.Body); s.hashes[p.Source.Name] != hash {
+			log.Printf("source %s changed", p.Source.Name)
+			changed[p.Source.Name] = hash
+		}
+	}
+
+	if s.built && len(changed) == 0 {
+		log.Printf("refreshed %d sources, nothing changed", len(due))
+		return nil
+	}
+
+	// sources that were not refreshed contribute what they returned last
+	// time, and so do sources that failed to, as long as cache.max_age allows
+	maxAge := time.Duration(cfg.Cache.MaxAge)
+	payloads := append(fetched, loadStaleSources(due, fetched, opts.Build.StateDir, maxAge)...)
+
+	cached, err := loadCachedSources(idle, opts.Build.StateDir, false)
+	if err != nil {
+		return err
+	}
+	for _, p := range cached {
+		if _, local := localSourcePath(p.Source.URL); !local && maxAge > 0 {
+			if age, err := sourceCacheAge(opts.Build.StateDir, p.Source.Name); err == nil && age > maxAge {
+				log.Printf("source %s: cached subscription is %s old, leaving it out", p.Source.Name, age.Round(time.Minute))
+				continue
+			}
+		}
+		payloads = append(payloads, p)
+	}
+
+	if len(payloads) == 0 {
+		return fmt.Errorf("no source could be loaded")
+	}
+
+	if err := generate(ctx, &opts.Build, cfg, payloads); err != nil {
+		return err
+	}
+
+	// only remember the new content once it made it into a build, so a
+	// failed build is retried on the next refresh
+	for name, hash := range changed {
+		s.hashes[name] = hash
+	}
+	s.built = true
+	return nil
+}
+
+// until returns how long to wait for the next source to become due.
+func (s *sourceSchedule) until(now time.Time, fallback time.Duration) time.Duration {
+	wait := fallback
+	for _, next := range s.next {
+		if d := next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
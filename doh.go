@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dohResolver looks hostnames up over DNS-over-HTTPS (RFC 8484), for
+// networks whose DNS answers for the subscription host are poisoned.
+// Answers are kept for the lifetime of the resolver, which is one round of
+// fetches.
+type dohResolver struct {
+	server string
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string][]netip.Addr
+}
+
+func newDoHResolver(server string) *dohResolver {
+	return &dohResolver{
+		server: server,
+		// the DoH server itself goes through the system resolver, so it is
+		// best given as an IP address
+		client: &http.Client{Timeout: 10 * time.Second},
+		cache:  make(map[string][]netip.Addr),
+	}
+}
+
+// dialContext resolves the host of addr over DoH and dials its addresses in
+// turn. IP literals are dialed directly.
+func (r *dohResolver) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := netip.ParseAddr(host); err == nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		addrs, err := r.lookup(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s over DoH: %v", host, err)
+		}
+
+		var errs []error
+		for _, ip := range addrs {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
+
+// lookup returns the IPv4 and then the IPv6 addresses of host.
+func (r *dohResolver) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[host]
+	r.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	var addrs []netip.Addr
+	var errs []error
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		found, err := r.query(ctx, host, qtype)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		addrs = append(addrs, found...)
+	}
+	if len(addrs) == 0 {
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		return nil, errors.New("no addresses")
+	}
+
+	r.mu.Lock()
+	r.cache[host] = addrs
+	r.mu.Unlock()
+	return addrs, nil
+}
+
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+	dnsClassIN  = 1
+)
+
+func (r *dohResolver) query(ctx context.Context, host string, qtype uint16) ([]netip.Addr, error) {
+	msg, err := dnsQuery(host, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.server, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	return dnsAnswers(body, qtype)
+}
+
+// dnsQuery builds a recursive query for one name. The id is 0, as RFC 8484
+// recommends for cache friendliness.
+func dnsQuery(host string, qtype uint16) ([]byte, error) {
+	msg := []byte{0, 0, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid hostname %q", host)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+	return msg, nil
+}
+
+// dnsAnswers reads the addresses of the given type from a response. Other
+// records, such as the CNAMEs leading to them, are skipped.
+func dnsAnswers(msg []byte, qtype uint16) ([]netip.Addr, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("short DNS response")
+	}
+	if rcode := msg[3] & 0x0f; rcode != 0 {
+		return nil, fmt.Errorf("DNS error code %d", rcode)
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	var err error
+	for range qdcount {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, err
+		}
+		if off += 4; off > len(msg) {
+			return nil, errors.New("truncated DNS question")
+		}
+	}
+
+	var addrs []netip.Addr
+	for range ancount {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, errors.New("truncated DNS answer")
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, errors.New("truncated DNS answer")
+		}
+
+		if rtype == qtype && rdlen == dnsAddrLen(qtype) {
+			addr, _ := netip.AddrFromSlice(msg[off : off+rdlen])
+			addrs = append(addrs, addr.Unmap())
+		}
+		off += rdlen
+	}
+	return addrs, nil
+}
+
+// dnsAddrLen is the RDATA length of an address record of the given type.
+func dnsAddrLen(qtype uint16) int {
+	if qtype == dnsTypeAAAA {
+		return 16
+	}
+	return 4
+}
+
+// skipDNSName returns the offset after the name at off, which may end in a
+// compression pointer. Pointers are never followed, so a response with a
+// pointer loop cannot keep it spinning.
+func skipDNSName(msg []byte, off int) (int, error) {
+	for off < len(msg) {
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			return off + 1, nil
+		case n&0xc0 == 0xc0:
+			if off+2 > len(msg) {
+				return 0, errors.New("truncated DNS name")
+			}
+			return off + 2, nil
+		case n&0xc0 != 0:
+			return 0, fmt.Errorf("invalid DNS label type %#x", n&0xc0)
+		}
+		off += 1 + n
+	}
+	return 0, errors.New("truncated DNS name")
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestDNSQuery(t *testing.T) {
+	msg, err := dnsQuery("sub.example.com.", dnsTypeAAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0, 0, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0,
+		3, 's', 'u', 'b', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0, 28, 0, 1,
+	}
+	if !bytes.Equal(msg, want) {
+		t.Errorf("dnsQuery() = %v, want %v", msg, want)
+	}
+
+	for _, host := range []string{"", "a..b", string(make([]byte, 64)) + ".com"} {
+		if _, err := dnsQuery(host, dnsTypeA); err == nil {
+			t.Errorf("dnsQuery(%q) succeeded", host)
+		}
+	}
+}
+
+// testDNSName encodes host as a sequence of labels.
+func testDNSName(host string) []byte {
+	msg, _ := dnsQuery(host, 0)
+	return msg[12 : len(msg)-4]
+}
+
+// testDNSResponse builds a response to a query for example.com with the
+// given answer records, each a name followed by its type and RDATA.
+func testDNSResponse(rcode byte, qtype uint16, answers ...[]byte) []byte {
+	msg, _ := dnsQuery("example.com", qtype)
+	msg[2] |= 0x80
+	msg[3] |= rcode
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	for _, rr := range answers {
+		msg = append(msg, rr...)
+	}
+	return msg
+}
+
+func testDNSRecord(name []byte, rtype uint16, rdata []byte) []byte {
+	rr := slices.Clone(name)
+	rr = binary.BigEndian.AppendUint16(rr, rtype)
+	rr = binary.BigEndian.AppendUint16(rr, dnsClassIN)
+	rr = binary.BigEndian.AppendUint32(rr, 300)
+	rr = binary.BigEndian.AppendUint16(rr, uint16(len(rdata)))
+	return append(rr, rdata...)
+}
+
+func TestDNSAnswers(t *testing.T) {
+	// a pointer to the question name at offset 12
+	qname := []byte{0xc0, 12}
+	cdn := testDNSName("cdn.example.net")
+	v4 := []byte{192, 0, 2, 1}
+	v6 := netip.MustParseAddr("2001:db8::1").AsSlice()
+
+	tests := []struct {
+		name    string
+		msg     []byte
+		qtype   uint16
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "a",
+			msg:   testDNSResponse(0, dnsTypeA, testDNSRecord(qname, dnsTypeA, v4)),
+			qtype: dnsTypeA,
+			want:  []string{"192.0.2.1"},
+		},
+		{
+			name: "cname chain",
+			msg: testDNSResponse(0, dnsTypeA,
+				testDNSRecord(qname, 5, cdn),
+				testDNSRecord(cdn, dnsTypeA, v4),
+				testDNSRecord(cdn, dnsTypeA, []byte{192, 0, 2, 2})),
+			qtype: dnsTypeA,
+			want:  []string{"192.0.2.1", "192.0.2.2"},
+		},
+		{
+			name: "a and aaaa mixed with cname",
+			msg: testDNSResponse(0, dnsTypeAAAA,
+				testDNSRecord(qname, 5, cdn),
+				testDNSRecord(cdn, dnsTypeA, v4),
+				testDNSRecord(cdn, dnsTypeAAAA, v6)),
+			qtype: dnsTypeAAAA,
+			want:  []string{"2001:db8::1"},
+		},
+		{
+			name:  "rdata of the wrong size",
+			msg:   testDNSResponse(0, dnsTypeA, testDNSRecord(qname, dnsTypeA, v6), testDNSRecord(qname, dnsTypeA, v4)),
+			qtype: dnsTypeA,
+			want:  []string{"192.0.2.1"},
+		},
+		{
+			// the answer name at offset 29 points at itself
+			name:  "compression loop",
+			msg:   testDNSResponse(0, dnsTypeA, testDNSRecord([]byte{0xc0, 29}, dnsTypeA, v4)),
+			qtype: dnsTypeA,
+			want:  []string{"192.0.2.1"},
+		},
+		{
+			name:  "no answers",
+			msg:   testDNSResponse(0, dnsTypeA),
+			qtype: dnsTypeA,
+		},
+		{
+			name:    "error code",
+			msg:     testDNSResponse(3, dnsTypeA),
+			qtype:   dnsTypeA,
+			wantErr: "DNS error code 3",
+		},
+		{
+			name:    "short header",
+			msg:     []byte{0, 0, 0x81, 0x80},
+			qtype:   dnsTypeA,
+			wantErr: "short DNS response",
+		},
+		{
+			name:    "truncated rdata",
+			msg:     testDNSResponse(0, dnsTypeA, testDNSRecord(qname, dnsTypeA, v4))[:44],
+			qtype:   dnsTypeA,
+			wantErr: "truncated DNS answer",
+		},
+		{
+			name:    "truncated question",
+			msg:     testDNSResponse(0, dnsTypeA)[:27],
+			qtype:   dnsTypeA,
+			wantErr: "truncated DNS question",
+		},
+		{
+			name:    "truncated pointer",
+			msg:     testDNSResponse(0, dnsTypeA, []byte{0xc0}),
+			qtype:   dnsTypeA,
+			wantErr: "truncated DNS name",
+		},
+		{
+			name:    "reserved label type",
+			msg:     testDNSResponse(0, dnsTypeA, testDNSRecord([]byte{0x40, 0}, dnsTypeA, v4)),
+			qtype:   dnsTypeA,
+			wantErr: "invalid DNS label type 0x40",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addrs, err := dnsAnswers(tt.msg, tt.qtype)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("dnsAnswers() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, addr := range addrs {
+				got = append(got, addr.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("dnsAnswers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSAnswersTruncated(t *testing.T) {
+	msg := testDNSResponse(0, dnsTypeA,
+		testDNSRecord([]byte{0xc0, 12}, 5, testDNSName("cdn.example.net")),
+		testDNSRecord(testDNSName("cdn.example.net"), dnsTypeA, []byte{192, 0, 2, 1}))
+
+	// every prefix of a valid response fails cleanly instead of panicking
+	for n := range len(msg) {
+		if _, err := dnsAnswers(msg[:n], dnsTypeA); err == nil {
+			t.Errorf("dnsAnswers() of the first %d bytes succeeded", n)
+		}
+	}
+}
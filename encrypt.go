@@ -0,0 +1,207 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// EncryptConfig writes an encrypted copy of every exported config to Dir,
+// so the configs and the credentials in them can be synced through storage
+// that is not trusted, e.g. a cloud drive. The other side turns them back
+// into configs with "msbc decrypt".
+type EncryptConfig struct {
+	// Tool is "age" (the default) or "gpg"; the binary has to be installed.
+	Tool       string   `json:"tool,omitempty"`
+	Recipients []string `json:"recipients,omitempty"`
+	Dir        string   `json:"dir,omitempty"`
+}
+
+var encryptTools = []string{"age", "gpg"}
+
+// encryptedExt is appended to the name of every encrypted file.
+func encryptedExt(tool string) string {
+	return "." + cmp.Or(tool, "age")
+}
+
+// encryptExport encrypts the configs in exportDir into cfg.Dir and removes
+// encrypted files whose config is no longer exported.
+func encryptExport(ctx context.Context, cfg *EncryptConfig, exportDir string) error {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(exportDir)
+	if err != nil {
+		return err
+	}
+
+	ext := encryptedExt(cfg.Tool)
+	written := make(map[string]bool)
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		dst := filepath.Join(cfg.Dir, name+ext)
+		if err := runCrypt(ctx, encryptArgs(cfg), filepath.Join(exportDir, name), dst); err != nil {
+			return err
+		}
+		written[name+ext] = true
+	}
+
+	stale, err := filepath.Glob(filepath.Join(cfg.Dir, "*"+ext))
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if written[filepath.Base(path)] {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		log.Printf("removed stale %s", path)
+	}
+
+	log.Printf("encrypted %d configs into %s", len(written), cfg.Dir)
+	return nil
+}
+
+func encryptArgs(cfg *EncryptConfig) []string {
+	var args []string
+
+	switch cmp.Or(cfg.Tool, "age") {
+	case "age":
+		args = []string{"age", "--encrypt"}
+		for _, r := range cfg.Recipients {
+			args = append(args, "-r", r)
+		}
+	case "gpg":
+		// recipients are named explicitly, so there is no point in asking
+		// about their trust
+		args = []string{"gpg", "--batch", "--quiet", "--trust-model", "always", "--encrypt"}
+		for _, r := range cfg.Recipients {
+			args = append(args, "-r", r)
+		}
+	}
+	return args
+}
+
+// runCrypt runs args with src on stdin and writes stdout to dst atomically,
+// so a failed run never leaves a truncated file behind.
+func runCrypt(ctx context.Context, args []string, src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeAtomic(dst, 0600, func(w io.Writer) error {
+		var stderr strings.Builder
+
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdin = in
+		cmd.Stdout = w
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return fmt.Errorf("%s %s: %v: %s", args[0], filepath.Base(src), err, msg)
+			}
+			return fmt.Errorf("%s %s: %v", args[0], filepath.Base(src), err)
+		}
+		return nil
+	})
+}
+
+type decryptOptions struct {
+	From      string
+	Tool      string
+	Identity  string
+	ExportDir string
+	Reload    reloadOptions
+}
+
+func decryptCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	opts := &decryptOptions{}
+
+	fs.StringVar(&opts.From, "from", "", "directory of configs encrypted by a build with encrypt.dir (required)")
+	fs.StringVar(&opts.Tool, "tool", "age", "tool the configs were encrypted with: age or gpg")
+	fs.StringVar(&opts.Identity, "identity", "", "age identity file holding the private key; gpg uses its keyring")
+	fs.StringVar(&opts.ExportDir, "export-dir", cmp.Or(openwrtExportDir(), defaultExportDir), "directory the decrypted configs are exported to")
+	registerReloadFlags(fs, &opts.Reload)
+
+	return func(ctx context.Context) error {
+		return decrypt(ctx, opts)
+	}
+}
+
+// decrypt exports the configs in opts.From. Everything is decrypted and
+// checked before the first file is exported, so a bad or partially synced
+// file leaves the running configs alone.
+func decrypt(ctx context.Context, opts *decryptOptions) error {
+	if opts.From == "" {
+		return errors.New("-from is required")
+	}
+	if !slices.Contains(encryptTools, opts.Tool) {
+		return fmt.Errorf("unknown tool %q, expected age or gpg", opts.Tool)
+	}
+
+	var args []string
+	switch opts.Tool {
+	case "age":
+		if opts.Identity == "" {
+			return errors.New("-identity is required with age")
+		}
+		args = []string{"age", "--decrypt", "-i", opts.Identity}
+	case "gpg":
+		args = []string{"gpg", "--batch", "--quiet", "--decrypt"}
+	}
+
+	files, err := filepath.Glob(filepath.Join(opts.From, "*"+encryptedExt(opts.Tool)))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no %s files in %s", encryptedExt(opts.Tool), opts.From)
+	}
+
+	tmp, err := os.MkdirTemp("", "msbc-decrypt-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, path := range files {
+		name := strings.TrimSuffix(filepath.Base(path), encryptedExt(opts.Tool))
+		if err := runCrypt(ctx, args, path, filepath.Join(tmp, name)); err != nil {
+			return err
+		}
+	}
+
+	// the synced directory is as untrusted as a staging directory
+	if err := checkStaged(tmp); err != nil {
+		return err
+	}
+
+	if err := exportConfig(ctx, tmp, opts.ExportDir); err != nil {
+		return fmt.Errorf("failed to export configs: %v", err)
+	}
+
+	if err := reloadSingBox(ctx, &opts.Reload); err != nil {
+		return fmt.Errorf("failed to reload sing-box: %v", err)
+	}
+	return nil
+}
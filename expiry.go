@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SourceUsage is the plan status a provider reports, either through the
+// subscription-userinfo header or through pseudo-nodes whose only purpose is
+// to show it in client UIs. Zero fields are unknown.
+type SourceUsage struct {
+	Upload   int64     `json:"upload,omitempty"`
+	Download int64     `json:"download,omitempty"`
+	Total    int64     `json:"total,omitempty"`
+	Remain   *int64    `json:"remaining,omitempty"`
+	Expire   time.Time `json:"expire,omitzero"`
+}
+
+// remaining returns the traffic left on the plan and whether it is known.
+func (u *SourceUsage) remaining() (int64, bool) {
+	if u.Remain != nil {
+		return *u.Remain, true
+	}
+	if u.Total > 0 {
+		return max(u.Total-u.Upload-u.Download, 0), true
+	}
+	return 0, false
+}
+
+// parseUserinfo reads a header such as
+// "upload=123; download=456; total=1073741824; expire=1767225600".
+func parseUserinfo(v string) *SourceUsage {
+	usage := &SourceUsage{}
+	found := false
+
+	for _, field := range strings.Split(v, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+
+		n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			continue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "upload":
+			usage.Upload = int64(n)
+		case "download":
+			usage.Download = int64(n)
+		case "total":
+			usage.Total = int64(n)
+		case "expire":
+			if n > 0 {
+				usage.Expire = time.Unix(int64(n), 0).UTC()
+			}
+		default:
+			continue
+		}
+		found = true
+	}
+
+	if !found {
+		return nil
+	}
+	return usage
+}
+
+var (
+	pseudoExpireRe  = regexp.MustCompile(`(?i)(?:expire[sd]?|expiry|到期|过期)[^0-9]*(\d{4})[-/.年](\d{1,2})[-/.月](\d{1,2})`)
+	pseudoTrafficRe = regexp.MustCompile(`(?i)(?:remaining|traffic left|剩余流量)[^0-9]*([0-9.]+)\s*([KMGTP]i?B?)`)
+)
+
+// usageFromTag extracts plan status from a pseudo-node tag such as
+// "Expire: 2026-12-01" or "剩余流量：12.5 GB".
+func usageFromTag(tag string) *SourceUsage {
+	if m := pseudoExpireRe.FindStringSubmatch(tag); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		day, _ := strconv.Atoi(m[3])
+		return &SourceUsage{Expire: time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)}
+	}
+
+	if m := pseudoTrafficRe.FindStringSubmatch(tag); m != nil {
+		size, err := parseByteSize(m[1] + m[2])
+		if err == nil {
+			remain := int64(size)
+			return &SourceUsage{Remain: &remain}
+		}
+	}
+
+	return nil
+}
+
+// merge fills fields of u that are unknown from other.
+func (u *SourceUsage) merge(other *SourceUsage) *SourceUsage {
+	if u == nil {
+		return other
+	}
+	if other == nil {
+		return u
+	}
+
+	merged := *u
+	if merged.Total == 0 && merged.Remain == nil {
+		merged.Upload, merged.Download, merged.Total, merged.Remain = other.Upload, other.Download, other.Total, other.Remain
+	}
+	if merged.Expire.IsZero() {
+		merged.Expire = other.Expire
+	}
+	return &merged
+}
+
+// String summarizes the plan status for the log.
+func (u *SourceUsage) String() string {
+	var parts []string
+	if remain, ok := u.remaining(); ok {
+		if u.Total > 0 {
+			parts = append(parts, fmt.Sprintf("%s of %s left", ByteSize(remain), ByteSize(u.Total)))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s left", ByteSize(remain)))
+		}
+	}
+	if !u.Expire.IsZero() {
+		parts = append(parts, "expires on "+u.Expire.Format(time.DateOnly))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// expired reports whether the provider says the plan has run out.
+func (u *SourceUsage) expired(now time.Time) bool {
+	return u != nil && !u.Expire.IsZero() && !u.Expire.After(now)
+}
+
+// checkExpired fails the build when a source's plan has expired and
+// AbortExpired is set, so the configs of the last good build stay in place.
+func checkExpired(cfg ExpiryConfig, payloads []sourcePayload, now time.Time) error {
+	if !cfg.AbortExpired {
+		return nil
+	}
+	for _, p := range payloads {
+		if p.Meta.Usage.expired(now) {
+			return fmt.Errorf("source %s: plan expired on %s, not building", p.Source.Name, p.Meta.Usage.Expire.Format(time.DateOnly))
+		}
+	}
+	return nil
+}
+
+// exhausted reports whether the provider says no traffic is left.
+func (u *SourceUsage) exhausted() bool {
+	if u == nil {
+		return false
+	}
+	remain, ok := u.remaining()
+	return ok && remain == 0
+}
+
+// activePayloads drops the sources whose plan has no traffic left when
+// suppression is enabled. Their caches are kept, so they come back as soon
+// as a refresh reports a reset.
+func activePayloads(cfg ExpiryConfig, payloads []sourcePayload) []sourcePayload {
+	if !cfg.SuppressExhausted {
+		return payloads
+	}
+
+	active := make([]sourcePayload, 0, len(payloads))
+	for _, p := range payloads {
+		if p.Meta.Usage.exhausted() {
+			log.Printf("source %s: no traffic left, leaving its nodes out", p.Source.Name)
+			continue
+		}
+		active = append(active, p)
+	}
+	return active
+}
+
+// expiryWarnings describes why a plan needs attention, if it does.
+func expiryWarnings(cfg ExpiryConfig, usage *SourceUsage, now time.Time) []string {
+	if usage == nil {
+		return nil
+	}
+
+	var warnings []string
+
+	if !usage.Expire.IsZero() && cfg.WarnDays > 0 {
+		left := usage.Expire.Sub(now)
+		switch {
+		case left <= 0:
+			warnings = append(warnings, fmt.Sprintf("plan expired on %s", usage.Expire.Format(time.DateOnly)))
+		case left <= time.Duration(cfg.WarnDays)*24*time.Hour:
+			days := int(math.Ceil(left.Hours() / 24))
+			warnings = append(warnings, fmt.Sprintf("plan expires on %s, in %d days", usage.Expire.Format(time.DateOnly), days))
+		}
+	}
+
+	if remain, ok := usage.remaining(); ok && cfg.WarnTraffic > 0 && remain < int64(cfg.WarnTraffic) {
+		warnings = append(warnings, fmt.Sprintf("only %s of traffic left", ByteSize(remain)))
+	}
+
+	return warnings
+}
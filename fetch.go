@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// fetchOptions controls how subscriptions are downloaded.
+type fetchOptions struct {
+	DebugHTTP bool
+	Proxy     proxyFlag
+	CAFile    string
+	Insecure  bool
+}
+
+func registerFetchFlags(fs *flag.FlagSet, opts *fetchOptions) {
+	fs.BoolVar(&opts.DebugHTTP, "debug-http", false, "log redacted headers, resolved addresses, TLS details and timings of subscription requests")
+	fs.StringVar(&opts.CAFile, "fetch-ca-file", "", "also trust the PEM certificates in this file when fetching subscriptions, e.g. the internal CA of a self-hosted panel")
+	fs.BoolVar(&opts.Insecure, "fetch-insecure", false, "do not verify the certificates of subscription hosts; the generated outbounds are not affected")
+	fs.Var(&opts.Proxy, "fetch-proxy", "download subscriptions through this http, https, socks5 or socks5h proxy url instead of $HTTPS_PROXY/$HTTP_PROXY")
+}
+
+// proxyFlag is the proxy subscriptions are fetched through. Unset, the
+// proxy environment variables apply as for any Go program.
+type proxyFlag struct {
+	url *url.URL
+}
+
+func (f *proxyFlag) String() string {
+	if f.url == nil {
+		return ""
+	}
+	return redactURL(f.url.String())
+}
+
+func (f *proxyFlag) Set(v string) error {
+	if v == "" {
+		f.url = nil
+		return nil
+	}
+
+	u, err := url.Parse(v)
+	if err != nil {
+		return fmt.Errorf("invalid proxy url: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("invalid proxy url %q, expected an http, https, socks5 or socks5h scheme", redactURL(v))
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid proxy url %q, missing host", redactURL(v))
+	}
+
+	f.url = u
+	return nil
+}
+
+// FetchConfig tunes the HTTP client subscriptions are downloaded with. A
+// zero timeout means no limit. Timeout covers the whole request including
+// the body, ResponseTimeout the wait for the response headers once the
+// request is sent. MaxRedirects of 0 turns redirects into errors.
+type FetchConfig struct {
+	Timeout         Duration `json:"timeout"`
+	ConnectTimeout  Duration `json:"connect_timeout"`
+	ResponseTimeout Duration `json:"response_timeout,omitempty"`
+	KeepAlive       Duration `json:"keep_alive"`
+	MaxRedirects    int      `json:"max_redirects"`
+	// Concurrency is how many sources are fetched at the same time.
+	Concurrency int `json:"concurrency"`
+	// DoH is the url of a DNS-over-HTTPS server that subscription hosts
+	// are resolved with instead of the system resolver.
+	DoH string `json:"doh,omitempty"`
+}
+
+func defaultFetchConfig() FetchConfig {
+	return FetchConfig{
+		Timeout:        Duration(15 * time.Second),
+		ConnectTimeout: Duration(10 * time.Second),
+		KeepAlive:      Duration(30 * time.Second),
+		MaxRedirects:   10,
+		Concurrency:    4,
+	}
+}
+
+// newFetchClient builds the client for one round of fetches, so sources on
+// the same host share connections. The TLS options only apply to the
+// subscription requests, never to the nodes.
+func newFetchClient(cfg *FetchConfig, opts *fetchOptions) (*http.Client, error) {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(cfg.ConnectTimeout),
+		KeepAlive: time.Duration(cfg.KeepAlive),
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = dialer.DialContext
+	if cfg.DoH != "" {
+		t.DialContext = newDoHResolver(cfg.DoH).dialContext(dialer)
+	}
+	t.ResponseHeaderTimeout = time.Duration(cfg.ResponseTimeout)
+	if opts.Proxy.url != nil {
+		t.Proxy = http.ProxyURL(opts.Proxy.url)
+	}
+
+	if opts.CAFile != "" || opts.Insecure {
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: opts.Insecure}
+	}
+	if opts.Insecure {
+		log.Printf("warning: not verifying the certificates of subscription hosts")
+	}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates in %s", opts.CAFile)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+
+	return &http.Client{
+		Timeout:   time.Duration(cfg.Timeout),
+		Transport: t,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", cfg.MaxRedirects)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// subscriptionURL returns the server list endpoint from the environment,
+// used when msbc.json does not define any sources. Like a source url, it
+// may name a local file.
+func subscriptionURL() (string, error) {
+	srvListURL := os.Getenv("SERVER_LIST_URL")
+
+	if srvListURL == "" {
+		return "", fmt.Errorf("$SERVER_LIST_URL environment variable not set")
+	}
+	return srvListURL, nil
+}
+
+// localSourcePath returns the file a source url points at, for a file://
+// url or a plain path such as a mihomo proxy-provider file. "-" stands for
+// standard input.
+func localSourcePath(src string) (string, bool) {
+	if path, ok := strings.CutPrefix(src, "file://"); ok {
+		return path, true
+	}
+	if !strings.Contains(src, "://") {
+		return src, true
+	}
+	return "", false
+}
+
+// readLocalSource reads a subscription from a file, or from standard input
+// for "-", which can only be read once per run.
+func readLocalSource(path string) ([]byte, error) {
+	if path == "-" {
+		body, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading stdin: %v", err)
+		}
+		log.Printf("read %d bytes from stdin", len(body))
+		return body, nil
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("read %d bytes from %s", len(body), path)
+	return body, nil
+}
+
+// errNotModified is returned for a conditional request the provider
+// answered with 304, meaning the cached body is current.
+var errNotModified = errors.New("not modified")
+
+// fetchSubscription downloads a subscription and returns its body along with
+// the response headers, which may carry provider metadata. headers are added
+// to the request. A local file is read instead and comes without headers.
+func fetchSubscription(ctx context.Context, client *http.Client, srvListURL string, headers map[string]string, opts *fetchOptions) ([]byte, http.Header, error) {
+	if path, ok := localSourcePath(srvListURL); ok {
+		body, err := readLocalSource(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return body, http.Header{}, nil
+	}
+
+	if err := requireNetwork("fetching " + srvListURL); err != nil {
+		return nil, nil, err
+	}
+
+	if opts.Proxy.url != nil {
+		log.Printf("fetching from %s through %s", redactURL(srvListURL), opts.Proxy.String())
+	} else {
+		log.Printf("fetching from %s", redactURL(srvListURL))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srvListURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	if opts.DebugHTTP {
+		req = traceRequest(req)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if opts.DebugHTTP {
+		logResponse(resp)
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		log.Printf("%s not modified since the last fetch", redactURL(srvListURL))
+		return nil, resp.Header, errNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, &statusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Printf("fetched %d bytes from %s", len(body), redactURL(srvListURL))
+
+	// the transport only decompresses the gzip it asked for itself
+	if body, err = decompressBody(body, resp.Header.Get("Content-Encoding")); err != nil {
+		return nil, nil, err
+	}
+	return body, resp.Header, nil
+}
+
+// decompressBody undoes the Content-Encoding of a response, a list applied
+// in order. A gzip body sent without the header is recognized by its magic
+// bytes. Brotli is not in the standard library and is reported as an error.
+func decompressBody(body []byte, encoding string) ([]byte, error) {
+	codings := splitList(strings.ToLower(encoding))
+	if len(codings) == 0 && bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		codings = []string{"gzip"}
+	}
+
+	for _, coding := range slices.Backward(codings) {
+		var r io.Reader
+		switch coding {
+		case "identity":
+			continue
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				return nil, fmt.Errorf("invalid gzip body: %v", err)
+			}
+			r = zr
+		case "deflate":
+			// deflate is meant to be zlib wrapped, some servers send it raw
+			if zr, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+				r = zr
+			} else {
+				r = flate.NewReader(bytes.NewReader(body))
+			}
+		case "br":
+			return nil, errors.New("the subscription is brotli compressed, which msbc cannot decode")
+		default:
+			return nil, fmt.Errorf("unsupported Content-Encoding %q", coding)
+		}
+
+		decoded, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing %s body: %v", coding, err)
+		}
+		log.Printf("decompressed %s body to %d bytes", coding, len(decoded))
+		body = decoded
+	}
+	return body, nil
+}
+
+// sourceFormats are the formats a source can be pinned to instead of having
+// its body detected: share links, plain or base64, or one of the formats
+// that list their nodes as records.
+var sourceFormats = []string{"links", "clash", "sip008", "surge", "quantumultx"}
+
+// detectFormat guesses the format of a subscription body.
+func detectFormat(body []byte) string {
+	switch {
+	case isClashConfig(body):
+		return "clash"
+	case isSIP008(body):
+		return "sip008"
+	case isQuantumultXList(body):
+		return "quantumultx"
+	case isSurgeList(body):
+		return "surge"
+	}
+	return "links"
+}
+
+// decodeRecords turns the bodies that list their nodes as records rather
+// than links, Clash yaml, SIP008 json and Quantumult X or Surge lists, into
+// share links.
+func decodeRecords(body []byte, format string) (lines, skipped []string, err error) {
+	switch format {
+	case "clash":
+		return decodeClash(body)
+	case "sip008":
+		return decodeSIP008(body)
+	case "quantumultx":
+		return decodeQuantumultX(body)
+	case "surge":
+		return decodeSurge(body)
+	}
+	return nil, nil, fmt.Errorf("unknown subscription format %q", format)
+}
+
+// decodeSubscription turns a body into link lines, logging what it read.
+// An empty format is detected from the body.
+func decodeSubscription(body []byte, format string) ([]string, error) {
+	format = cmp.Or(format, detectFormat(body))
+	if format != "links" {
+		lines, skipped, err := decodeRecords(body, format)
+		if err != nil {
+			return nil, err
+		}
+		for _, reason := range skipped {
+			log.Printf("skipping %s %s", format, reason)
+		}
+		log.Printf("read %d %s nodes", len(lines), format)
+		return lines, nil
+	}
+
+	lines, err := splitLinkBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("decoded %d lines", len(lines))
+	return lines, nil
+}
+
+// decodeLines is decodeSubscription without the logging.
+func decodeLines(body []byte, format string) ([]string, error) {
+	format = cmp.Or(format, detectFormat(body))
+	if format != "links" {
+		lines, _, err := decodeRecords(body, format)
+		return lines, err
+	}
+	return splitLinkBody(body)
+}
+
+// linkLineRe matches a line starting with a share link. ':' is outside
+// both base64 alphabets, so no encoded body matches.
+var linkLineRe = regexp.MustCompile(`(?m)^[ \t]*[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+// splitLinkBody splits a body of share links into lines. The body may be a
+// list of links as is, or one encoded in base64.
+func splitLinkBody(body []byte) ([]string, error) {
+	if linkLineRe.Match(body) {
+		return strings.Split(string(body), "\n"), nil
+	}
+
+	decoded, err := decodeBodyBase64(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("body is neither a list of links nor base64: %v", err)
+	}
+
+	return strings.Split(string(decoded), "\n"), nil
+}
+
+// decodeBodyBase64 decodes a base64 body whichever alphabet and padding a
+// provider chose. Whitespace, including the line breaks some providers wrap
+// the body at, is dropped first. The error is that of the standard encoding.
+func decodeBodyBase64(text string) ([]byte, error) {
+	text = strings.Join(strings.Fields(text), "")
+
+	var firstErr error
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding,
+	} {
+		// the raw encodings reject padding rather than ignore it
+		in := text
+		if enc == base64.RawStdEncoding || enc == base64.RawURLEncoding {
+			in = strings.TrimRight(text, "=")
+		}
+		decoded, err := enc.DecodeString(in)
+		if err == nil {
+			return decoded, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// saveSourceCache keeps the raw body of the last successful fetch of a
+// source so that offline builds and inspection commands can work without
+// hitting the provider again.
+func saveSourceCache(stateDir, name string, body []byte) error {
+	path := sourceCachePath(stateDir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, body, 0600)
+}
+
+func loadSourceCache(stateDir, name string) ([]byte, error) {
+	path := sourceCachePath(stateDir, name)
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no cached subscription for source %s at %s, run a build first", name, path)
+		}
+		return nil, err
+	}
+
+	log.Printf("read %d bytes from %s", len(body), path)
+	return body, nil
+}
+
+func sourceCachePath(stateDir, name string) string {
+	return filepath.Join(stateDir, "sources", safeFileName(name)+".cache")
+}
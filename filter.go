@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// FilterConfig screens parsed nodes for endpoints that should never come
+// from a subscription. Actions are "drop", "warn" or "allow".
+type FilterConfig struct {
+	// PrivateAddresses applies to nodes pointing at private, loopback,
+	// link-local or otherwise reserved addresses, which can redirect traffic
+	// into the local network.
+	PrivateAddresses string `json:"private_addresses,omitempty"`
+	// Confusables applies to hostnames made to look like other hostnames,
+	// e.g. with Cyrillic letters that render like Latin ones.
+	Confusables string `json:"confusables,omitempty"`
+	// DenyHosts lists hostnames whose nodes are always dropped. An entry
+	// also matches its subdomains.
+	DenyHosts []string `json:"deny_hosts,omitempty"`
+}
+
+var filterActions = []string{"drop", "warn", "allow"}
+
+// Rejection records a node a filter dropped, for the report.
+type Rejection struct {
+	ID     string `json:"id"`
+	Tag    string `json:"tag"`
+	Source string `json:"source"`
+	Server string `json:"server"`
+	Reason string `json:"reason"`
+}
+
+func reject(ob *ServerOutbound, format string, args ...any) Rejection {
+	reason := fmt.Sprintf(format, args...)
+	log.Printf("%s: %s, skipping", ob.Tag, reason)
+	buildTrace.note(ob, "dropped by the filter, %s", reason)
+	return Rejection{ID: ob.ID, Tag: ob.Tag, Source: ob.Source, Server: ob.Server, Reason: reason}
+}
+
+// reservedPrefixes are ranges not covered by the netip predicates that a
+// public proxy server cannot live in.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+func isReservedAddr(addr netip.Addr) bool {
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedAddr returns the first reserved address a node points at. Without
+// the resolution stage only IP literals and localhost can be checked.
+func reservedAddr(ob *ServerOutbound) (string, bool) {
+	host := strings.ToLower(strings.TrimSuffix(ob.Server, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return host, true
+	}
+
+	addrs := ob.Addrs
+	if addr, err := netip.ParseAddr(ob.Server); err == nil {
+		addrs = []netip.Addr{addr.Unmap()}
+	}
+
+	for _, addr := range addrs {
+		if isReservedAddr(addr) {
+			return addr.String(), true
+		}
+	}
+	return "", false
+}
+
+func deniedHost(deny []string, host string) (string, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, entry := range deny {
+		domain := strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(entry, "*"), "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return entry, true
+		}
+	}
+	return "", false
+}
+
+// asciiLookalikes are non-Latin letters that render (nearly) identical to
+// a Latin letter in common fonts.
+var asciiLookalikes = map[rune]rune{
+	'а': 'a', 'в': 'b', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'к': 'k',
+	'ӏ': 'l', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'с': 'c',
+	'ѕ': 's', 'т': 't', 'у': 'y', 'х': 'x', 'ԁ': 'd', 'ԝ': 'w', 'ѵ': 'v',
+	'α': 'a', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x',
+}
+
+// confusableScripts are the scripts whose letters pass for one another.
+// Letters of other scripts are not confused with these and are ignored.
+var confusableScripts = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{"Latin", unicode.Latin},
+	{"Cyrillic", unicode.Cyrillic},
+	{"Greek", unicode.Greek},
+}
+
+// confusableHost reports hostnames that mix Latin, Cyrillic and Greek
+// within a label, or that are spelled entirely with letters imitating ASCII.
+// Punycode labels are decoded first since that is how such names usually
+// arrive. Accented Latin, as in münchen.de, is Latin like the rest.
+func confusableHost(host string) (string, bool) {
+	for _, label := range strings.Split(strings.ToLower(host), ".") {
+		decoded := label
+		if rest, ok := strings.CutPrefix(label, "xn--"); ok {
+			var err error
+			decoded, err = decodePunycode(rest)
+			if err != nil {
+				return fmt.Sprintf("host label %s is invalid punycode", label), true
+			}
+		}
+
+		var scripts []string
+		letters, lookalikes := 0, 0
+		var imitated strings.Builder
+		for _, r := range decoded {
+			if ascii, ok := asciiLookalikes[r]; ok {
+				lookalikes++
+				imitated.WriteRune(ascii)
+			} else {
+				imitated.WriteRune(r)
+			}
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			letters++
+			for _, script := range confusableScripts {
+				if unicode.Is(script.table, r) && !slices.Contains(scripts, script.name) {
+					scripts = append(scripts, script.name)
+				}
+			}
+		}
+
+		switch {
+		case len(scripts) > 1:
+			return fmt.Sprintf("host label %q mixes %s", decoded, strings.Join(scripts, " and ")), true
+		case lookalikes > 0 && lookalikes == letters:
+			return fmt.Sprintf("host label %q imitates %q", decoded, imitated.String()), true
+		}
+	}
+	return "", false
+}
+
+// sourceFilter holds the include and exclude regexps of a source, either
+// nil when unset.
+type sourceFilter struct {
+	include, exclude *regexp.Regexp
+}
+
+func newSourceFilters(sources []SourceConfig) map[string]sourceFilter {
+	filters := make(map[string]sourceFilter)
+	for _, src := range sources {
+		var f sourceFilter
+		// both were validated with the config
+		if src.Include != "" {
+			f.include = regexp.MustCompile(src.Include)
+		}
+		if src.Exclude != "" {
+			f.exclude = regexp.MustCompile(src.Exclude)
+		}
+		filters[src.Name] = f
+	}
+	return filters
+}
+
+// filterOutbounds applies the filters, those of the sources included, and
+// returns the kept nodes along with the ones that were dropped.
+func filterOutbounds(cfg FilterConfig, sources []SourceConfig, outbounds []ServerOutbound) ([]ServerOutbound, []Rejection) {
+	var rejected []Rejection
+	kept := outbounds[:0]
+	sourceFilters := newSourceFilters(sources)
+
+	for _, ob := range outbounds {
+		if reason, ok := unrepresentable[ob.Type]; ok {
+			rejected = append(rejected, reject(&ob, "%s", reason))
+			continue
+		}
+
+		if f := sourceFilters[ob.Source]; f.include != nil && !f.include.MatchString(ob.Tag) {
+			rejected = append(rejected, reject(&ob, "tag does not match the include of source %s", ob.Source))
+			continue
+		} else if f.exclude != nil && f.exclude.MatchString(ob.Tag) {
+			rejected = append(rejected, reject(&ob, "tag matches the exclude of source %s", ob.Source))
+			continue
+		}
+
+		if entry, ok := deniedHost(cfg.DenyHosts, ob.Server); ok {
+			rejected = append(rejected, reject(&ob, "host matches deny-list entry %s", entry))
+			continue
+		}
+
+		if reason, ok := confusableHost(ob.Server); ok {
+			switch cfg.Confusables {
+			case "drop":
+				rejected = append(rejected, reject(&ob, "%s", reason))
+				continue
+			case "warn":
+				log.Printf("warning: %s: %s", ob.Tag, reason)
+				buildTrace.note(&ob, "kept with a warning, %s", reason)
+			}
+		}
+
+		if addr, ok := reservedAddr(&ob); ok {
+			switch cfg.PrivateAddresses {
+			case "drop":
+				rejected = append(rejected, reject(&ob, "points at reserved address %s", addr))
+				continue
+			case "warn":
+				log.Printf("warning: %s points at reserved address %s", ob.Tag, addr)
+				buildTrace.note(&ob, "kept with a warning, points at reserved address %s", addr)
+			}
+		}
+
+		kept = append(kept, ob)
+	}
+
+	return kept, rejected
+}
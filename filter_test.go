@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestConfusableHost(t *testing.T) {
+	tests := []struct {
+		host      string
+		confusing bool
+	}{
+		{"example.com", false},
+		{"münchen.de", false},
+		{"café.fr", false},
+		{"xn--mnchen-3ya.de", false},
+		{"straße.de", false},
+		{"пример.рф", false},
+		{"ελλάδα.gr", false},
+		{"例え.jp", false},
+		{"jp-01.例え.jp", false},
+		{"аррӏе.com", true},
+		{"xn--80ak6aa92e.com", true},
+		{"pаypal.com", true},
+		{"gοogle.com", true},
+		{"xn--ab_c.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			reason, got := confusableHost(tt.host)
+			if got != tt.confusing {
+				t.Errorf("confusableHost(%q) = %q, %v, want %v", tt.host, reason, got, tt.confusing)
+			}
+		})
+	}
+}
+
+func TestDecodePunycode(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"mnchen-3ya", "münchen"},
+		{"80ak6aa92e", "аррӏе"},
+		{"r8jz45g", "例え"},
+		{"abc-", "abc"},
+	}
+
+	for _, tt := range tests {
+		got, err := decodePunycode(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("decodePunycode(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
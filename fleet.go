@@ -0,0 +1,223 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FleetHost is a remote device that receives the configs after every build.
+// Commands run through the remote shell; an empty Reload or Health skips
+// that step.
+type FleetHost struct {
+	Name     string `json:"name"`
+	SSH      string `json:"ssh"`
+	Port     int    `json:"port,omitempty"`
+	Identity string `json:"identity,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Reload   string `json:"reload,omitempty"`
+	Health   string `json:"health,omitempty"`
+}
+
+// FleetStatus is the outcome of the last push to a host, kept in
+// state/fleet.json.
+type FleetStatus struct {
+	Name     string    `json:"name"`
+	OK       bool      `json:"ok"`
+	Step     string    `json:"step,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	PushedAt time.Time `json:"pushed_at"`
+}
+
+// pushFleet copies exportDir to every host in parallel, reloads sing-box
+// there and runs the health check. A failing host does not stop the others;
+// the statuses are logged and saved to the state directory.
+func pushFleet(ctx context.Context, hosts []FleetHost, stateDir, exportDir string) error {
+	if err := requireNetwork("pushing configs to the fleet"); err != nil {
+		return err
+	}
+
+	archive, err := tarDir(exportDir)
+	if err != nil {
+		return err
+	}
+
+	statuses := make([]FleetStatus, len(hosts))
+	var wg sync.WaitGroup
+
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host FleetHost) {
+			defer wg.Done()
+			statuses[i] = pushHost(ctx, &host, archive)
+		}(i, host)
+	}
+	wg.Wait()
+
+	ok := 0
+	for _, s := range statuses {
+		if s.OK {
+			ok++
+			log.Printf("fleet %s: updated", s.Name)
+			continue
+		}
+		log.Printf("warning: fleet %s: %s failed: %s", s.Name, s.Step, s.Error)
+	}
+	log.Printf("fleet: %d of %d hosts updated", ok, len(hosts))
+
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(stateDir, "fleet.json"), statuses)
+}
+
+func pushHost(ctx context.Context, host *FleetHost, archive []byte) FleetStatus {
+	status := FleetStatus{Name: host.Name, PushedAt: time.Now().UTC()}
+
+	steps := []struct {
+		name    string
+		command string
+		stdin   []byte
+	}{
+		{"push", installScript(cmp.Or(host.Path, defaultExportDir)), archive},
+		{"reload", host.Reload, nil},
+		{"health check", host.Health, nil},
+	}
+
+	for _, step := range steps {
+		if step.command == "" {
+			continue
+		}
+		if err := runSSH(ctx, host, step.command, step.stdin); err != nil {
+			status.Step = step.name
+			status.Error = err.Error()
+			return status
+		}
+	}
+
+	status.OK = true
+	return status
+}
+
+// installScript unpacks the archive on stdin next to path and then replaces
+// the files the previous push wrote to path, as listed in its manifest, so a
+// broken connection never leaves a partial set behind and files of an older
+// output layout disappear while those of others stay.
+func installScript(path string) string {
+	dir := shellQuote(path)
+
+	return strings.Join([]string{
+		"set -e",
+		"mkdir -p " + dir,
+		"tmp=$(mktemp -d " + dir + "/.msbc.XXXXXX)",
+		`trap 'rm -rf "$tmp"' EXIT`,
+		`tar -C "$tmp" -xf -`,
+		"if [ -f " + dir + "/" + generatedManifest + " ]; then",
+		"  while IFS= read -r f; do",
+		`    case "$f" in ""|.*|*/*) ;; *) rm -f ` + dir + `/"$f" ;; esac`,
+		"  done < " + dir + "/" + generatedManifest,
+		"fi",
+		`cp -R "$tmp"/. ` + dir,
+	}, "\n")
+}
+
+func runSSH(ctx context.Context, host *FleetHost, command string, stdin []byte) error {
+	args := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=10"}
+	if host.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(host.Port))
+	}
+	if host.Identity != "" {
+		args = append(args, "-i", host.Identity)
+	}
+	args = append(args, host.SSH, command)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// tarDir archives the regular files below dir, skipping dotfiles.
+func tarDir(dir string) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		// the manifest tells the host which files to replace
+		if strings.HasPrefix(d.Name(), ".") && rel != generatedManifest {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GitConfig commits every exported config to a git repository, giving an
+// audited history that can be reverted and deployed by pulling. Repo is a
+// local path, used as the working copy, or a remote url that is cloned into
+// the state directory and pushed to.
+type GitConfig struct {
+	Repo   string `json:"repo,omitempty"`
+	Branch string `json:"branch,omitempty"`
+	// Dir is the directory inside the repository holding the configs.
+	Dir    string `json:"dir,omitempty"`
+	Author string `json:"author,omitempty"`
+}
+
+const (
+	defaultGitBranch = "main"
+	defaultGitAuthor = "msbc <msbc@localhost>"
+)
+
+// isRemoteRepo tells urls and scp-like "host:path" addresses apart from
+// local paths.
+func isRemoteRepo(repo string) bool {
+	if strings.Contains(repo, "://") {
+		return true
+	}
+	host, _, ok := strings.Cut(repo, ":")
+	return ok && !strings.ContainsAny(host, `/\`) && len(host) > 1
+}
+
+// exportGit mirrors exportDir into the repository and commits the result if
+// anything changed. The commit message lists the changed files.
+func exportGit(ctx context.Context, cfg *GitConfig, stateDir, exportDir string) error {
+	branch := cmp.Or(cfg.Branch, defaultGitBranch)
+	author, err := mail.ParseAddress(cmp.Or(cfg.Author, defaultGitAuthor))
+	if err != nil {
+		return fmt.Errorf("git author %q: %v", cfg.Author, err)
+	}
+
+	remote := isRemoteRepo(cfg.Repo)
+	worktree := cfg.Repo
+	if remote {
+		if err := requireNetwork("committing configs to " + cfg.Repo); err != nil {
+			return err
+		}
+		worktree = filepath.Join(stateDir, "git")
+	}
+
+	if err := prepareWorktree(ctx, cfg.Repo, worktree, branch, remote); err != nil {
+		return err
+	}
+
+	target := filepath.Join(worktree, cfg.Dir)
+	if err := mirrorDir(exportDir, target); err != nil {
+		return err
+	}
+
+	pathspec := cmp.Or(cfg.Dir, ".")
+	if _, err := runGit(ctx, worktree, "add", "-A", "--", pathspec); err != nil {
+		return err
+	}
+
+	if _, err := runGit(ctx, worktree, "diff", "--cached", "--quiet", "--", pathspec); err == nil {
+		log.Printf("configs unchanged, nothing to commit to %s", cfg.Repo)
+		return nil
+	}
+
+	stat, err := runGit(ctx, worktree, "diff", "--cached", "--stat", "--", pathspec)
+	if err != nil {
+		return err
+	}
+
+	message := "Update sing-box configs\n\n" + stat
+	_, err = runGit(ctx, worktree,
+		"-c", "user.name="+author.Name, "-c", "user.email="+author.Address,
+		"commit", "-q", "--no-verify", "-m", message, "--", pathspec)
+	if err != nil {
+		return err
+	}
+
+	if remote {
+		if _, err := runGit(ctx, worktree, "push", "-q", "origin", branch); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("committed configs to %s (%s)", cfg.Repo, branch)
+	return nil
+}
+
+// prepareWorktree leaves worktree on branch. A remote repository is cloned
+// on first use and reset to the remote branch afterwards, so local commits
+// that failed to push are replaced by a fresh one.
+func prepareWorktree(ctx context.Context, repo, worktree, branch string, remote bool) error {
+	if _, err := os.Stat(filepath.Join(worktree, ".git")); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		if remote {
+			if _, err := runGit(ctx, "", "clone", "-q", "--no-checkout", repo, worktree); err != nil {
+				return err
+			}
+		} else {
+			if err := os.MkdirAll(worktree, 0755); err != nil {
+				return err
+			}
+			if _, err := runGit(ctx, worktree, "init", "-q"); err != nil {
+				return err
+			}
+			_, err := runGit(ctx, worktree, "symbolic-ref", "HEAD", "refs/heads/"+branch)
+			return err
+		}
+	} else if remote {
+		if _, err := runGit(ctx, worktree, "fetch", "-q", "origin"); err != nil {
+			return err
+		}
+	}
+
+	if remote {
+		if _, err := runGit(ctx, worktree, "rev-parse", "-q", "--verify", "refs/remotes/origin/"+branch); err == nil {
+			_, err := runGit(ctx, worktree, "checkout", "-q", "-f", "-B", branch, "origin/"+branch)
+			return err
+		}
+	}
+
+	current, err := runGit(ctx, worktree, "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(current) == branch {
+		return nil
+	}
+
+	if _, err := runGit(ctx, worktree, "rev-parse", "-q", "--verify", "refs/heads/"+branch); err == nil {
+		_, err := runGit(ctx, worktree, "checkout", "-q", branch)
+		return err
+	}
+
+	// a new branch starts empty rather than from whatever was checked out
+	if _, err := runGit(ctx, worktree, "checkout", "-q", "--orphan", branch); err != nil {
+		return err
+	}
+	_, err = runGit(ctx, worktree, "read-tree", "--empty")
+	return err
+}
+
+// mirrorDir copies the files of src to dst and removes those an earlier
+// mirror copied that src no longer has. Everything else in dst, such as a
+// README next to the configs or .git, is left alone.
+func mirrorDir(src, dst string) error {
+	keep := make(map[string]struct{})
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		keep[filepath.ToSlash(rel)] = struct{}{}
+		return copyFile(path, filepath.Join(dst, rel))
+	})
+	if err != nil {
+		return err
+	}
+
+	return pruneGenerated(dst, keep)
+}
+
+// runGit runs git in dir and returns its output. Prompts are disabled so a
+// missing credential fails the build instead of hanging it.
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stdout.String(), fmt.Errorf("git %s: %v: %s", args[0], err, msg)
+		}
+		return stdout.String(), fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return stdout.String(), nil
+}
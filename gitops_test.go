@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIsRemoteRepo(t *testing.T) {
+	tests := []struct {
+		repo string
+		want bool
+	}{
+		{"https://example.com/configs.git", true},
+		{"ssh://git@example.com/configs.git", true},
+		{"git@example.com:configs.git", true},
+		{"/srv/sing-box-configs", false},
+		{"configs", false},
+		{"./a:b", false},
+		{`C:\configs`, false},
+	}
+
+	for _, tt := range tests {
+		if got := isRemoteRepo(tt.repo); got != tt.want {
+			t.Errorf("isRemoteRepo(%q) = %v, want %v", tt.repo, got, tt.want)
+		}
+	}
+}
+
+func TestMirrorDir(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	writeFiles := func(dir string, files map[string]string) {
+		t.Helper()
+		for name, data := range files {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	files := func(dir string) []string {
+		t.Helper()
+		var names []string
+		err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				rel, _ := filepath.Rel(dir, path)
+				names = append(names, filepath.ToSlash(rel))
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+
+	// the repository already holds content of its own
+	writeFiles(dst, map[string]string{"README.md": "configs", "docs/setup.md": "", ".git/HEAD": "ref: refs/heads/main"})
+	writeFiles(src, map[string]string{"outbounds.json": "{}", "route.json": "{}", "rulesets/geoip-cn.srs": "", generatedManifest: "outbounds.json\n"})
+
+	if err := mirrorDir(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".git/HEAD", generatedManifest, "README.md", "docs/setup.md", "outbounds.json", "route.json", "rulesets/geoip-cn.srs"}
+	if got := files(dst); !slices.Equal(got, want) {
+		t.Fatalf("first mirror left %v, want %v", got, want)
+	}
+
+	// the next export switches layout and drops the rule-set
+	for _, name := range []string{"outbounds.json", "rulesets/geoip-cn.srs"} {
+		if err := os.Remove(filepath.Join(src, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFiles(src, map[string]string{"servers.json": "{}"})
+
+	if err := mirrorDir(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{".git/HEAD", generatedManifest, "README.md", "docs/setup.md", "route.json", "servers.json"}
+	if got := files(dst); !slices.Equal(got, want) {
+		t.Errorf("second mirror left %v, want %v", got, want)
+	}
+}
+
+func TestExportGitLocalRepo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	repo, exportDir := t.TempDir(), t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := runGit(t.Context(), repo, append([]string{"-c", "user.name=t", "-c", "user.email=t@localhost"}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	git("init", "-q", "-b", "main")
+	if err := os.WriteFile(filepath.Join(repo, "README.md"), []byte("configs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "README.md")
+	git("commit", "-q", "-m", "initial")
+
+	if err := os.WriteFile(filepath.Join(exportDir, "outbounds.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// no dir, the configs go to the root of the repository
+	cfg := &GitConfig{Repo: repo}
+	if err := exportGit(t.Context(), cfg, t.TempDir(), exportDir); err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Fields(git("ls-files"))
+	if want := []string{generatedManifest, "README.md", "outbounds.json"}; !slices.Equal(got, want) {
+		t.Errorf("repository holds %v, want %v", got, want)
+	}
+	if log := git("log", "--format=%s"); log != "Update sing-box configs\ninitial\n" {
+		t.Errorf("git log = %q", log)
+	}
+}
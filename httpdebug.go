@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sensitiveHeaders never have their values logged.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+}
+
+// redactURL strips credentials and query values, which is where providers
+// usually put subscription tokens.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[unparsable url]"
+	}
+
+	if u.User != nil {
+		u.User = url.User("redacted")
+	}
+
+	q := u.Query()
+	for key := range q {
+		q.Set(key, "redacted")
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+func logHeaders(prefix string, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := strings.Join(h[key], ", ")
+		if sensitiveHeaders[http.CanonicalHeaderKey(key)] {
+			value = "[redacted]"
+		}
+		log.Printf("%s %s: %s", prefix, key, value)
+	}
+}
+
+// traceRequest attaches an httptrace to req that logs DNS results,
+// connection reuse, TLS parameters and timings relative to the request start.
+func traceRequest(req *http.Request) *http.Request {
+	start := time.Now()
+	since := func() string {
+		return time.Since(start).Round(time.Millisecond).String()
+	}
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			log.Printf("http: [%s] resolving %s", since(), info.Host)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			addrs := make([]string, 0, len(info.Addrs))
+			for _, addr := range info.Addrs {
+				addrs = append(addrs, addr.String())
+			}
+			log.Printf("http: [%s] resolved to %s (err: %v)", since(), strings.Join(addrs, ", "), info.Err)
+		},
+		ConnectStart: func(network, addr string) {
+			log.Printf("http: [%s] connecting to %s %s", since(), network, addr)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			log.Printf("http: [%s] connected to %s %s (err: %v)", since(), network, addr, err)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			log.Printf("http: [%s] using connection to %s (reused: %t)", since(), info.Conn.RemoteAddr(), info.Reused)
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			log.Printf("http: [%s] tls %s, cipher %s, server name %q, alpn %q (err: %v)", since(),
+				tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite),
+				state.ServerName, state.NegotiatedProtocol, err)
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			log.Printf("http: [%s] request sent (err: %v)", since(), info.Err)
+		},
+		GotFirstResponseByte: func() {
+			log.Printf("http: [%s] first response byte", since())
+		},
+	}
+
+	log.Printf("http: > %s %s", req.Method, redactURL(req.URL.String()))
+	logHeaders("http: >", req.Header)
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+func logResponse(resp *http.Response) {
+	log.Printf("http: < %s %s", resp.Proto, resp.Status)
+	logHeaders("http: <", resp.Header)
+}
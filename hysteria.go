@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// parseHysteriaURL reads a Hysteria (v1) link:
+//
+//	hysteria://host:port?protocol=udp&auth=secret&peer=sni&insecure=1&upmbps=50&downmbps=100&alpn=hysteria&obfs=xplus&obfsParam=key#tag
+//
+// sing-box needs both bandwidths, so links without them are rejected rather
+// than generated in a state sing-box refuses to start with.
+func parseHysteriaURL(u *url.URL) (*ServerOutbound, error) {
+	host, port, err := linkEndpoint(u)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+
+	// faketcp and wechat-video only exist in the original client
+	if protocol := q.Get("protocol"); protocol != "" && protocol != "udp" {
+		return nil, fmt.Errorf("unsupported protocol %q for %s, sing-box only speaks udp", protocol, host)
+	}
+
+	up, err := hysteriaMbps(q, "upmbps")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", host, err)
+	}
+	down, err := hysteriaMbps(q, "downmbps")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", host, err)
+	}
+
+	ob := &ServerOutbound{
+		BaseOutbound: BaseOutbound{Type: "hysteria"},
+		Server:       host,
+		ServerPort:   port,
+		AuthStr:      q.Get("auth"),
+		UpMbps:       up,
+		DownMbps:     down,
+		TLS: &TLSOptions{
+			Enabled:    true,
+			ServerName: q.Get("peer"),
+			Insecure:   q.Get("insecure") == "1" || q.Get("insecure") == "true",
+			ALPN:       parseALPN(q),
+		},
+	}
+
+	switch obfs := q.Get("obfs"); obfs {
+	case "":
+	case "xplus":
+		ob.Obfs.Value = q.Get("obfsParam")
+	default:
+		return nil, fmt.Errorf("unsupported obfs %q for %s", obfs, host)
+	}
+
+	return ob, nil
+}
+
+func hysteriaMbps(q url.Values, name string) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, fmt.Errorf("missing %s", name)
+	}
+
+	mbps, err := strconv.Atoi(v)
+	if err != nil || mbps <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return mbps, nil
+}
+
+// hysteriaLink is the inverse of parseHysteriaURL.
+func hysteriaLink(ob *ServerOutbound) string {
+	q := url.Values{}
+	q.Set("protocol", "udp")
+	if ob.AuthStr != "" {
+		q.Set("auth", ob.AuthStr)
+	}
+	q.Set("upmbps", strconv.Itoa(ob.UpMbps))
+	q.Set("downmbps", strconv.Itoa(ob.DownMbps))
+	if ob.Obfs.Value != "" {
+		q.Set("obfs", "xplus")
+		q.Set("obfsParam", ob.Obfs.Value)
+	}
+	if tls := ob.TLS; tls != nil {
+		if tls.ServerName != "" {
+			q.Set("peer", tls.ServerName)
+		}
+		if tls.Insecure {
+			q.Set("insecure", "1")
+		}
+		setALPNParam(q, tls)
+	}
+
+	u := url.URL{
+		Scheme:   "hysteria",
+		Host:     net.JoinHostPort(ob.Server, strconv.Itoa(ob.ServerPort)),
+		RawQuery: q.Encode(),
+		Fragment: ob.Tag,
+	}
+	return u.String()
+}
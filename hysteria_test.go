@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestParseHysteriaURL(t *testing.T) {
+	runLinkTests(t, []linkTest{
+		{
+			name: "full",
+			link: "hysteria://hy.example.com:443?protocol=udp&auth=secret&peer=sni.example.com&insecure=1&upmbps=50&downmbps=100&alpn=hysteria&obfs=xplus&obfsParam=key#HY%2001",
+			want: `{"type": "hysteria", "tag": "HY 01", "server": "hy.example.com", "server_port": 443, "obfs": "key", "auth_str": "secret",
+				"up_mbps": 50, "down_mbps": 100, "tls": {"enabled": true, "server_name": "sni.example.com", "insecure": true, "alpn": ["hysteria"]}}`,
+		},
+		{
+			name: "minimal",
+			link: "hysteria://hy.example.com:443?upmbps=50&downmbps=100#HY",
+			want: `{"type": "hysteria", "tag": "HY", "server": "hy.example.com", "server_port": 443, "up_mbps": 50, "down_mbps": 100,
+				"tls": {"enabled": true, "insecure": false}}`,
+		},
+		{name: "faketcp", link: "hysteria://hy.example.com:443?protocol=faketcp&upmbps=50&downmbps=100"},
+		{name: "no down bandwidth", link: "hysteria://hy.example.com:443?upmbps=50"},
+		{name: "zero bandwidth", link: "hysteria://hy.example.com:443?upmbps=0&downmbps=100"},
+		{name: "unknown obfs", link: "hysteria://hy.example.com:443?upmbps=50&downmbps=100&obfs=salamander"},
+		{name: "no port", link: "hysteria://hy.example.com?upmbps=50&downmbps=100"},
+	})
+}
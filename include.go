@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+)
+
+// includeKey is the directive that pulls another file into a scheme, e.g.
+// {"$include": "groups/streaming.json"}. Paths are relative to the file that
+// contains the directive.
+const includeKey = "$include"
+
+// resolveIncludes replaces every include directive in doc with the contents
+// of the referenced file. Inside arrays, an included array is spliced into
+// the surrounding one so a fragment can contribute several outbounds.
+func resolveIncludes(file string, doc any) (any, error) {
+	var errs ValidationErrors
+	resolved, _ := includeValue(file, "", doc, []string{file}, &errs)
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// includeValue returns the resolved value and whether it came from an
+// include directive, which decides if an array result should be spliced.
+func includeValue(file, pointer string, v any, stack []string, errs *ValidationErrors) (any, bool) {
+	switch t := v.(type) {
+	case map[string]any:
+		if target, ok := t[includeKey]; ok {
+			return includeFile(file, pointer, target, len(t), stack, errs), true
+		}
+
+		for key, value := range t {
+			t[key], _ = includeValue(file, pointerJoin(pointer, key), value, stack, errs)
+		}
+		return t, false
+	case []any:
+		result := make([]any, 0, len(t))
+		for i, item := range t {
+			value, included := includeValue(file, pointerJoin(pointer, i), item, stack, errs)
+			if arr, ok := value.([]any); ok && included {
+				result = append(result, arr...)
+				continue
+			}
+			result = append(result, value)
+		}
+		return result, false
+	}
+	return v, false
+}
+
+func includeFile(file, pointer string, target any, fields int, stack []string, errs *ValidationErrors) any {
+	p := pointerJoin(pointer, includeKey)
+
+	rel, ok := target.(string)
+	if !ok || rel == "" {
+		errs.add(file, p, target, "expected non-empty path")
+		return nil
+	}
+
+	if fields != 1 {
+		errs.add(file, pointer, nil, "%s must be the only key of its object", includeKey)
+		return nil
+	}
+
+	path := rel
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(file), rel)
+	}
+
+	if slices.Contains(stack, path) {
+		errs.add(file, p, rel, "include cycle: %v", append(stack, path))
+		return nil
+	}
+
+	data, err := readTemplate(path)
+	if err != nil {
+		if verrs, ok := err.(ValidationErrors); ok {
+			*errs = append(*errs, verrs...)
+		} else {
+			errs.add(file, p, rel, "%v", err)
+		}
+		return nil
+	}
+
+	doc, err := decodeDocument(path, data)
+	if err != nil {
+		*errs = append(*errs, err.(ValidationErrors)...)
+		return nil
+	}
+
+	resolved, _ := includeValue(path, "", doc, append(slices.Clip(stack), path), errs)
+	return resolved
+}
+
+// flattenIncludes resolves includes in doc and returns the combined
+// document re-encoded as JSON, ready for typed decoding.
+func flattenIncludes(file string, doc any) (any, []byte, error) {
+	resolved, err := resolveIncludes(file, doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := json.Marshal(resolved)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resolved, data, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const latencyFile = "latency.json"
+
+// LatencyRecord is the result of the last probe of a node, keyed by node id
+// in latency.json so that measurements survive provider renames.
+type LatencyRecord struct {
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// probeLatency measures how long a TCP connection to every node takes.
+// Unreachable nodes are left out of the result. Cancelling ctx aborts the
+// probes in flight and returns the context error.
+func probeLatency(ctx context.Context, outbounds []ServerOutbound) (map[string]LatencyRecord, error) {
+	const (
+		timeout     = 5 * time.Second
+		concurrency = 16
+	)
+
+	results := make(map[string]LatencyRecord)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	dialer := &net.Dialer{Timeout: timeout}
+
+	bar := startProgress("probing", len(outbounds))
+	defer bar.finish()
+
+	for _, ob := range outbounds {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+
+		go func(ob ServerOutbound) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer bar.add(1)
+
+			start := time.Now()
+			conn, err := dialer.DialContext(ctx, "tcp", outboundKey(ob.Server, ob.ServerPort))
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("probe %s failed: %v", ob.Tag, err)
+				return
+			}
+			conn.Close()
+
+			mu.Lock()
+			results[ob.ID] = LatencyRecord{
+				LatencyMS: time.Since(start).Milliseconds(),
+				CheckedAt: start.UTC(),
+			}
+			mu.Unlock()
+		}(ob)
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("probed %d nodes, %d reachable", len(outbounds), len(results))
+	return results, nil
+}
+
+func saveLatency(stateDir string, results map[string]LatencyRecord) error {
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(stateDir, latencyFile), results)
+}
+
+// loadLatency returns the last recorded measurements, or an empty map when
+// no probe has run yet.
+func loadLatency(stateDir string) (map[string]LatencyRecord, error) {
+	results := make(map[string]LatencyRecord)
+
+	data, err := os.ReadFile(filepath.Join(stateDir, latencyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return results, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// LatencyConfig turns the last measurements into tag annotations and a
+// group of fast nodes. Nodes without a measurement are left alone.
+type LatencyConfig struct {
+	// Tiers are checked in order and the first one at or above a node's
+	// latency appends its marker to the tag, e.g. "⚡" or "[<80ms]".
+	Tiers []LatencyTier `json:"tiers,omitempty"`
+	// FastMaxMS enables a urltest group of every node at or below it.
+	FastMaxMS int64  `json:"fast_max_ms,omitempty"`
+	FastGroup string `json:"fast_group,omitempty"`
+}
+
+type LatencyTier struct {
+	MaxMS  int64  `json:"max_ms"`
+	Marker string `json:"marker"`
+}
+
+func (c *LatencyConfig) fastGroup() string {
+	if c.FastGroup == "" {
+		return "Fast"
+	}
+	return c.FastGroup
+}
+
+// latencyTag appends the marker of the tier ob falls into.
+func latencyTag(tiers []LatencyTier, ob *ServerOutbound, latency map[string]LatencyRecord) string {
+	rec, ok := latency[ob.ID]
+	if !ok {
+		return ob.Tag
+	}
+	for _, tier := range tiers {
+		if rec.LatencyMS <= tier.MaxMS {
+			return ob.Tag + " " + tier.Marker
+		}
+	}
+	return ob.Tag
+}
+
+// fastTags returns the tags of the nodes measured at or below the ceiling.
+// They keep the node order, since urltest picks among them anyway and
+// ordering by latency would change the output on every probe.
+func fastTags(c *LatencyConfig, outbounds []ServerOutbound, latency map[string]LatencyRecord) []string {
+	if c.FastMaxMS == 0 {
+		return nil
+	}
+
+	var tags []string
+	for _, ob := range outbounds {
+		if rec, ok := latency[ob.ID]; ok && rec.LatencyMS <= c.FastMaxMS {
+			tags = append(tags, ob.Tag)
+		}
+	}
+	return tags
+}
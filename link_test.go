@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// linkTest is one share link and the sing-box outbound it should become,
+// written as JSON. An empty want expects the link to be rejected.
+type linkTest struct {
+	name string
+	link string
+	want string
+}
+
+// runLinkTests parses every link and compares the outbound with want. Nodes
+// that have a share link format must also survive being written back out
+// as a link and parsed again.
+func runLinkTests(t *testing.T, tests []linkTest) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob, err := parseShareLink(tt.link)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("parseShareLink() = %s, want an error", mustJSON(t, ob))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ob.ID == "" {
+				t.Error("node has no id")
+			}
+			assertJSON(t, "parseShareLink()", ob, tt.want)
+
+			link, err := shareLink(ob)
+			if err != nil {
+				return
+			}
+			again, err := parseShareLink(link)
+			if err != nil {
+				t.Fatalf("parsing %s again: %v", link, err)
+			}
+			if again.ID != ob.ID {
+				t.Errorf("id changed from %s to %s through %s", ob.ID, again.ID, link)
+			}
+			assertJSON(t, "link "+link, again, tt.want)
+		})
+	}
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// assertJSON compares v, marshaled, with the JSON document want.
+func assertJSON(t *testing.T, what string, v any, want string) {
+	t.Helper()
+
+	got := mustJSON(t, v)
+	var gotDoc, wantDoc any
+	if err := json.Unmarshal(got, &gotDoc); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantDoc); err != nil {
+		t.Fatalf("invalid want: %v", err)
+	}
+	if !reflect.DeepEqual(gotDoc, wantDoc) {
+		t.Errorf("%s =\n%s\nwant\n%s", what, got, want)
+	}
+}
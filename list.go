@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+type listOptions struct {
+	Config   configOptions
+	Region   string
+	Proto    string
+	JSON     bool
+	Cached   bool
+	StateDir string
+	Fetch    fetchOptions
+}
+
+// ListEntry is one row of the node inventory.
+type ListEntry struct {
+	ID         string `json:"id"`
+	Tag        string `json:"tag"`
+	Region     string `json:"region"`
+	Type       string `json:"type"`
+	Source     string `json:"source"`
+	Server     string `json:"server"`
+	ServerPort int    `json:"server_port"`
+	LatencyMS  *int64 `json:"latency_ms,omitempty"`
+}
+
+func listCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	opts := &listOptions{}
+
+	registerConfigFlags(fs, &opts.Config)
+	fs.StringVar(&opts.Region, "region", "", "only list nodes whose region matches (case-insensitive)")
+	fs.StringVar(&opts.Proto, "proto", "", "only list nodes of this protocol")
+	fs.BoolVar(&opts.JSON, "json", false, "print nodes as JSON instead of a table")
+	fs.BoolVar(&opts.Cached, "cached", false, "read the subscription cached by the last build instead of fetching")
+	fs.StringVar(&opts.StateDir, "state-dir", "state", "directory holding the subscription cache and latency data")
+	registerFetchFlags(fs, &opts.Fetch)
+
+	return func(ctx context.Context) error {
+		return list(ctx, opts)
+	}
+}
+
+func list(ctx context.Context, opts *listOptions) error {
+	cfg, err := loadConfig(&opts.Config)
+	if err != nil {
+		return err
+	}
+
+	var payloads []sourcePayload
+	if opts.Cached {
+		var sources []SourceConfig
+		sources, err = configuredSources(cfg)
+		if err == nil {
+			payloads, err = loadCachedSources(sources, opts.StateDir, false)
+		}
+	} else {
+		payloads, err = loadSources(ctx, cfg, opts.StateDir, &opts.Fetch)
+	}
+	if err != nil {
+		return err
+	}
+
+	outbounds, err := parseSources(ctx, payloads, &cfg.Subconverter)
+	if err != nil {
+		return err
+	}
+	groupRegions(outbounds)
+
+	latency, err := loadLatency(opts.StateDir)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]ListEntry, 0, len(outbounds))
+
+	for _, ob := range outbounds {
+		if opts.Region != "" && !strings.EqualFold(ob.Region, opts.Region) {
+			continue
+		}
+		if opts.Proto != "" && !strings.EqualFold(ob.Type, opts.Proto) {
+			continue
+		}
+
+		entry := ListEntry{
+			ID:         ob.ID,
+			Tag:        ob.Tag,
+			Region:     ob.Region,
+			Type:       ob.Type,
+			Source:     ob.Source,
+			Server:     ob.Server,
+			ServerPort: ob.ServerPort,
+		}
+
+		if rec, ok := latency[ob.ID]; ok {
+			entry.LatencyMS = &rec.LatencyMS
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if opts.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTAG\tREGION\tPROTO\tSOURCE\tSERVER\tPORT\tLATENCY")
+
+	for _, e := range entries {
+		latency := "-"
+		if e.LatencyMS != nil {
+			latency = strconv.FormatInt(*e.LatencyMS, 10) + "ms"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", e.ID, e.Tag, e.Region, e.Type, e.Source, e.Server, e.ServerPort, latency)
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+// LogConfig picks where log messages go. Routers running the daemon often
+// have nothing capturing stderr, so syslog and journald are available too.
+type LogConfig struct {
+	Output string `json:"output,omitempty"`
+}
+
+var logOutputs = []string{"", "stderr", "syslog", "journald"}
+
+// Syslog priorities, shared by the syslog and journald sinks.
+const (
+	priorityErr     = 3
+	priorityWarning = 4
+	priorityInfo    = 6
+)
+
+// logPriority derives a priority from a message, since msbc logs through
+// the standard logger without levels.
+func logPriority(msg string) int {
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.HasPrefix(lower, "warning:"):
+		return priorityWarning
+	case strings.Contains(lower, "failed") || strings.Contains(lower, "error"):
+		return priorityErr
+	}
+	return priorityInfo
+}
+
+// prioritySink adapts a sink taking one message with its priority to the
+// io.Writer the standard logger writes to.
+type prioritySink func(priority int, msg string) error
+
+func (s prioritySink) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	if err := s(logPriority(msg), msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+var currentLogOutput string
+
+// setupLogging switches the standard logger to the configured output. It
+// is called whenever the config is loaded and does nothing when the output
+// did not change.
+func setupLogging(cfg LogConfig) error {
+	output := cfg.Output
+	if output == "" {
+		output = "stderr"
+	}
+	if output == currentLogOutput {
+		return nil
+	}
+
+	var sink prioritySink
+	var err error
+
+	switch output {
+	case "stderr":
+		log.SetOutput(stderrWriter())
+		log.SetFlags(log.LstdFlags)
+		currentLogOutput = output
+		return nil
+	case "syslog":
+		sink, err = syslogSink()
+	case "journald":
+		sink, err = journaldSink()
+	default:
+		return fmt.Errorf("unknown log output %q", output)
+	}
+	if err != nil {
+		return fmt.Errorf("log output %s: %v", output, err)
+	}
+
+	// both add their own timestamps
+	log.SetFlags(0)
+	log.SetOutput(sink)
+	currentLogOutput = output
+	return nil
+}
+
+// stderrWriter decorates stderr when it is a terminal.
+func stderrWriter() io.Writer {
+	if stderrTerminal != nil {
+		return stderrTerminal
+	}
+	return os.Stderr
+}
+
+func validLogOutput(output string) bool {
+	return slices.Contains(logOutputs, output)
+}
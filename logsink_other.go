@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+func syslogSink() (prioritySink, error) {
+	return nil, errors.New("not available on this platform")
+}
+
+func journaldSink() (prioritySink, error) {
+	return nil, errors.New("not available on this platform")
+}
@@ -0,0 +1,58 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/syslog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+func syslogSink() (prioritySink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "msbc")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(priority int, msg string) error {
+		switch priority {
+		case priorityErr:
+			return w.Err(msg)
+		case priorityWarning:
+			return w.Warning(msg)
+		}
+		return w.Info(msg)
+	}, nil
+}
+
+const journalSocket = "/run/systemd/journal/socket"
+
+// journaldSink speaks the native journal protocol, which unlike stdout
+// capture keeps the priority of every message.
+func journaldSink() (prioritySink, error) {
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(priority int, msg string) error {
+		var b bytes.Buffer
+		b.WriteString("PRIORITY=" + strconv.Itoa(priority) + "\n")
+		b.WriteString("SYSLOG_IDENTIFIER=msbc\n")
+
+		if strings.Contains(msg, "\n") {
+			// multi-line values are sent length-prefixed
+			b.WriteString("MESSAGE\n")
+			binary.Write(&b, binary.LittleEndian, uint64(len(msg)))
+			b.WriteString(msg + "\n")
+		} else {
+			b.WriteString("MESSAGE=" + msg + "\n")
+		}
+
+		_, err := conn.Write(b.Bytes())
+		return err
+	}, nil
+}
@@ -1,17 +1,20 @@
 package main
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
-	"io"
+	"flag"
+	"fmt"
 	"log"
-	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
-	"time"
+	"syscall"
 	"unicode"
 )
 
@@ -20,17 +23,187 @@ type BaseOutbound struct {
 	Tag  string `json:"tag"`
 }
 
-type TrojanOutbound struct {
+// ServerOutbound is a node of any supported protocol. Fields that do not
+// apply to Type stay empty and are left out of the JSON.
+type ServerOutbound struct {
 	BaseOutbound
 
+	ID     string `json:"-"`
+	Region string `json:"-"`
+	Source string `json:"-"`
+	// Addrs holds the server's addresses when the resolution stage ran.
+	Addrs []netip.Addr `json:"-"`
+
 	Server     string `json:"server"`
 	ServerPort int    `json:"server_port"`
-	Password   string `json:"password"`
-	TLS        struct {
-		Enabled    bool   `json:"enabled"`
-		ServerName string `json:"server_name,omitempty"`
-		Insecure   bool   `json:"insecure"`
-	} `json:"tls"`
+	Username   string `json:"username,omitempty"`
+	Password   string `json:"password,omitempty"`
+
+	// socks
+	Version string `json:"version,omitempty"`
+
+	// http and naive
+	Headers      map[string]string `json:"headers,omitempty"`
+	ExtraHeaders map[string]string `json:"extra_headers,omitempty"`
+	QUIC         bool              `json:"quic,omitempty"`
+
+	// shadowsocks
+	Method     string `json:"method,omitempty"`
+	Plugin     string `json:"plugin,omitempty"`
+	PluginOpts string `json:"plugin_opts,omitempty"`
+
+	// shadowsocksr
+	Protocol      string      `json:"protocol,omitempty"`
+	ProtocolParam string      `json:"protocol_param,omitempty"`
+	Obfs          ObfsOptions `json:"obfs,omitzero"`
+	ObfsParam     string      `json:"obfs_param,omitempty"`
+
+	// hysteria
+	AuthStr  string `json:"auth_str,omitempty"`
+	UpMbps   int    `json:"up_mbps,omitempty"`
+	DownMbps int    `json:"down_mbps,omitempty"`
+
+	// wireguard; AllowedIPs and Keepalive only exist in the endpoint form
+	LocalAddress  []string `json:"local_address,omitempty"`
+	PrivateKey    string   `json:"private_key,omitempty"`
+	PeerPublicKey string   `json:"peer_public_key,omitempty"`
+	PreSharedKey  string   `json:"pre_shared_key,omitempty"`
+	Reserved      []int    `json:"reserved,omitempty"`
+	MTU           int      `json:"mtu,omitempty"`
+	AllowedIPs    []string `json:"-"`
+	Keepalive     int      `json:"-"`
+	// Endpoint writes the node to "endpoints" rather than "outbounds".
+	Endpoint bool `json:"-"`
+
+	// anytls
+	IdleSessionCheckInterval Duration `json:"idle_session_check_interval,omitempty"`
+	IdleSessionTimeout       Duration `json:"idle_session_timeout,omitempty"`
+	MinIdleSession           int      `json:"min_idle_session,omitempty"`
+
+	// ssh; the private key goes in PrivateKey
+	User                 string   `json:"user,omitempty"`
+	PrivateKeyPath       string   `json:"private_key_path,omitempty"`
+	PrivateKeyPassphrase string   `json:"private_key_passphrase,omitempty"`
+	HostKey              []string `json:"host_key,omitempty"`
+	HostKeyAlgorithms    []string `json:"host_key_algorithms,omitempty"`
+	ClientVersion        string   `json:"client_version,omitempty"`
+
+	// mieru, only shown by parse since sing-box lacks the outbound
+	Multiplexing string `json:"multiplexing,omitempty"`
+
+	// tuic
+	UUID              string `json:"uuid,omitempty"`
+	CongestionControl string `json:"congestion_control,omitempty"`
+	UDPRelayMode      string `json:"udp_relay_mode,omitempty"`
+
+	TLS       *TLSOptions       `json:"tls,omitempty"`
+	Transport *TransportOptions `json:"transport,omitempty"`
+	Multiplex *MultiplexOptions `json:"multiplex,omitempty"`
+
+	RoutingMark uint32 `json:"routing_mark,omitempty"`
+	// Detour is set from ShadowTLS when the node is written.
+	Detour    string            `json:"detour,omitempty"`
+	ShadowTLS *ShadowTLSOptions `json:"-"`
+
+	// Extra holds the fields of an outbound converted by the subconverter
+	// that msbc does not model; they are written out unchanged.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// MarshalJSON appends Extra, in key order, after the modeled fields.
+// Endpoints have a shape of their own.
+func (ob ServerOutbound) MarshalJSON() ([]byte, error) {
+	if ob.Endpoint {
+		return json.Marshal(ob.wireguardEndpoint())
+	}
+	if ob.ShadowTLS != nil {
+		ob.Detour = shadowTLSTag(ob.Tag)
+	}
+
+	type plain ServerOutbound
+	data, err := json.Marshal(plain(ob))
+	if err != nil || len(ob.Extra) == 0 {
+		return data, err
+	}
+
+	keys := make([]string, 0, len(ob.Extra))
+	for key := range ob.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	data = data[:len(data)-1]
+	for _, key := range keys {
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, ',')
+		data = append(data, name...)
+		data = append(data, ':')
+		data = append(data, ob.Extra[key]...)
+	}
+	return append(data, '}'), nil
+}
+
+// ObfsOptions is sing-box's obfs option, a string for shadowsocksr (the
+// plugin) and hysteria (the password) but a block for hysteria2.
+type ObfsOptions struct {
+	Value string
+
+	Type     string
+	Password string
+}
+
+func (o ObfsOptions) MarshalJSON() ([]byte, error) {
+	if o.Type == "" {
+		return json.Marshal(o.Value)
+	}
+	return json.Marshal(struct {
+		Type     string `json:"type"`
+		Password string `json:"password"`
+	}{o.Type, o.Password})
+}
+
+func (o *ObfsOptions) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &o.Value); err == nil {
+		return nil
+	}
+
+	var block struct {
+		Type     string `json:"type"`
+		Password string `json:"password"`
+	}
+	if err := json.Unmarshal(data, &block); err != nil {
+		return err
+	}
+	o.Type, o.Password = block.Type, block.Password
+	return nil
+}
+
+type TLSOptions struct {
+	Enabled    bool     `json:"enabled"`
+	ServerName string   `json:"server_name,omitempty"`
+	Insecure   bool     `json:"insecure"`
+	ALPN       []string `json:"alpn,omitempty"`
+
+	CertificatePublicKeySHA256 []string `json:"certificate_public_key_sha256,omitempty"`
+
+	ECH     *ECHOptions     `json:"ech,omitempty"`
+	UTLS    *UTLSOptions    `json:"utls,omitempty"`
+	Reality *RealityOptions `json:"reality,omitempty"`
+
+	Fragment              bool     `json:"fragment,omitempty"`
+	FragmentFallbackDelay Duration `json:"fragment_fallback_delay,omitempty"`
+	RecordFragment        bool     `json:"record_fragment,omitempty"`
+}
+
+// ECHOptions is sing-box's tls.ech block. Without Config, sing-box looks the
+// ECH config up in the server's DNS HTTPS record.
+type ECHOptions struct {
+	Enabled    bool     `json:"enabled"`
+	Config     []string `json:"config,omitempty"`
+	ConfigPath string   `json:"config_path,omitempty"`
 }
 
 type SelectorOutbound struct {
@@ -42,6 +215,10 @@ type SelectorOutbound struct {
 type GroupOutbound struct {
 	SelectorOutbound
 
+	// urltest only
+	Interval  Duration `json:"interval,omitempty"`
+	Tolerance int      `json:"tolerance,omitempty"`
+
 	InterruptExistConnections bool `json:"interrupt_exist_connections"`
 }
 
@@ -53,288 +230,262 @@ func outboundKey(server string, port int) string {
 	return server + ":" + strconv.Itoa(port)
 }
 
-func parseTrojanURL(raw string) (*TrojanOutbound, error) {
+// supportedTypes are the sing-box outbound types parseShareLink produces and
+// msbc therefore models, keep it in step with the schemes below.
+var supportedTypes = map[string]bool{
+	"trojan":       true,
+	"shadowsocks":  true,
+	"shadowsocksr": true,
+	"hysteria":     true,
+	"hysteria2":    true,
+	"tuic":         true,
+	"wireguard":    true,
+	"socks":        true,
+	"http":         true,
+	"naive":        true,
+	"anytls":       true,
+	"ssh":          true,
+}
+
+// parseShareLink converts a share link into an outbound. Links of schemes
+// msbc does not support yield nil without an error.
+func parseShareLink(raw string) (*ServerOutbound, error) {
 	u, err := url.Parse(strings.TrimSpace(raw))
 	if err != nil {
 		return nil, err
 	}
 
-	if u.Scheme != "trojan" {
+	var ob *ServerOutbound
+
+	switch u.Scheme {
+	case "trojan":
+		ob, err = parseTrojanURL(u)
+	case "ss":
+		ob, err = parseShadowsocksURL(u)
+	case "ssr":
+		ob, err = parseShadowsocksRURL(u)
+	case "hysteria":
+		ob, err = parseHysteriaURL(u)
+	case "hysteria2", "hy2":
+		ob, err = parseHysteria2URL(u)
+	case "tuic":
+		ob, err = parseTUICURL(u)
+	case "wireguard", "wg":
+		ob, err = parseWireGuardURL(u)
+	case "socks", "socks5", "socks5h", "socks4", "socks4a":
+		ob, err = parseSocksURL(u)
+	case "http", "https":
+		ob, err = parseHTTPProxyURL(u)
+	case "naive+https", "naive+quic":
+		ob, err = parseNaiveURL(u)
+	case "anytls":
+		ob, err = parseAnyTLSURL(u)
+	case "snell":
+		ob, err = parseSnellURL(u)
+	case "mierus", "mieru":
+		ob, err = parseMieruURL(u)
+	case "juicity":
+		ob, err = parseJuicityURL(u)
+	case "brook":
+		ob, err = parseBrookURL(u)
+	case "ssh":
+		ob, err = parseSSHURL(u)
+	default:
 		log.Printf("Unsupported scheme: %s", u.Scheme)
 		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
 
-	password := u.User.Username()
+	// ssr links carry their tag inside the encoded part
+	if ob.Tag == "" {
+		ob.Tag = linkTag(u)
+	}
+	ob.ID = nodeID(ob.Type, ob.Server, ob.ServerPort, ob.credential())
+	return ob, nil
+}
+
+// linkEndpoint returns the server and port of a share link.
+func linkEndpoint(u *url.URL) (string, int, error) {
 	host := u.Hostname()
 
 	portStr := u.Port()
 	if portStr == "" {
-		return nil, err
+		return "", 0, fmt.Errorf("missing port for %s", host)
 	}
 
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
-		return nil, err
+		return "", 0, err
 	}
 
-	q := u.Query()
-	allowInsecure := q.Get("allowInsecure") == "1"
-	sni := q.Get("sni")
+	return host, port, nil
+}
 
+func linkTag(u *url.URL) string {
 	rawTag := u.Fragment
 	rawTag = strings.TrimSpace(rawTag)
-	tag := strings.TrimSpace(removeEmoji(rawTag))
-
-	ob := &TrojanOutbound{
-		BaseOutbound: BaseOutbound{
-			Type: "trojan",
-			Tag:  tag,
-		},
-		Server:     host,
-		ServerPort: port,
-		Password:   password,
-	}
-
-	ob.TLS.Enabled = true
-	ob.TLS.ServerName = sni
-	ob.TLS.Insecure = allowInsecure
-
-	return ob, nil
+	return strings.TrimSpace(removeEmoji(rawTag))
 }
 
-func extractRegion(tag string) string {
-	fields := strings.Fields(tag)
-	if len(fields) <= 1 {
-		return tag
+func parseTrojanURL(u *url.URL) (*ServerOutbound, error) {
+	host, port, err := linkEndpoint(u)
+	if err != nil {
+		return nil, err
 	}
-	return strings.Join(fields[:len(fields)-1], " ")
-}
-
-type ServersConfig struct {
-	Outbounds []TrojanOutbound `json:"outbounds"`
-}
-
-type GroupsConfig struct {
-	Outbounds []GroupOutbound `json:"outbounds"`
-}
 
-func main() {
-	srvListURL := os.Getenv("SERVER_LIST_URL")
+	q := u.Query()
 
-	if srvListURL == "" {
-		log.Fatal("$SERVER_LIST_URL environment variable not set")
+	ob := &ServerOutbound{
+		BaseOutbound: BaseOutbound{Type: "trojan"},
+		Server:       host,
+		ServerPort:   port,
+		Password:     u.User.Username(),
+		TLS: &TLSOptions{
+			Enabled:    true,
+			ServerName: q.Get("sni"),
+			Insecure:   q.Get("allowInsecure") == "1",
+			ECH:        parseECH(q),
+			ALPN:       parseALPN(q),
+			UTLS:       parseUTLS(q),
+		},
 	}
 
-	log.Printf("fetching from %s", srvListURL)
-	client := &http.Client{
-		Timeout: 15 * time.Second,
-	}
+	parseReality(q, ob.TLS)
 
-	resp, err := client.Get(srvListURL)
-	if err != nil {
-		log.Fatal(err)
+	if ob.Transport, err = parseTransport(q); err != nil {
+		return nil, fmt.Errorf("%s: %v", host, err)
 	}
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			log.Fatal(err)
+	if v := q.Get("shadow-tls"); v != "" {
+		if ob.ShadowTLS, err = parseShadowTLSParam(v); err != nil {
+			return nil, fmt.Errorf("%s: %v", host, err)
 		}
-	}(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("unexpected HTTP status: %s", resp.Status)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal(err)
 	}
 
-	log.Printf("fetched %d bytes", len(body))
+	return ob, nil
+}
 
-	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(body)))
-	if err != nil {
-		log.Fatalf("base64 decode failed: %v", err)
+// parseECH reads the ech (or ech-config) parameter of a share link, which is
+// either a flag such as "1" or a base64 ECHConfigList.
+func parseECH(q url.Values) *ECHOptions {
+	v := q.Get("ech")
+	if v == "" {
+		v = q.Get("ech-config")
 	}
 
-	lines := strings.Split(string(decoded), "\n")
-	log.Printf("decoded %d lines", len(lines))
-
-	outbounds := make([]TrojanOutbound, 0)
-	indexMap := make(map[string]int)
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		ob, err := parseTrojanURL(line)
-		if err != nil {
-			log.Printf("skipping invalid line: %v", err)
-			continue
-		}
-		if ob == nil {
-			continue
-		}
-
-		key := outboundKey(ob.Server, ob.ServerPort)
-
-		if idx, exists := indexMap[key]; exists {
-			outbounds[idx] = *ob
-		} else {
-			indexMap[key] = len(outbounds)
-			outbounds = append(outbounds, *ob)
-		}
+	switch strings.ToLower(v) {
+	case "", "0", "false":
+		return nil
+	case "1", "true":
+		return &ECHOptions{Enabled: true}
 	}
 
-	log.Printf("parsed %d unique servers", len(outbounds))
+	// unescaped '+' in base64 arrives as a space
+	v = strings.ReplaceAll(v, " ", "+")
 
-	regionTags := make(map[string][]string)
-	regionIndex := make(map[string]int)
-	regionOrder := make([]string, 0)
-
-	for _, ob := range outbounds {
-		region := extractRegion(ob.Tag)
-
-		if _, exists := regionIndex[region]; !exists {
-			regionIndex[region] = len(regionOrder)
-			regionOrder = append(regionOrder, region)
-		}
+	return &ECHOptions{
+		Enabled: true,
+		Config:  []string{"-----BEGIN ECH CONFIGS-----", v, "-----END ECH CONFIGS-----"},
+	}
+}
 
-		regionTags[region] = append(regionTags[region], ob.Tag)
+func extractRegion(tag string) string {
+	fields := strings.Fields(tag)
+	if len(fields) <= 1 {
+		return tag
 	}
+	return strings.Join(fields[:len(fields)-1], " ")
+}
 
-	for region, tags := range regionTags {
-		if len(tags) == 1 {
-			originalTag := tags[0]
+type ServersConfig struct {
+	Outbounds []ServerOutbound `json:"outbounds"`
+	Endpoints []ServerOutbound `json:"endpoints,omitempty"`
+}
 
-			for i := range outbounds {
-				if outbounds[i].Tag == originalTag {
-					outbounds[i].Tag = region
-					break
-				}
-			}
+// MarshalJSON adds the outbounds the nodes detour through.
+func (c ServersConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Outbounds []any            `json:"outbounds"`
+		Endpoints []ServerOutbound `json:"endpoints,omitempty"`
+	}{expandOutbounds(c.Outbounds), c.Endpoints})
+}
 
-			regionTags[region][0] = region
-		}
-	}
+type GroupsConfig struct {
+	Outbounds []GroupOutbound `json:"outbounds"`
+}
 
-	cfg := ServersConfig{
-		Outbounds: outbounds,
-	}
+type command struct {
+	name    string
+	summary string
+	// setup registers the command's flags and returns the action to run
+	// once they have been parsed.
+	setup func(fs *flag.FlagSet) func(ctx context.Context) error
+	// args lists fixed positional arguments offered by shell completion.
+	args []string
+}
 
-	jsonBytes, err := json.MarshalIndent(cfg, "", "  ")
-	if err != nil {
-		log.Fatal(err)
+var commands []command
+
+// commands is filled in init because the completion command walks it.
+func init() {
+	commands = []command{
+		{name: "build", summary: "fetch the server list and generate sing-box configs", setup: buildCommand},
+		{name: "daemon", summary: "rebuild on a schedule, export, reload sing-box and serve /healthz", setup: daemonCommand},
+		{name: "list", summary: "print the parsed nodes without generating configs", setup: listCommand},
+		{name: "explain", summary: "show what the last build did to a node and why", setup: explainCommand},
+		{name: "parse", summary: "show how a single share link is converted", setup: parseCommand},
+		{name: "install", summary: "install configs staged by an unprivileged build and reload sing-box", setup: installCommand},
+		{name: "decrypt", summary: "export and reload configs encrypted by a build with encrypt.dir", setup: decryptCommand},
+		{name: "convert", summary: "convert a node list between formats", setup: convertCommand},
+		{name: "service", summary: "install or uninstall a macOS launchd job running msbc build on an interval", setup: serviceCommand, args: []string{"install", "uninstall"}},
+		{name: "openwrt", summary: "print the procd init script or a starting uci config", setup: openwrtCommand, args: []string{"init", "uci"}},
+		{name: "completion", summary: "print a shell completion script", setup: completionCommand, args: []string{"bash", "zsh", "fish"}},
 	}
+}
 
-	err = os.MkdirAll("config", 0755)
-	if err != nil {
-		log.Fatal(err)
-	}
+func main() {
+	args := os.Args[1:]
+	log.SetOutput(stderrWriter())
 
-	err = os.WriteFile("config/servers.json", jsonBytes, 0644)
-	if err != nil {
-		log.Fatal(err)
+	// no command (or only flags) keeps the original behavior of building
+	name := "build"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	log.Printf("wrote config/servers.json")
-
-	var groupOutbounds []GroupOutbound
-
-	for _, region := range regionOrder {
-		tags := regionTags[region]
-
-		if len(tags) <= 1 {
+	for _, cmd := range commands {
+		if cmd.name != name {
 			continue
 		}
 
-		autoTag := region + "-auto"
-
-		urltest := GroupOutbound{
-			SelectorOutbound: SelectorOutbound{
-				BaseOutbound: BaseOutbound{
-					Type: "urltest",
-					Tag:  autoTag,
-				},
-				Outbounds: tags,
-			},
-			InterruptExistConnections: false,
+		fs := flag.NewFlagSet("msbc "+cmd.name, flag.ExitOnError)
+		run := cmd.setup(fs)
+		if err := fs.Parse(args); err != nil {
+			log.Fatal(err)
 		}
-
-		selector := GroupOutbound{
-			SelectorOutbound: SelectorOutbound{
-				BaseOutbound: BaseOutbound{
-					Type: "selector",
-					Tag:  region,
-				},
-				Outbounds: append([]string{autoTag}, tags...),
-			},
-			InterruptExistConnections: true,
+		if err := applyUCIFlags(fs); err != nil {
+			log.Fatal(err)
 		}
 
-		groupOutbounds = append(groupOutbounds, urltest, selector)
-	}
-
-	log.Printf("parsed %d server groups", len(groupOutbounds))
-
-	groupsCfg := GroupsConfig{
-		Outbounds: groupOutbounds,
-	}
-
-	groupsJSON, err := json.MarshalIndent(groupsCfg, "", "  ")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = os.WriteFile("config/groups.json", groupsJSON, 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
+		// SIGTERM cancels in-flight work; files are only ever replaced
+		// atomically so an interrupted run leaves the previous output intact
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := run(ctx)
+		stop()
 
-	log.Printf("wrote config/groups.json")
-
-	selectors, err := loadSelectors("config/selectors.scheme.json")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	for i, ob := range selectors {
-		sel, ok := ob.(SelectorOutbound)
-		if !ok {
-			continue
+		if err != nil {
+			log.Fatal(err)
 		}
-
-		sel.Outbounds = appendUnique(sel.Outbounds, regionOrder)
-		selectors[i] = sel
-	}
-
-	selectorsCfg := struct {
-		Outbounds []any `json:"outbounds"`
-	}{
-		Outbounds: selectors,
+		return
 	}
 
-	data, err := json.MarshalIndent(selectorsCfg, "", "  ")
-	if err != nil {
-		log.Fatal(err)
+	log.Printf("unknown command %q, available commands:", name)
+	for _, cmd := range commands {
+		log.Printf("  %-8s %s", cmd.name, cmd.summary)
 	}
-
-	err = os.WriteFile("config/selectors.json", data, 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	log.Printf("wrote config/selectors.json")
-
-	err = exportConfig("config", "/etc/sing-box")
-	if err != nil {
-		log.Fatalf("failed to export configs: %v", err)
-	}
-
-	log.Printf("all done")
+	os.Exit(2)
 }
 
 func removeEmoji(s string) string {
@@ -347,7 +498,7 @@ func removeEmoji(s string) string {
 }
 
 func loadSelectors(path string) ([]any, error) {
-	data, err := os.ReadFile(path)
+	data, err := readTemplate(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
@@ -355,20 +506,34 @@ func loadSelectors(path string) ([]any, error) {
 		return nil, err
 	}
 
+	doc, err := decodeDocument(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, data, err = flattenIncludes(path, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateOutbounds(path, doc); err != nil {
+		return nil, err
+	}
+
 	var cfg SelectorsConfig
 	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, err
+		return nil, &ValidationError{File: path, Message: err.Error()}
 	}
 
 	var result []any
 
-	for _, raw := range cfg.Outbounds {
+	for i, raw := range cfg.Outbounds {
 		var base struct {
 			Type string `json:"type"`
 		}
 
 		if err := json.Unmarshal(raw, &base); err != nil {
-			return nil, err
+			return nil, &ValidationError{File: path, Pointer: pointerJoin("/outbounds", i), Message: err.Error()}
 		}
 
 		if base.Type != "selector" {
@@ -378,7 +543,7 @@ func loadSelectors(path string) ([]any, error) {
 
 		var sel SelectorOutbound
 		if err := json.Unmarshal(raw, &sel); err != nil {
-			return nil, err
+			return nil, &ValidationError{File: path, Pointer: pointerJoin("/outbounds", i), Message: err.Error()}
 		}
 
 		result = append(result, sel)
@@ -404,7 +569,14 @@ func appendUnique(dst []string, src []string) []string {
 	return dst
 }
 
-func exportConfig(srcDir, dstDir string) error {
+// exportConfig copies the configs in srcDir to dstDir. Cancellation is only
+// honoured before the first file is written, so an export is never left
+// half done.
+func exportConfig(ctx context.Context, srcDir, dstDir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	entries, err := os.ReadDir(srcDir)
 	if err != nil {
 		return err
@@ -414,38 +586,39 @@ func exportConfig(srcDir, dstDir string) error {
 		return err
 	}
 
+	exported := make(map[string]struct{})
+
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
 
 		name := entry.Name()
-		if strings.HasSuffix(name, ".scheme.json") {
+		// the manifest of srcDir describes srcDir, not dstDir
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".scheme.json") {
 			continue
 		}
+		exported[exportName(name)] = struct{}{}
 
 		srcPath := filepath.Join(srcDir, name)
-		dstPath := filepath.Join(dstDir, name)
+		dstPath := filepath.Join(dstDir, exportName(name))
 
-		srcFile, err := os.Open(srcPath)
-		if err != nil {
-			return err
-		}
-		defer srcFile.Close()
+		if isTemplate(name) {
+			if err := renderTemplate(srcPath, dstPath); err != nil {
+				return err
+			}
 
-		dstFile, err := os.Create(dstPath)
-		if err != nil {
-			return err
+			log.Printf("rendered %s -> %s", srcPath, dstPath)
+			continue
 		}
-		defer dstFile.Close()
 
-		_, err = io.Copy(dstFile, srcFile)
-		if err != nil {
+		if err := copyFile(srcPath, dstPath); err != nil {
 			return err
 		}
 
 		log.Printf("exported %s -> %s", srcPath, dstPath)
 	}
 
-	return nil
+	// generated files depend on the output layout, drop the ones no longer produced
+	return pruneGenerated(dstDir, exported)
 }
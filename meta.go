@@ -0,0 +1,157 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SourceMeta is what a provider tells us about its subscription besides the
+// nodes themselves, from response headers or directive comments in the list.
+type SourceMeta struct {
+	UpdateInterval Duration `json:"update_interval,omitempty"`
+	WebPageURL     string   `json:"web_page_url,omitempty"`
+	// Notes are the free-form comment, REMARKS= and STATUS= lines of the
+	// list, which providers use for announcements and plan status.
+	Notes []string `json:"notes,omitempty"`
+	// Usage is the plan status, when the provider reports it.
+	Usage *SourceUsage `json:"usage,omitempty"`
+	// ETag and LastModified validate the cached body on the next fetch.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// isMetadataLine reports whether a decoded line is a comment or metadata
+// line rather than a share link.
+func isMetadataLine(line string) bool {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "#") {
+		return true
+	}
+
+	key, _, ok := strings.Cut(line, "=")
+	if !ok {
+		return false
+	}
+	switch strings.ToUpper(key) {
+	case "REMARKS", "STATUS":
+		return true
+	}
+	return false
+}
+
+// metaFromHeader reads the Clash-style profile-* headers. The update
+// interval is given in hours.
+func metaFromHeader(h http.Header) SourceMeta {
+	var meta SourceMeta
+
+	if v := strings.TrimSpace(h.Get("Profile-Update-Interval")); v != "" {
+		if hours, err := strconv.ParseFloat(v, 64); err == nil && hours > 0 {
+			meta.UpdateInterval = Duration(time.Duration(hours * float64(time.Hour)))
+		}
+	}
+	meta.WebPageURL = strings.TrimSpace(h.Get("Profile-Web-Page-Url"))
+	meta.Usage = parseUserinfo(h.Get("Subscription-Userinfo"))
+	meta.ETag = h.Get("ETag")
+	meta.LastModified = h.Get("Last-Modified")
+
+	return meta
+}
+
+// metaFromLines reads directive comments from a decoded link list:
+//
+//	#!MANAGED-CONFIG https://example.com/sub interval=43200
+//	#profile-update-interval: 24
+//	#profile-web-page-url: https://example.com
+//
+// Any other metadata line is kept as a note. Plan status is also read from
+// pseudo-nodes, links whose tag only announces expiry or remaining traffic.
+func metaFromLines(lines []string) SourceMeta {
+	var meta SourceMeta
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if !isMetadataLine(line) {
+			if _, fragment, ok := strings.Cut(line, "#"); ok {
+				if tag, err := url.PathUnescape(fragment); err == nil {
+					meta.Usage = meta.Usage.merge(usageFromTag(tag))
+				}
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			meta.Notes = append(meta.Notes, line)
+			continue
+		}
+
+		if rest, ok := strings.CutPrefix(line, "#!MANAGED-CONFIG"); ok {
+			for _, field := range strings.Fields(rest) {
+				if v, ok := strings.CutPrefix(field, "interval="); ok {
+					if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+						meta.UpdateInterval = Duration(time.Duration(secs) * time.Second)
+					}
+				}
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, "#")), ":")
+		if !ok {
+			key, value, ok = strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, "#")), "=")
+		}
+
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "profile-update-interval":
+			if hours, err := strconv.ParseFloat(value, 64); err == nil && hours > 0 {
+				meta.UpdateInterval = Duration(time.Duration(hours * float64(time.Hour)))
+			}
+		case "profile-web-page-url":
+			meta.WebPageURL = value
+		default:
+			if note := strings.TrimSpace(strings.TrimLeft(line, "#")); note != "" {
+				meta.Notes = append(meta.Notes, note)
+			}
+		}
+	}
+
+	return meta
+}
+
+// merge fills fields of m that are unset from other.
+func (m SourceMeta) merge(other SourceMeta) SourceMeta {
+	if m.UpdateInterval == 0 {
+		m.UpdateInterval = other.UpdateInterval
+	}
+	if m.WebPageURL == "" {
+		m.WebPageURL = other.WebPageURL
+	}
+	m.Notes = append(m.Notes, other.Notes...)
+	m.Usage = m.Usage.merge(other.Usage)
+	m.ETag = cmp.Or(m.ETag, other.ETag)
+	m.LastModified = cmp.Or(m.LastModified, other.LastModified)
+	return m
+}
+
+func saveSourceMeta(stateDir, name string, meta SourceMeta) error {
+	return writeJSON(sourceMetaPath(stateDir, name), meta)
+}
+
+func loadSourceMeta(stateDir, name string) SourceMeta {
+	var meta SourceMeta
+
+	data, err := os.ReadFile(sourceMetaPath(stateDir, name))
+	if err == nil {
+		json.Unmarshal(data, &meta)
+	}
+	return meta
+}
+
+func sourceMetaPath(stateDir, name string) string {
+	return strings.TrimSuffix(sourceCachePath(stateDir, name), ".cache") + ".meta.json"
+}
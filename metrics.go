@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsWriter accumulates gauges in the text format read by the
+// node_exporter textfile collector.
+type metricsWriter struct {
+	b strings.Builder
+}
+
+func (m *metricsWriter) gauge(name, help string) {
+	fmt.Fprintf(&m.b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.b.WriteString(name)
+	if len(labels) > 0 {
+		m.b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.b.WriteByte(',')
+			}
+			fmt.Fprintf(&m.b, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		m.b.WriteByte('}')
+	}
+	m.b.WriteByte(' ')
+	m.b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	m.b.WriteByte('\n')
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// writeTextfile writes msbc.prom into dir after a successful build.
+func writeTextfile(dir string, report *Report, duration time.Duration) error {
+	var m metricsWriter
+
+	m.gauge("msbc_last_success_timestamp_seconds", "Unix time of the last successful build.")
+	m.sample("msbc_last_success_timestamp_seconds", float64(report.GeneratedAt.Unix()))
+
+	m.gauge("msbc_build_duration_seconds", "Time the last successful build spent generating and exporting.")
+	m.sample("msbc_build_duration_seconds", duration.Seconds())
+
+	m.gauge("msbc_nodes", "Generated server outbounds per source.")
+	for _, src := range report.Sources {
+		m.sample("msbc_nodes", float64(src.Nodes), "source", src.Name)
+	}
+
+	regions := make(map[string]int)
+	var order []string
+	for _, node := range report.Nodes {
+		if _, ok := regions[node.Region]; !ok {
+			order = append(order, node.Region)
+		}
+		regions[node.Region]++
+	}
+
+	m.gauge("msbc_regions", "Regions with at least one node.")
+	m.sample("msbc_regions", float64(len(order)))
+
+	m.gauge("msbc_region_nodes", "Generated server outbounds per region.")
+	for _, region := range order {
+		m.sample("msbc_region_nodes", float64(regions[region]), "region", region)
+	}
+
+	m.gauge("msbc_rejected_nodes", "Nodes dropped by filters in the last build.")
+	m.sample("msbc_rejected_nodes", float64(len(report.Rejected)))
+
+	m.gauge("msbc_source_traffic_remaining_bytes", "Traffic left on the plan, as reported by the provider.")
+	for _, src := range report.Sources {
+		if remain, ok := src.Usage.remaining(); src.Usage != nil && ok {
+			m.sample("msbc_source_traffic_remaining_bytes", float64(remain), "source", src.Name)
+		}
+	}
+
+	m.gauge("msbc_source_traffic_total_bytes", "Traffic included in the plan, as reported by the provider.")
+	for _, src := range report.Sources {
+		if src.Usage != nil && src.Usage.Total > 0 {
+			m.sample("msbc_source_traffic_total_bytes", float64(src.Usage.Total), "source", src.Name)
+		}
+	}
+
+	m.gauge("msbc_source_expire_timestamp_seconds", "Unix time the plan expires, as reported by the provider.")
+	for _, src := range report.Sources {
+		if src.Usage != nil && !src.Usage.Expire.IsZero() {
+			m.sample("msbc_source_expire_timestamp_seconds", float64(src.Usage.Expire.Unix()), "source", src.Name)
+		}
+	}
+
+	m.b.WriteString("# EOF\n")
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, "msbc.prom")
+	if err := writeFileAtomic(path, []byte(m.b.String()), 0644); err != nil {
+		return err
+	}
+
+	log.Printf("wrote %s", path)
+	return nil
+}
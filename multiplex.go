@@ -0,0 +1,45 @@
+package main
+
+import "slices"
+
+// MultiplexOptions is sing-box's multiplex block. In the config it is the
+// block copied into every generated outbound that can multiplex.
+type MultiplexOptions struct {
+	Enabled        bool           `json:"enabled"`
+	Protocol       string         `json:"protocol,omitempty"`
+	MaxConnections int            `json:"max_connections,omitempty"`
+	MinStreams     int            `json:"min_streams,omitempty"`
+	MaxStreams     int            `json:"max_streams,omitempty"`
+	Padding        bool           `json:"padding,omitempty"`
+	Brutal         *BrutalOptions `json:"brutal,omitempty"`
+}
+
+// BrutalOptions is the TCP Brutal congestion control of a multiplexed
+// connection; the server needs the kernel module.
+type BrutalOptions struct {
+	Enabled  bool `json:"enabled"`
+	UpMbps   int  `json:"up_mbps"`
+	DownMbps int  `json:"down_mbps"`
+}
+
+var multiplexProtocols = []string{"smux", "yamux", "h2mux"}
+
+// multiplexTypes are the TCP based outbounds sing-box can multiplex.
+var multiplexTypes = []string{"trojan", "shadowsocks"}
+
+// applyMultiplex sets the configured multiplex block on the nodes that can
+// take it and do not have one.
+func applyMultiplex(c *MultiplexOptions, outbounds []ServerOutbound) {
+	if !c.Enabled {
+		return
+	}
+
+	for i := range outbounds {
+		ob := &outbounds[i]
+		if ob.Multiplex != nil || !slices.Contains(multiplexTypes, ob.Type) {
+			continue
+		}
+		mux := *c
+		ob.Multiplex = &mux
+	}
+}
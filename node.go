@@ -0,0 +1,27 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// nodeID derives a short identifier from the parts of a node that actually
+// determine where traffic goes. Providers rename nodes freely, but the id
+// stays the same as long as the endpoint and credential do.
+func nodeID(protocol, server string, port int, credential string) string {
+	h := sha256.New()
+	for _, part := range []string{protocol, server, strconv.Itoa(port), credential} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:8]
+}
+
+// credential is whatever authenticates a node of its protocol, along with
+// the shadowsocks method that goes with the password. The fields are NUL
+// separated so that "ab" and "c" never read the same as "a" and "bc".
+func (ob *ServerOutbound) credential() string {
+	return strings.Join([]string{ob.UUID, ob.User, ob.Username, ob.Password, ob.AuthStr, ob.PrivateKey, ob.Method}, "\x00")
+}
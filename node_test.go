@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestNodeIDCredential(t *testing.T) {
+	node := func(f func(ob *ServerOutbound)) string {
+		ob := &ServerOutbound{BaseOutbound: BaseOutbound{Type: "shadowsocks"}, Server: "example.com", ServerPort: 443}
+		f(ob)
+		return nodeID(ob.Type, ob.Server, ob.ServerPort, ob.credential())
+	}
+
+	tests := []struct {
+		name string
+		a, b func(ob *ServerOutbound)
+	}{
+		{
+			"split between user and password",
+			func(ob *ServerOutbound) { ob.Username, ob.Password = "ab", "c" },
+			func(ob *ServerOutbound) { ob.Username, ob.Password = "a", "bc" },
+		},
+		{
+			"moved to another field",
+			func(ob *ServerOutbound) { ob.UUID = "secret" },
+			func(ob *ServerOutbound) { ob.Password = "secret" },
+		},
+		{
+			"shadowsocks method",
+			func(ob *ServerOutbound) { ob.Method, ob.Password = "aes-128-gcm", "pw" },
+			func(ob *ServerOutbound) { ob.Method, ob.Password = "chacha20-ietf-poly1305", "pw" },
+		},
+	}
+
+	for _, tt := range tests {
+		if a, b := node(tt.a), node(tt.b); a == b {
+			t.Errorf("%s: both nodes got id %s", tt.name, a)
+		}
+	}
+
+	same := func(ob *ServerOutbound) { ob.Method, ob.Password = "aes-128-gcm", "pw" }
+	if a, b := node(same), node(same); a != b {
+		t.Errorf("the same node got ids %s and %s", a, b)
+	}
+}
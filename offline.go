@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// offlineMode is set by --offline. Every code path that would touch the
+// network must go through requireNetwork so the guarantee holds globally.
+var offlineMode bool
+
+func requireNetwork(what string) error {
+	if offlineMode {
+		return fmt.Errorf("offline mode: %s would require network access", what)
+	}
+	return nil
+}
+
+// localizeRuleSets rewrites remote rule-sets in the configs under dir into
+// local ones backed by files from bundleDir, so sing-box never has to
+// download them. A bundled file is looked up by tag or by the base name of
+// the rule-set URL, e.g. rulesets/geoip-cn.srs. Without apply it only
+// reports rule-sets that have no bundled copy, which lets a build fail
+// before anything is exported.
+func localizeRuleSets(dir, bundleDir string, apply bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var errs ValidationErrors
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".scheme.json") {
+			continue
+		}
+
+		file := filepath.Join(dir, name)
+
+		var data []byte
+		if isTemplate(name) {
+			data, err = readTemplate(file)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return err
+		}
+
+		doc, err := decodeDocument(file, data)
+		if err != nil {
+			return err
+		}
+
+		root, ok := doc.(map[string]any)
+		if !ok {
+			continue
+		}
+		route, ok := root["route"].(map[string]any)
+		if !ok {
+			continue
+		}
+		ruleSets, ok := route["rule_set"].([]any)
+		if !ok {
+			continue
+		}
+
+		changed := false
+
+		for i, item := range ruleSets {
+			rs, ok := item.(map[string]any)
+			if !ok || rs["type"] != "remote" {
+				continue
+			}
+
+			pointer := pointerJoin(pointerJoin("/route/rule_set", i), "url")
+			tag, _ := rs["tag"].(string)
+			rawURL, _ := rs["url"].(string)
+
+			src := findBundledRuleSet(bundleDir, tag, rawURL, rs["format"])
+			if src == "" {
+				errs.add(file, pointer, rawURL, "remote rule-set %q has no bundled copy in %s", tag, bundleDir)
+				continue
+			}
+			if !apply {
+				continue
+			}
+
+			dst := filepath.Join(dir, "rulesets", filepath.Base(src))
+			if err := copyFile(src, dst); err != nil {
+				return err
+			}
+
+			localized := map[string]any{
+				"type": "local",
+				"tag":  tag,
+				"path": dst,
+			}
+			if format, ok := rs["format"]; ok {
+				localized["format"] = format
+			}
+
+			ruleSets[i] = localized
+			changed = true
+			log.Printf("localized rule-set %s -> %s", tag, dst)
+		}
+
+		if changed {
+			if err := writeJSON(file, doc); err != nil {
+				return err
+			}
+		}
+	}
+
+	return errs.Err()
+}
+
+func findBundledRuleSet(bundleDir, tag, rawURL string, format any) string {
+	ext := ".srs"
+	if format == "source" {
+		ext = ".json"
+	}
+
+	candidates := []string{tag + ext}
+	if u, err := url.Parse(rawURL); err == nil && u.Path != "" {
+		candidates = append(candidates, path.Base(u.Path))
+	}
+
+	for _, name := range candidates {
+		p := filepath.Join(bundleDir, name)
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p
+		}
+	}
+	return ""
+}
+
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return writeAtomic(dst, 0644, func(out io.Writer) error {
+		_, err := io.Copy(out, in)
+		return err
+	})
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	openwrtRelease  = "/etc/openwrt_release"
+	uciConfigPath   = "/etc/config/msbc"
+	uciSingBoxPath  = "/etc/config/sing-box"
+	singBoxInitPath = "/etc/init.d/sing-box"
+)
+
+// isOpenWrt reports whether msbc runs on OpenWrt, where its options live in
+// uci and sing-box is managed by procd.
+func isOpenWrt() bool {
+	_, err := os.Stat(openwrtRelease)
+	return err == nil
+}
+
+// defaultUCIPath is the -uci default: /etc/config/msbc on OpenWrt, nothing
+// elsewhere.
+func defaultUCIPath() string {
+	if isOpenWrt() {
+		return uciConfigPath
+	}
+	return ""
+}
+
+// uciSection is one "config <type> '<name>'" block of a uci file. Options
+// given with "list" collect every value, "option" keeps the last one.
+type uciSection struct {
+	Type    string
+	Name    string
+	Options map[string][]string
+	order   []string
+	lists   map[string]bool
+}
+
+// readUCI parses a uci config file. A missing file yields no sections, so
+// a fresh install works before /etc/config/msbc has been written.
+func readUCI(path string) ([]*uciSection, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var sections []*uciSection
+	var current *uciSection
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		words, err := splitUCI(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+
+		switch {
+		case words[0] == "config" && (len(words) == 2 || len(words) == 3):
+			current = &uciSection{Type: words[1], Options: make(map[string][]string), lists: make(map[string]bool)}
+			if len(words) == 3 {
+				current.Name = words[2]
+			}
+			sections = append(sections, current)
+		case (words[0] == "option" || words[0] == "list") && len(words) == 3:
+			if current == nil {
+				return nil, fmt.Errorf("%s:%d: %s outside of a config section", path, n, words[0])
+			}
+			key := words[1]
+			if _, seen := current.Options[key]; !seen {
+				current.order = append(current.order, key)
+			}
+			if words[0] == "option" {
+				current.Options[key] = words[2:]
+			} else {
+				current.Options[key] = append(current.Options[key], words[2])
+				current.lists[key] = true
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unexpected %q", path, n, line)
+		}
+	}
+
+	return sections, scanner.Err()
+}
+
+// option returns the value of a single-valued option.
+func (s *uciSection) option(key string) string {
+	values := s.Options[key]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// value returns a list option as a list of its values and any other option
+// converted by uciValue.
+func (s *uciSection) value(key string) any {
+	if !s.lists[key] {
+		return uciValue(s.option(key))
+	}
+	list := make([]any, 0, len(s.Options[key]))
+	for _, v := range s.Options[key] {
+		list = append(list, v)
+	}
+	return list
+}
+
+// splitUCI splits a line into words the way uci does, honoring single and
+// double quotes and backslash escapes outside single quotes.
+func splitUCI(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	for i := 0; i < len(line); i++ {
+		c := rune(line[i])
+
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == '\'':
+			word.WriteRune(c)
+		case c == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case quote == '"':
+			word.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == '#':
+			i = len(line)
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// applyUCIFlags fills in the flags of the running command from the "msbc"
+// sections of the -uci file, e.g. "option export_dir" sets -export-dir.
+// Flags given on the command line win. Options the command has no flag for
+// are skipped, since build, daemon and list share one section; "enabled" is
+// read by the init script and "workdir" changes into the directory that
+// holds the templates and state.
+func applyUCIFlags(fs *flag.FlagSet) error {
+	f := fs.Lookup("uci")
+	if f == nil || f.Value.String() == "" {
+		return nil
+	}
+	path := f.Value.String()
+
+	sections, err := readUCI(path)
+	if err != nil {
+		return err
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for _, s := range sections {
+		if s.Type != "msbc" {
+			continue
+		}
+
+		for _, key := range s.order {
+			name := strings.ReplaceAll(key, "_", "-")
+
+			switch {
+			case key == "enabled":
+				continue
+			case key == "workdir":
+				if err := os.Chdir(s.option(key)); err != nil {
+					return fmt.Errorf("uci %s: %v", path, err)
+				}
+				continue
+			case fs.Lookup(name) == nil || explicit[name]:
+				continue
+			}
+
+			for _, value := range s.Options[key] {
+				if err := fs.Set(name, value); err != nil {
+					return fmt.Errorf("uci %s: option %s: %v", path, key, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// uciConfigLayer turns the -uci file into a merge patch over msbc.json.
+// Every "source" section becomes a source named after the section, and
+// together they replace the sources of msbc.json. Options of any other
+// section except "msbc" set the config key <type>.<option>, so
+// "config expiry" with "option warn_days '3'" sets expiry.warn_days.
+func uciConfigLayer(path string) (map[string]any, error) {
+	sections, err := readUCI(path)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := make(map[string]any)
+	var sources []any
+	var errs ValidationErrors
+
+	names := make(map[string]int)
+
+	for _, s := range sections {
+		switch s.Type {
+		case "msbc":
+			// "list source '<url>'" is the short form of a source section,
+			// named after the host of the url
+			for _, u := range s.Options["source"] {
+				name := u
+				if parsed, err := url.Parse(u); err == nil && parsed.Hostname() != "" {
+					name = parsed.Hostname()
+				}
+				if names[name]++; names[name] > 1 {
+					name = fmt.Sprintf("%s-%d", name, names[name])
+				}
+				sources = append(sources, map[string]any{"name": name, "url": u})
+			}
+			continue
+		case "source":
+			src := map[string]any{"name": s.Name}
+			for _, key := range s.order {
+				src[key] = s.value(key)
+			}
+			if s.option("enabled") != "" {
+				src["enabled"] = s.option("enabled") == "1"
+			}
+			sources = append(sources, src)
+			continue
+		}
+
+		for _, key := range s.order {
+			if pointer, err := setPath(patch, []string{s.Type, key}, s.value(key)); err != nil {
+				errs.add(path, pointer, nil, "%s.%s: %v", s.Type, key, err)
+			}
+		}
+	}
+
+	if sources != nil {
+		patch["sources"] = sources
+	}
+	return patch, errs.Err()
+}
+
+// uciValue converts a uci string the way -set values are converted: numbers
+// and booleans keep their JSON type, everything else stays a string.
+func uciValue(v string) any {
+	var value any
+	if err := json.Unmarshal([]byte(v), &value); err != nil {
+		return v
+	}
+	return value
+}
+
+// openwrtExportDir is the directory of the config file the sing-box init
+// script passes to sing-box, read from its uci config. It is empty off
+// OpenWrt.
+func openwrtExportDir() string {
+	if !isOpenWrt() {
+		return ""
+	}
+
+	sections, err := readUCI(uciSingBoxPath)
+	if err != nil {
+		log.Printf("warning: %v", err)
+		return ""
+	}
+
+	for _, s := range sections {
+		if s.Type == "sing-box" {
+			if conf := s.option("conffile"); conf != "" {
+				return filepath.Dir(conf)
+			}
+		}
+	}
+	return ""
+}
+
+// reloadOpenWrt reloads sing-box through its procd init script.
+func reloadOpenWrt(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, singBoxInitPath, "reload").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s reload: %v: %s", singBoxInitPath, err, strings.TrimSpace(string(out)))
+	}
+
+	log.Printf("reloaded sing-box through %s", singBoxInitPath)
+	return nil
+}
+
+func openwrtCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: msbc openwrt init|uci\n\n")
+		fmt.Fprintf(fs.Output(), "init:  msbc openwrt init > /etc/init.d/msbc && chmod +x /etc/init.d/msbc\n")
+		fmt.Fprintf(fs.Output(), "uci:   msbc openwrt uci > /etc/config/msbc\n")
+	}
+
+	return func(_ context.Context) error {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return fmt.Errorf("expected exactly one of init or uci")
+		}
+
+		switch fs.Arg(0) {
+		case "init":
+			return writeProcdInit(os.Stdout)
+		case "uci":
+			_, err := io.WriteString(os.Stdout, uciTemplate)
+			return err
+		}
+		return fmt.Errorf("unknown file %q, expected init or uci", fs.Arg(0))
+	}
+}
+
+// writeProcdInit prints a procd init script running "msbc daemon" with the
+// options from /etc/config/msbc; procd restarts it when it exits and on
+// "uci commit msbc".
+func writeProcdInit(w io.Writer) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	return procdInit.Execute(w, map[string]string{
+		"Exe": exe,
+		"UCI": uciConfigPath,
+	})
+}
+
+var procdInit = template.Must(template.New("init").Parse(`#!/bin/sh /etc/rc.common
+
+USE_PROCD=1
+START=99
+STOP=10
+
+start_service() {
+	config_load msbc
+	local enabled
+	config_get_bool enabled main enabled 1
+	[ "$enabled" -eq 1 ] || return 0
+
+	procd_open_instance
+	procd_set_param command {{.Exe}} daemon -uci {{.UCI}}
+	procd_set_param respawn
+	procd_set_param stdout 1
+	procd_set_param stderr 1
+	procd_close_instance
+}
+
+service_triggers() {
+	procd_add_reload_trigger msbc
+}
+`))
+
+const uciTemplate = `config msbc 'main'
+	option enabled '1'
+	option workdir '/etc/msbc'
+	option state_dir '/var/lib/msbc'
+	option single_file 'config'
+	option reload 'openwrt'
+	option interval '6h'
+
+config source 'provider'
+	option url 'https://example.com/subscription'
+
+config log
+	option output 'syslog'
+`
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestUCIConfigLayer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "msbc")
+	config := `config msbc 'main'
+	option interval '6h'
+	list source 'https://example.com/sub?token=a'
+	list source "https://example.com/sub?token=b"
+	list source 'https://example.org/list'
+
+config source 'paid'
+	option url 'https://paid.example.net/sub'
+	option priority '10'
+	option enabled '0'
+	list mirrors 'https://m1.example.net/sub'
+	list mirrors 'https://m2.example.net/sub'
+
+config expiry
+	option warn_days '3' # inline comment
+
+config filter
+	list deny_hosts 'bad.example.net'
+	list deny_hosts 'worse.example.net'
+`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	patch, err := uciConfigLayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.Marshal(patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"expiry":{"warn_days":3},"filter":{"deny_hosts":["bad.example.net","worse.example.net"]},"sources":[` +
+		`{"name":"example.com","url":"https://example.com/sub?token=a"},` +
+		`{"name":"example.com-2","url":"https://example.com/sub?token=b"},` +
+		`{"name":"example.org","url":"https://example.org/list"},` +
+		`{"enabled":false,"mirrors":["https://m1.example.net/sub","https://m2.example.net/sub"],"name":"paid","priority":10,"url":"https://paid.example.net/sub"}]}`
+	if string(got) != want {
+		t.Errorf("uciConfigLayer() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSplitUCI(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{`option url 'https://a/?x=1&y=2'`, []string{"option", "url", "https://a/?x=1&y=2"}},
+		{`option name "it's"`, []string{"option", "name", "it's"}},
+		{`option name 'a b' # comment`, []string{"option", "name", "a b"}},
+		{`option name a\ b`, []string{"option", "name", "a b"}},
+	}
+
+	for _, tt := range tests {
+		got, err := splitUCI(tt.line)
+		if err != nil {
+			t.Errorf("splitUCI(%q): %v", tt.line, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitUCI(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+
+	if _, err := splitUCI(`option url 'open`); err == nil {
+		t.Error("splitUCI() accepted an unterminated quote")
+	}
+}
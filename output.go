@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// writeJSON writes v as indented JSON terminated by a newline. Struct fields
+// keep their declaration order and map keys are sorted by encoding/json, so
+// identical input always produces byte-identical files.
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, append(data, '\n'), 0644)
+}
+
+// writeFileAtomic replaces path with data without ever exposing a partially
+// written file: the data goes to a temporary file in the same directory which
+// is then renamed over the target.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeAtomic(path, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func writeAtomic(path string, perm os.FileMode, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// sortOutbounds orders nodes by region and then by tag, comparing digit runs
+// numerically so that "HK 2" sorts before "HK 10". Providers that shuffle
+// their list between refreshes then still produce the same output.
+func sortOutbounds(outbounds []ServerOutbound) {
+	sort.SliceStable(outbounds, func(i, j int) bool {
+		ri, rj := extractRegion(outbounds[i].Tag), extractRegion(outbounds[j].Tag)
+		if ri != rj {
+			return naturalLess(ri, rj)
+		}
+		if outbounds[i].Tag != outbounds[j].Tag {
+			return naturalLess(outbounds[i].Tag, outbounds[j].Tag)
+		}
+		return outbounds[i].ID < outbounds[j].ID
+	})
+}
+
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := isDigit(a[0]), isDigit(b[0])
+
+		if da && db {
+			na, ra := splitDigits(a)
+			nb, rb := splitDigits(b)
+
+			// compare by magnitude first, ignoring leading zeros
+			ta, tb := trimZeros(na), trimZeros(nb)
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+
+			a, b = ra, rb
+			continue
+		}
+
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func trimZeros(s string) string {
+	for len(s) > 1 && s[0] == '0' {
+		s = s[1:]
+	}
+	return s
+}
+
+// writeServers writes the server outbounds either to a single servers.json or,
+// when split is set, to one servers-<region>.json per region, and returns the
+// names of the files it wrote.
+func writeServers(dir string, outbounds []ServerOutbound, split bool) ([]string, error) {
+	files := make(map[string][]ServerOutbound)
+	order := make([]string, 0)
+
+	for _, ob := range outbounds {
+		name := "servers.json"
+		if split {
+			name = "servers-" + safeFileName(ob.Region) + ".json"
+		}
+
+		if _, exists := files[name]; !exists {
+			order = append(order, name)
+		}
+		files[name] = append(files[name], ob)
+	}
+
+	if len(order) == 0 {
+		order = append(order, "servers.json")
+	}
+
+	for _, name := range order {
+		path := filepath.Join(dir, name)
+
+		var cfg ServersConfig
+		cfg.Outbounds, cfg.Endpoints = splitEndpoints(files[name])
+		if cfg.Outbounds == nil {
+			cfg.Outbounds = []ServerOutbound{}
+		}
+
+		if err := writeJSON(path, cfg); err != nil {
+			return nil, err
+		}
+
+		log.Printf("wrote %s", path)
+	}
+
+	return order, nil
+}
+
+// generatedManifest lists, one name per line, the files msbc wrote to the
+// directory it sits in. Which files exist depends on the output layout, so
+// switching layouts has to clean up, but only ever what msbc put there: a
+// hand-maintained servers-manual.json next to the generated files stays.
+const generatedManifest = ".msbc-generated"
+
+// pruneGenerated removes the files the previous run wrote to dir that are
+// not in keep, slash separated paths relative to dir, so that sing-box never loads the same outbound from two
+// files, and records keep as the files written by this run.
+func pruneGenerated(dir string, keep map[string]struct{}) error {
+	manifest := filepath.Join(dir, generatedManifest)
+
+	data, err := os.ReadFile(manifest)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, name := range strings.Split(string(data), "\n") {
+		if _, ok := keep[name]; ok || !manifestName(name) {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.Remove(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		log.Printf("removed stale %s", path)
+	}
+
+	names := make([]string, 0, len(keep))
+	for name := range keep {
+		names = append(names, name+"\n")
+	}
+	sort.Strings(names)
+	return writeFileAtomic(manifest, []byte(strings.Join(names, "")), 0644)
+}
+
+// manifestName reports whether name, a slash separated path, can be a file
+// msbc wrote, so that an edited manifest cannot point outside its directory
+// or at dotfiles such as .git.
+func manifestName(name string) bool {
+	for part := range strings.SplitSeq(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return filepath.IsLocal(filepath.FromSlash(name))
+}
+
+// safeFileName turns a region or source name into something safe to use in
+// a file name, e.g. "Hong Kong" becomes "hong-kong".
+func safeFileName(name string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+
+	if b.Len() == 0 {
+		return "other"
+	}
+	return b.String()
+}
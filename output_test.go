@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPruneGenerated(t *testing.T) {
+	dir := t.TempDir()
+
+	// no manifest yet, nothing is known to be msbc's
+	writeTestFiles(t, dir, map[string]string{"outbounds.json": "{}", "servers.json": "{}"})
+	if err := pruneGenerated(dir, map[string]struct{}{"servers.json": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if names, want := dirNames(t, dir), []string{generatedManifest, "outbounds.json", "servers.json"}; !slices.Equal(names, want) {
+		t.Fatalf("dir holds %v, want %v", names, want)
+	}
+
+	// the layout changes from servers.json to one file per region
+	writeTestFiles(t, dir, map[string]string{"servers-japan.json": "{}", "servers-manual.json": "{}"})
+	if err := pruneGenerated(dir, map[string]struct{}{"servers-japan.json": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if names, want := dirNames(t, dir), []string{generatedManifest, "outbounds.json", "servers-japan.json", "servers-manual.json"}; !slices.Equal(names, want) {
+		t.Errorf("dir holds %v, want %v", names, want)
+	}
+
+	manifest, err := os.ReadFile(filepath.Join(dir, generatedManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(manifest) != "servers-japan.json\n" {
+		t.Errorf("manifest = %q", manifest)
+	}
+}
+
+func TestPruneGeneratedStaysInDir(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "export")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFiles(t, parent, map[string]string{"outside.json": "{}"})
+	writeTestFiles(t, dir, map[string]string{generatedManifest: "../outside.json\n.hidden\n\n", ".hidden": ""})
+	if err := pruneGenerated(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "outside.json")); err != nil {
+		t.Errorf("a manifest entry outside the directory was removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".hidden")); err != nil {
+		t.Errorf("a hidden manifest entry was removed: %v", err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// packetEncodings are the values sing-box accepts for packet_encoding.
+var packetEncodings = []string{"packetaddr", "xudp"}
+
+// packetEncodingTypes are the outbounds that have a packet_encoding option.
+// msbc does not parse their links itself, they arrive through the
+// subconverter.
+var packetEncodingTypes = []string{"vmess", "vless"}
+
+// PacketEncodingRule sets packet_encoding on the vmess and vless nodes whose
+// tag, as the subscription names it, matches Match; an empty Match matches
+// every node. UDP over these protocols needs an encoding the server also
+// speaks, xudp for Xray servers.
+type PacketEncodingRule struct {
+	Match    string `json:"match,omitempty"`
+	Encoding string `json:"encoding"`
+}
+
+// packetEncodingMatchers compiles the Match of every rule once; a rule
+// without one gets nil and matches every node.
+func packetEncodingMatchers(rules []PacketEncodingRule) ([]*regexp.Regexp, error) {
+	matchers := make([]*regexp.Regexp, len(rules))
+	for i, rule := range rules {
+		if rule.Match == "" {
+			continue
+		}
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("packet_encoding %d: invalid match %q: %v", i, rule.Match, err)
+		}
+		matchers[i] = re
+	}
+	return matchers, nil
+}
+
+// packetEncodingTag is the tag parseShareLink would give a link named tag,
+// so that links and the nodes converted from them meet under the same name
+// however the subconverter trimmed it.
+func packetEncodingTag(tag string) string {
+	return linkTag(&url.URL{Fragment: tag})
+}
+
+// linkPacketEncodings reads the packetEncoding parameter that v2rayN and
+// its relatives put on vless links, keyed by the tag of the link. The
+// subconverter drops the parameter, setLinkPacketEncodings puts it back.
+func linkPacketEncodings(links []string) map[string]string {
+	encodings := make(map[string]string)
+	for _, link := range links {
+		if !strings.HasPrefix(strings.ToLower(link), "vless://") {
+			continue
+		}
+		u, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		if enc := u.Query().Get("packetEncoding"); slices.Contains(packetEncodings, enc) {
+			encodings[linkTag(u)] = enc
+		}
+	}
+	return encodings
+}
+
+// setLinkPacketEncodings gives converted nodes the packet_encoding their
+// link asked for, unless the subconverter already set one. Links whose node
+// cannot be found by its tag are reported, the encoding is lost for them.
+func setLinkPacketEncodings(outbounds []ServerOutbound, encodings map[string]string) {
+	found := make(map[string]bool, len(encodings))
+	for i := range outbounds {
+		ob := &outbounds[i]
+		tag := packetEncodingTag(ob.Tag)
+		enc, ok := encodings[tag]
+		if !ok {
+			continue
+		}
+		found[tag] = true
+		if !slices.Contains(packetEncodingTypes, ob.Type) || ob.Extra["packet_encoding"] != nil {
+			continue
+		}
+		setPacketEncoding(ob, enc)
+	}
+
+	for _, tag := range slices.Sorted(maps.Keys(encodings)) {
+		if !found[tag] {
+			log.Printf("warning: the subconverter returned no node named %q, dropping its packetEncoding %s", tag, encodings[tag])
+		}
+	}
+}
+
+// applyPacketEncoding sets the packet_encoding of the first matching rule,
+// which wins over the one from the link.
+func applyPacketEncoding(rules []PacketEncodingRule, outbounds []ServerOutbound) error {
+	matchers, err := packetEncodingMatchers(rules)
+	if err != nil {
+		return err
+	}
+
+	for i := range outbounds {
+		ob := &outbounds[i]
+		if !slices.Contains(packetEncodingTypes, ob.Type) {
+			continue
+		}
+		for j, re := range matchers {
+			if re == nil || re.MatchString(ob.Tag) {
+				setPacketEncoding(ob, rules[j].Encoding)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func setPacketEncoding(ob *ServerOutbound, enc string) {
+	if ob.Extra == nil {
+		ob.Extra = make(map[string]json.RawMessage)
+	}
+	ob.Extra["packet_encoding"], _ = json.Marshal(enc)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestPacketEncoding(t *testing.T) {
+	links := []string{
+		"vless://uuid@jp.example.com:443?security=tls&packetEncoding=xudp#%20JP%2001%20",
+		"vless://uuid@hk.example.com:443?packetEncoding=packetaddr#%F0%9F%87%AD%F0%9F%87%B0%20HK%2002",
+		"vless://uuid@us.example.com:443?packetEncoding=bogus#US 01",
+		"vmess://eyJhZGQiOiJleGFtcGxlLmNvbSJ9",
+	}
+	encodings := linkPacketEncodings(links)
+	if len(encodings) != 2 || encodings["JP 01"] != "xudp" || encodings["HK 02"] != "packetaddr" {
+		t.Fatalf("linkPacketEncodings() = %v, want JP 01 with xudp and HK 02 with packetaddr", encodings)
+	}
+
+	node := func(typ, tag string) ServerOutbound {
+		return ServerOutbound{BaseOutbound: BaseOutbound{Type: typ, Tag: tag}}
+	}
+	outbounds := []ServerOutbound{
+		// as the subconverter may name them, untrimmed or with the flag
+		node("vless", " JP 01"),
+		node("vless", "HK 01"),
+		node("vmess", "US 01"),
+		node("trojan", "JP 02"),
+	}
+	setLinkPacketEncodings(outbounds, encodings)
+
+	tests := []struct {
+		name  string
+		rules []PacketEncodingRule
+		want  []string
+	}{
+		{"link only", nil, []string{`"xudp"`, "", "", ""}},
+		{"global", []PacketEncodingRule{{Encoding: "packetaddr"}}, []string{`"packetaddr"`, `"packetaddr"`, `"packetaddr"`, ""}},
+		{"per node", []PacketEncodingRule{{Match: "^US", Encoding: "packetaddr"}}, []string{`"xudp"`, "", `"packetaddr"`, ""}},
+		{"first match wins", []PacketEncodingRule{{Match: "HK", Encoding: "xudp"}, {Encoding: "packetaddr"}}, []string{`"packetaddr"`, `"xudp"`, `"packetaddr"`, ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]ServerOutbound, len(outbounds))
+			for i, ob := range outbounds {
+				got[i] = ob
+				got[i].Extra = maps.Clone(ob.Extra)
+			}
+			if err := applyPacketEncoding(tt.rules, got); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, ob := range got {
+				if enc := string(ob.Extra["packet_encoding"]); enc != tt.want[i] {
+					t.Errorf("%s: packet_encoding = %s, want %s", ob.Tag, enc, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestApplyPacketEncodingInvalidMatch(t *testing.T) {
+	rules := []PacketEncodingRule{{Match: "^JP", Encoding: "xudp"}, {Match: "(", Encoding: "xudp"}}
+	if err := applyPacketEncoding(rules, nil); err == nil {
+		t.Error("applyPacketEncoding() accepted an invalid match")
+	}
+}
+
+func TestSetLinkPacketEncodingsNormalizesTags(t *testing.T) {
+	encodings := linkPacketEncodings([]string{"vless://uuid@hk.example.com:443?packetEncoding=packetaddr#%F0%9F%87%AD%F0%9F%87%B0%20HK%2002%20"})
+
+	outbounds := []ServerOutbound{{BaseOutbound: BaseOutbound{Type: "vless", Tag: "🇭🇰 HK 02 "}}}
+	setLinkPacketEncodings(outbounds, encodings)
+	if enc := string(outbounds[0].Extra["packet_encoding"]); enc != `"packetaddr"` {
+		t.Errorf("packet_encoding = %s, want the packetaddr of the link", enc)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParseResult shows everything msbc derives from a single share link.
+type ParseResult struct {
+	Link     string `json:"link"`
+	Region   string `json:"region,omitempty"`
+	DedupKey string `json:"dedup_key,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Outbound any    `json:"outbound,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func parseCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: msbc parse [link ...]\n\nwith no links (or \"-\"), links are read from stdin one per line\n")
+		fs.PrintDefaults()
+	}
+
+	return func(_ context.Context) error {
+		links := fs.Args()
+		if len(links) == 0 || (len(links) == 1 && links[0] == "-") {
+			var err error
+			links, err = readLinks(os.Stdin)
+			if err != nil {
+				return err
+			}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		for _, link := range links {
+			if err := enc.Encode(parseLink(link)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func readLinks(f *os.File) ([]string, error) {
+	var links []string
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			links = append(links, line)
+		}
+	}
+	return links, scanner.Err()
+}
+
+func parseLink(link string) ParseResult {
+	result := ParseResult{Link: link}
+
+	ob, err := parseShareLink(link)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if ob == nil {
+		result.Error = "unsupported scheme"
+		return result
+	}
+
+	if reason, ok := unrepresentable[ob.Type]; ok {
+		result.Error = reason
+	}
+
+	result.Region = extractRegion(ob.Tag)
+	result.DedupKey = outboundKey(ob.Server, ob.ServerPort)
+	result.ID = ob.ID
+	result.Outbound = ob
+	return result
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergePatchSuffix = ".merge.json"
+	jsonPatchSuffix  = ".patch.json"
+)
+
+// exportPatched renders the configs in srcDir into a directory of its own,
+// applies the patches in patchDir, and those of env in its subdirectory, and
+// only then exports the result to dstDir. A patch that fails leaves dstDir
+// as it was.
+func exportPatched(ctx context.Context, srcDir, dstDir, patchDir, env string, merged bool) error {
+	renderDir, err := os.MkdirTemp("", "msbc-render-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(renderDir)
+
+	if merged {
+		err = exportMerged(ctx, srcDir, renderDir)
+	} else {
+		err = exportConfig(ctx, srcDir, renderDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := applyPatches(patchDir, renderDir); err != nil {
+		return err
+	}
+	// an environment can tweak the output further, e.g. its inbounds
+	if env != "" {
+		if err := applyPatches(filepath.Join(patchDir, env), renderDir); err != nil {
+			return err
+		}
+	}
+
+	return exportConfig(ctx, renderDir, dstDir)
+}
+
+// applyPatches applies the patch files in patchDir to the exported documents
+// in dstDir. servers.merge.json is an RFC 7386 merge patch for servers.json
+// and servers.patch.json an RFC 6902 JSON Patch; both may exist, the merge
+// patch goes first. A missing patch directory is not an error.
+func applyPatches(patchDir, dstDir string) error {
+	entries, err := os.ReadDir(patchDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	targets := make(map[string][]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			continue
+		}
+
+		for _, suffix := range []string{mergePatchSuffix, jsonPatchSuffix} {
+			if base, ok := strings.CutSuffix(name, suffix); ok {
+				targets[base+".json"] = append(targets[base+".json"], name)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs ValidationErrors
+
+	for _, name := range names {
+		dstPath := filepath.Join(dstDir, name)
+
+		data, err := os.ReadFile(dstPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				for _, patch := range targets[name] {
+					errs.add(filepath.Join(patchDir, patch), "", nil, "no exported %s to patch", name)
+				}
+				continue
+			}
+			return err
+		}
+
+		doc, err := decodeDocument(dstPath, data)
+		if err != nil {
+			return err
+		}
+
+		// merge patches sort before JSON patches, and are applied first
+		patches := targets[name]
+		sort.Slice(patches, func(i, j int) bool {
+			return strings.HasSuffix(patches[i], mergePatchSuffix) && !strings.HasSuffix(patches[j], mergePatchSuffix)
+		})
+
+		failed := false
+		for _, patch := range patches {
+			path := filepath.Join(patchDir, patch)
+
+			patchData, err := readTemplate(path)
+			if err != nil {
+				return err
+			}
+			patchDoc, err := decodeDocument(path, patchData)
+			if err != nil {
+				return err
+			}
+
+			if strings.HasSuffix(patch, mergePatchSuffix) {
+				doc = mergePatch(doc, patchDoc)
+				continue
+			}
+
+			n := len(errs)
+			doc = applyJSONPatch(path, doc, patchDoc, &errs)
+			failed = failed || len(errs) > n
+		}
+
+		if failed {
+			continue
+		}
+
+		if err := writeJSON(dstPath, doc); err != nil {
+			return err
+		}
+		log.Printf("patched %s", dstPath)
+	}
+
+	return errs.Err()
+}
+
+// mergePatch applies an RFC 7386 merge patch: objects are merged
+// recursively, null removes a key and anything else replaces the target.
+func mergePatch(target, patch any) any {
+	obj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	dst, ok := target.(map[string]any)
+	if !ok {
+		dst = make(map[string]any)
+	}
+
+	for key, value := range obj {
+		if value == nil {
+			delete(dst, key)
+			continue
+		}
+		dst[key] = mergePatch(dst[key], value)
+	}
+	return dst
+}
+
+// applyJSONPatch applies the operations of an RFC 6902 JSON Patch in order.
+// A failing operation is reported and stops the patch.
+func applyJSONPatch(file string, doc, patch any, errs *ValidationErrors) any {
+	ops, ok := patch.([]any)
+	if !ok {
+		errs.add(file, "", nil, "expected array of operations, got %s", jsonTypeName(patch))
+		return doc
+	}
+
+	for i, item := range ops {
+		pointer := pointerJoin("", i)
+
+		op, ok := item.(map[string]any)
+		if !ok {
+			errs.add(file, pointer, item, "expected object")
+			return doc
+		}
+
+		var err error
+		doc, err = applyOperation(doc, op)
+		if err != nil {
+			errs.add(file, pointer, op["op"], "%v", err)
+			return doc
+		}
+	}
+
+	return doc
+}
+
+func applyOperation(doc any, op map[string]any) (any, error) {
+	name, _ := op["op"].(string)
+
+	path, ok := op["path"].(string)
+	if !ok {
+		return doc, fmt.Errorf("missing path")
+	}
+
+	value, hasValue := op["value"]
+	from, _ := op["from"].(string)
+
+	switch name {
+	case "add":
+		if !hasValue {
+			return doc, fmt.Errorf("missing value")
+		}
+		return pointerAdd(doc, path, value)
+
+	case "remove":
+		doc, _, err := pointerRemove(doc, path)
+		return doc, err
+
+	case "replace":
+		if !hasValue {
+			return doc, fmt.Errorf("missing value")
+		}
+		doc, _, err := pointerRemove(doc, path)
+		if err != nil {
+			return doc, err
+		}
+		return pointerAdd(doc, path, value)
+
+	case "move":
+		doc, moved, err := pointerRemove(doc, from)
+		if err != nil {
+			return doc, err
+		}
+		return pointerAdd(doc, path, moved)
+
+	case "copy":
+		copied, err := pointerGet(doc, from)
+		if err != nil {
+			return doc, err
+		}
+		return pointerAdd(doc, path, deepCopy(copied))
+
+	case "test":
+		actual, err := pointerGet(doc, path)
+		if err != nil {
+			return doc, err
+		}
+		if !reflect.DeepEqual(normalizeNumbers(actual), normalizeNumbers(value)) {
+			return doc, fmt.Errorf("test failed at %s", path)
+		}
+		return doc, nil
+	}
+
+	return doc, fmt.Errorf("unknown operation %q", name)
+}
+
+// splitPointer turns an RFC 6901 pointer into its unescaped tokens.
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		t = strings.ReplaceAll(t, "~1", "/")
+		tokens[i] = strings.ReplaceAll(t, "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(arr []any, token string, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return len(arr), nil
+	}
+
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || idx > len(arr) || idx == len(arr) && !allowEnd {
+		return 0, fmt.Errorf("index %q out of range", token)
+	}
+	return idx, nil
+}
+
+func pointerGet(doc any, pointer string) (any, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tokens {
+		switch v := doc.(type) {
+		case map[string]any:
+			next, ok := v[t]
+			if !ok {
+				return nil, fmt.Errorf("%s does not exist", pointer)
+			}
+			doc = next
+		case []any:
+			idx, err := arrayIndex(v, t, false)
+			if err != nil {
+				return nil, err
+			}
+			doc = v[idx]
+		default:
+			return nil, fmt.Errorf("%s does not exist", pointer)
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd inserts value at pointer and returns the updated document, which
+// differs from doc when the pointer names the root or an array grows.
+func pointerAdd(doc any, pointer string, value any) (any, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return doc, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return addAt(doc, tokens, value, pointer)
+}
+
+func addAt(doc any, tokens []string, value any, pointer string) (any, error) {
+	t := tokens[0]
+	last := len(tokens) == 1
+
+	switch v := doc.(type) {
+	case map[string]any:
+		if last {
+			v[t] = value
+			return v, nil
+		}
+		child, ok := v[t]
+		if !ok {
+			return doc, fmt.Errorf("%s does not exist", pointer)
+		}
+		child, err := addAt(child, tokens[1:], value, pointer)
+		if err != nil {
+			return doc, err
+		}
+		v[t] = child
+		return v, nil
+
+	case []any:
+		idx, err := arrayIndex(v, t, last)
+		if err != nil {
+			return doc, err
+		}
+		if last {
+			return append(v[:idx], append([]any{value}, v[idx:]...)...), nil
+		}
+		child, err := addAt(v[idx], tokens[1:], value, pointer)
+		if err != nil {
+			return doc, err
+		}
+		v[idx] = child
+		return v, nil
+	}
+
+	return doc, fmt.Errorf("%s does not exist", pointer)
+}
+
+// pointerRemove deletes the value at pointer and returns the updated
+// document along with the removed value.
+func pointerRemove(doc any, pointer string) (any, any, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return doc, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	return removeAt(doc, tokens, pointer)
+}
+
+func removeAt(doc any, tokens []string, pointer string) (any, any, error) {
+	t := tokens[0]
+	last := len(tokens) == 1
+
+	switch v := doc.(type) {
+	case map[string]any:
+		child, ok := v[t]
+		if !ok {
+			return doc, nil, fmt.Errorf("%s does not exist", pointer)
+		}
+		if last {
+			delete(v, t)
+			return v, child, nil
+		}
+		child, removed, err := removeAt(child, tokens[1:], pointer)
+		if err != nil {
+			return doc, nil, err
+		}
+		v[t] = child
+		return v, removed, nil
+
+	case []any:
+		idx, err := arrayIndex(v, t, false)
+		if err != nil {
+			return doc, nil, err
+		}
+		if last {
+			removed := v[idx]
+			return append(v[:idx:idx], v[idx+1:]...), removed, nil
+		}
+		child, removed, err := removeAt(v[idx], tokens[1:], pointer)
+		if err != nil {
+			return doc, nil, err
+		}
+		v[idx] = child
+		return v, removed, nil
+	}
+
+	return doc, nil, fmt.Errorf("%s does not exist", pointer)
+}
+
+func deepCopy(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(t))
+		for k, e := range t {
+			c[k] = deepCopy(e)
+		}
+		return c
+	case []any:
+		c := make([]any, len(t))
+		for i, e := range t {
+			c[i] = deepCopy(e)
+		}
+		return c
+	}
+	return v
+}
+
+// normalizeNumbers makes json.Number values from decodeDocument comparable
+// with each other regardless of how they were written.
+func normalizeNumbers(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(t))
+		for k, e := range t {
+			c[k] = normalizeNumbers(e)
+		}
+		return c
+	case []any:
+		c := make([]any, len(t))
+		for i, e := range t {
+			c[i] = normalizeNumbers(e)
+		}
+		return c
+	case interface{ Float64() (float64, error) }:
+		f, _ := t.Float64()
+		return f
+	}
+	return v
+}
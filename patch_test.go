@@ -0,0 +1,195 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func decodeTestDocument(t *testing.T, s string) any {
+	t.Helper()
+	doc, err := decodeDocument("test.json", []byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// the examples of RFC 7386 appendix A
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{`{"a": ["b"]}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "c"}`, `{"a": ["b"]}`, `{"a": ["b"]}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`["a", "b"]`, `["c", "d"]`, `["c", "d"]`},
+		{`{"a": "b"}`, `["c"]`, `["c"]`},
+		{`{"a": "foo"}`, `null`, `null`},
+		{`{"a": "foo"}`, `"bar"`, `"bar"`},
+		{`{"e": null}`, `{"a": 1}`, `{"e": null, "a": 1}`},
+		{`[1, 2]`, `{"a": "b", "c": null}`, `{"a": "b"}`},
+		{`{}`, `{"a": {"bb": {"ccc": null}}}`, `{"a": {"bb": {}}}`},
+	}
+
+	for _, tt := range tests {
+		got := mergePatch(decodeTestDocument(t, tt.target), decodeTestDocument(t, tt.patch))
+		assertJSON(t, "mergePatch("+tt.target+", "+tt.patch+")", got, tt.want)
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, want, err string
+	}{
+		{name: "add member", doc: `{"foo": "bar"}`, patch: `[{"op": "add", "path": "/baz", "value": "qux"}]`, want: `{"foo": "bar", "baz": "qux"}`},
+		{name: "insert into array", doc: `{"foo": ["bar", "baz"]}`, patch: `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, want: `{"foo": ["bar", "qux", "baz"]}`},
+		{name: "append to array", doc: `{"foo": [1]}`, patch: `[{"op": "add", "path": "/foo/-", "value": 2}]`, want: `{"foo": [1, 2]}`},
+		{name: "replace root", doc: `{"foo": 1}`, patch: `[{"op": "add", "path": "", "value": [1]}]`, want: `[1]`},
+		{name: "remove", doc: `{"baz": "qux", "foo": "bar"}`, patch: `[{"op": "remove", "path": "/baz"}]`, want: `{"foo": "bar"}`},
+		{name: "remove from array", doc: `{"foo": ["bar", "qux", "baz"]}`, patch: `[{"op": "remove", "path": "/foo/1"}]`, want: `{"foo": ["bar", "baz"]}`},
+		{name: "replace", doc: `{"baz": "qux", "foo": "bar"}`, patch: `[{"op": "replace", "path": "/baz", "value": "boo"}]`, want: `{"baz": "boo", "foo": "bar"}`},
+		{
+			name:  "move",
+			doc:   `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			patch: `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			want:  `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+		},
+		{name: "move in array", doc: `{"foo": ["all", "grass", "cows", "eat"]}`, patch: `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`, want: `{"foo": ["all", "cows", "eat", "grass"]}`},
+		{
+			name:  "copy is deep",
+			doc:   `{"a": {"b": 1}}`,
+			patch: `[{"op": "copy", "from": "/a", "path": "/c"}, {"op": "replace", "path": "/c/b", "value": 2}]`,
+			want:  `{"a": {"b": 1}, "c": {"b": 2}}`,
+		},
+		{name: "test", doc: `{"baz": "qux", "foo": ["a", 2, "c"]}`, patch: `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2.0}]`, want: `{"baz": "qux", "foo": ["a", 2, "c"]}`},
+		{name: "escaped pointer", doc: `{"/": 9, "~1": 10}`, patch: `[{"op": "test", "path": "/~01", "value": 10}, {"op": "remove", "path": "/~1"}]`, want: `{"~1": 10}`},
+
+		{name: "test fails", doc: `{"baz": "qux"}`, patch: `[{"op": "test", "path": "/baz", "value": "bar"}]`, err: "p.patch.json: /0: test failed at /baz (got \"test\")"},
+		{name: "missing parent", doc: `{"foo": "bar"}`, patch: `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`},
+		{name: "remove missing", doc: `{"foo": "bar"}`, patch: `[{"op": "remove", "path": "/baz"}]`},
+		{name: "index out of range", doc: `{"foo": [1]}`, patch: `[{"op": "add", "path": "/foo/2", "value": 2}]`},
+		{name: "missing value", doc: `{}`, patch: `[{"op": "add", "path": "/a"}]`},
+		{name: "missing path", doc: `{}`, patch: `[{"op": "remove"}]`},
+		{name: "unknown op", doc: `{}`, patch: `[{"op": "merge", "path": "/a"}]`},
+		{name: "bad pointer", doc: `{}`, patch: `[{"op": "add", "path": "a", "value": 1}]`},
+		{name: "not an array", doc: `{}`, patch: `{"op": "add"}`, err: "p.patch.json: expected array of operations, got object"},
+		{name: "stops at first error", doc: `{}`, patch: `[{"op": "add", "path": "/a", "value": 1}, {"op": "remove", "path": "/b"}, {"op": "remove", "path": "/c"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs ValidationErrors
+			got := applyJSONPatch("p.patch.json", decodeTestDocument(t, tt.doc), decodeTestDocument(t, tt.patch), &errs)
+
+			if tt.want != "" {
+				if err := errs.Err(); err != nil {
+					t.Fatal(err)
+				}
+				assertJSON(t, "applyJSONPatch()", got, tt.want)
+				return
+			}
+			if len(errs) != 1 {
+				t.Fatalf("got %d errors, want 1: %v", len(errs), errs.Err())
+			}
+			if tt.err != "" && errs.Error() != tt.err {
+				t.Errorf("error %q, want %q", errs.Error(), tt.err)
+			}
+		})
+	}
+}
+
+func TestApplyPatches(t *testing.T) {
+	patchDir, dstDir := t.TempDir(), t.TempDir()
+
+	files := map[string]string{
+		filepath.Join(dstDir, "dns.json"):                  `{"dns": {"servers": [{"tag": "local"}], "strategy": "ipv4_only"}}`,
+		filepath.Join(dstDir, "route.json"):                `{"route": {"final": "proxy"}}`,
+		filepath.Join(patchDir, "dns.merge.json"):          `{"dns": {"strategy": null, "final": "local"}}`,
+		filepath.Join(patchDir, "dns.patch.json"):          `[{"op": "test", "path": "/dns/final", "value": "local"}, {"op": "add", "path": "/dns/servers/-", "value": {"tag": "remote"}}]`,
+		filepath.Join(patchDir, "notes.txt"):               `not a patch`,
+		filepath.Join(patchDir, "experimental.merge.json"): `{"experimental": {}}`,
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := applyPatches(patchDir, dstDir)
+	want := filepath.Join(patchDir, "experimental.merge.json") + ": no exported experimental.json to patch"
+	if err == nil || err.Error() != want {
+		t.Errorf("applyPatches() = %v, want %q", err, want)
+	}
+
+	for name, want := range map[string]string{
+		"dns.json":   `{"dns": {"servers": [{"tag": "local"}, {"tag": "remote"}], "final": "local"}}`,
+		"route.json": `{"route": {"final": "proxy"}}`,
+	} {
+		data, err := os.ReadFile(filepath.Join(dstDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertJSON(t, name, decodeTestDocument(t, string(data)), want)
+	}
+
+	if err := applyPatches(filepath.Join(patchDir, "missing"), dstDir); err != nil {
+		t.Errorf("applyPatches() without a patch directory = %v", err)
+	}
+}
+
+func TestExportPatched(t *testing.T) {
+	srcDir, patchDir, dstDir := t.TempDir(), t.TempDir(), t.TempDir()
+
+	writeTestFiles(t, srcDir, map[string]string{
+		"route.json":     `{"route": {"final": "proxy"}}`,
+		"outbounds.json": `{"outbounds": [{"type": "direct", "tag": "direct"}]}`,
+	})
+	writeTestFiles(t, dstDir, map[string]string{
+		"route.json": `{"route": {"final": "old"}}`,
+	})
+	if err := os.Mkdir(filepath.Join(patchDir, "travel"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second patch fails, nothing may reach the export directory
+	writeTestFiles(t, patchDir, map[string]string{
+		"route.merge.json":     `{"route": {"final": "direct"}}`,
+		"outbounds.patch.json": `[{"op": "remove", "path": "/outbounds/5"}]`,
+	})
+	if err := exportPatched(t.Context(), srcDir, dstDir, patchDir, "", false); err == nil {
+		t.Fatal("exportPatched() with a failing patch succeeded")
+	}
+	if names := dirNames(t, dstDir); !slices.Equal(names, []string{"route.json"}) {
+		t.Errorf("export dir holds %v after a failed patch", names)
+	}
+	data, err := os.ReadFile(filepath.Join(dstDir, "route.json"))
+	if err != nil || string(data) != `{"route": {"final": "old"}}` {
+		t.Errorf("route.json = %s, %v after a failed patch", data, err)
+	}
+
+	writeTestFiles(t, patchDir, map[string]string{
+		"outbounds.patch.json":    `[{"op": "add", "path": "/outbounds/-", "value": {"type": "block", "tag": "block"}}]`,
+		"travel/route.merge.json": `{"route": {"auto_detect_interface": true}}`,
+	})
+	if err := exportPatched(t.Context(), srcDir, dstDir, patchDir, "travel", false); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"route.json":     `{"route": {"final": "direct", "auto_detect_interface": true}}`,
+		"outbounds.json": `{"outbounds": [{"type": "direct", "tag": "direct"}, {"type": "block", "tag": "block"}]}`,
+	} {
+		data, err := os.ReadFile(filepath.Join(dstDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertJSON(t, name, decodeTestDocument(t, string(data)), want)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const pinsFile = "pins.json"
+
+type pinMode string
+
+const (
+	pinOff    pinMode = ""
+	pinReport pinMode = "report"
+	pinTLS    pinMode = "tls"
+)
+
+func (m *pinMode) String() string {
+	return string(*m)
+}
+
+func (m *pinMode) Set(v string) error {
+	switch v {
+	case "true", "report":
+		*m = pinReport
+	case "tls":
+		*m = pinTLS
+	case "false":
+		*m = pinOff
+	default:
+		return fmt.Errorf("unknown pin mode %q, expected report or tls", v)
+	}
+	return nil
+}
+
+// IsBoolFlag lets --pin-insecure be given without a value.
+func (m *pinMode) IsBoolFlag() bool {
+	return true
+}
+
+// PinRecord is the certificate a node presented, keyed by node id in
+// pins.json. PublicKeySHA256 is the base64 form sing-box expects in
+// certificate_public_key_sha256.
+type PinRecord struct {
+	Server          string    `json:"server"`
+	CertSHA256      string    `json:"cert_sha256"`
+	PublicKeySHA256 string    `json:"public_key_sha256"`
+	CheckedAt       time.Time `json:"checked_at"`
+}
+
+// capturePins completes a TLS handshake with every node that skips
+// certificate verification and records the leaf certificate it presents.
+// Nodes that cannot be reached keep their previous record, if any.
+func capturePins(ctx context.Context, outbounds []ServerOutbound, previous map[string]PinRecord) (map[string]PinRecord, error) {
+	const (
+		timeout     = 5 * time.Second
+		concurrency = 16
+	)
+
+	results := make(map[string]PinRecord, len(previous))
+	for id, rec := range previous {
+		results[id] = rec
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	captured := 0
+
+	insecure := 0
+	for _, ob := range outbounds {
+		if ob.TLS != nil && ob.TLS.Insecure {
+			insecure++
+		}
+	}
+	bar := startProgress("capturing certificates", insecure)
+	defer bar.finish()
+
+	for _, ob := range outbounds {
+		if ob.TLS == nil || !ob.TLS.Insecure {
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+
+		go func(ob ServerOutbound) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer bar.add(1)
+
+			dialer := &tls.Dialer{
+				NetDialer: &net.Dialer{Timeout: timeout},
+				Config: &tls.Config{
+					ServerName: ob.TLS.ServerName,
+					// the whole point is to learn the certificate we
+					// cannot verify yet
+					InsecureSkipVerify: true,
+				},
+			}
+
+			conn, err := dialer.DialContext(ctx, "tcp", outboundKey(ob.Server, ob.ServerPort))
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Printf("pin %s failed: %v", ob.Tag, err)
+				return
+			}
+			certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+			conn.Close()
+
+			if len(certs) == 0 {
+				log.Printf("pin %s failed: no certificate presented", ob.Tag)
+				return
+			}
+
+			mu.Lock()
+			results[ob.ID] = newPinRecord(ob, certs[0])
+			captured++
+			mu.Unlock()
+		}(ob)
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("captured %d certificates of insecure nodes", captured)
+	return results, nil
+}
+
+func newPinRecord(ob ServerOutbound, cert *x509.Certificate) PinRecord {
+	certSum := sha256.Sum256(cert.Raw)
+	keySum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	return PinRecord{
+		Server:          outboundKey(ob.Server, ob.ServerPort),
+		CertSHA256:      hex.EncodeToString(certSum[:]),
+		PublicKeySHA256: base64.StdEncoding.EncodeToString(keySum[:]),
+		CheckedAt:       time.Now().UTC(),
+	}
+}
+
+// applyPins replaces insecure=true with verification against the recorded
+// public key for every node that has a pin.
+func applyPins(outbounds []ServerOutbound, pins map[string]PinRecord) {
+	for i := range outbounds {
+		ob := &outbounds[i]
+		if ob.TLS == nil || !ob.TLS.Insecure {
+			continue
+		}
+
+		pin, ok := pins[ob.ID]
+		if !ok {
+			continue
+		}
+
+		ob.TLS.Insecure = false
+		ob.TLS.CertificatePublicKeySHA256 = []string{pin.PublicKeySHA256}
+	}
+}
+
+func savePins(stateDir string, pins map[string]PinRecord) error {
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(stateDir, pinsFile), pins)
+}
+
+// loadPins returns the recorded pins, or an empty map when none were
+// captured yet.
+func loadPins(stateDir string) (map[string]PinRecord, error) {
+	pins := make(map[string]PinRecord)
+
+	data, err := os.ReadFile(filepath.Join(stateDir, pinsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return pins, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// platformProfile holds the limits of a platform sing-box runs on. Apple
+// runs sing-box inside a Network Extension, which is killed when it uses more
+// than about 50MB, so large node lists and rule-sets are a real problem there.
+type platformProfile struct {
+	name string
+	// maxNodes caps the number of server outbounds.
+	maxNodes int
+	// maxURLTest caps the members of every urltest group; each member is
+	// probed periodically, which costs memory and battery.
+	maxURLTest int
+	// unsupported lists outbound types the platform cannot run.
+	unsupported []string
+	// configBudget is the exported size above which the extension is
+	// likely to run out of memory.
+	configBudget int64
+}
+
+var platforms = map[string]*platformProfile{
+	"ios": {
+		name:         "ios",
+		maxNodes:     200,
+		maxURLTest:   20,
+		unsupported:  []string{"tor", "naive", "shadowsocksr"},
+		configBudget: 5 << 20,
+	},
+	"tvos": {
+		name:         "tvos",
+		maxNodes:     100,
+		maxURLTest:   10,
+		unsupported:  []string{"tor", "naive", "shadowsocksr"},
+		configBudget: 3 << 20,
+	},
+}
+
+// platformFlag selects a profile by name; nil means no constraints.
+type platformFlag struct {
+	profile *platformProfile
+}
+
+func (f *platformFlag) String() string {
+	if f.profile == nil {
+		return ""
+	}
+	return f.profile.name
+}
+
+func (f *platformFlag) Set(v string) error {
+	if v == "" {
+		f.profile = nil
+		return nil
+	}
+
+	p, ok := platforms[v]
+	if !ok {
+		names := make([]string, 0, len(platforms))
+		for name := range platforms {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return fmt.Errorf("unknown platform %q, expected one of %s", v, strings.Join(names, ", "))
+	}
+
+	f.profile = p
+	return nil
+}
+
+// limitOutbounds drops outbounds the platform cannot run and caps the rest
+// at maxNodes, keeping the first ones.
+func (p *platformProfile) limitOutbounds(outbounds []ServerOutbound) []ServerOutbound {
+	if p == nil {
+		return outbounds
+	}
+
+	kept := outbounds[:0]
+	for _, ob := range outbounds {
+		if slices.Contains(p.unsupported, ob.Type) {
+			log.Printf("%s: %s outbounds are not supported on %s, skipping", ob.Tag, ob.Type, p.name)
+			buildTrace.note(&ob, "dropped, %s outbounds are not supported on %s", ob.Type, p.name)
+			continue
+		}
+		kept = append(kept, ob)
+	}
+
+	if len(kept) > p.maxNodes {
+		log.Printf("keeping the first %d of %d nodes for %s", p.maxNodes, len(kept), p.name)
+		for i := p.maxNodes; i < len(kept); i++ {
+			buildTrace.note(&kept[i], "dropped, %s keeps the first %d nodes", p.name, p.maxNodes)
+		}
+		kept = kept[:p.maxNodes]
+	}
+	return kept
+}
+
+// urltestMembers caps the members of a urltest group. The selector next to
+// it still lists every node.
+func (p *platformProfile) urltestMembers(tags []string) []string {
+	if p == nil || len(tags) <= p.maxURLTest {
+		return tags
+	}
+	return tags[:p.maxURLTest]
+}
+
+// checkBudget warns when the exported configs are large enough that sing-box
+// will probably exceed the platform's memory limit.
+func (p *platformProfile) checkBudget(dir string) error {
+	if p == nil {
+		return nil
+	}
+
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if total > p.configBudget {
+		log.Printf("warning: exported configs take %s, sing-box on %s will likely exceed its memory limit above %s",
+			ByteSize(total), p.name, ByteSize(p.configBudget))
+	}
+	return nil
+}
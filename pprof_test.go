@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStartPprofLoopbackOnly(t *testing.T) {
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{":6060", false},
+		{"0.0.0.0:6060", false},
+		{"[::]:6060", false},
+		{"192.168.1.1:6060", false},
+		{"pprof.example.com:6060", false},
+		{"127.0.0.1", false},
+		{"127.0.0.1:0", true},
+		{"127.8.0.1:0", true},
+		{"[::1]:0", true},
+		{"localhost:0", true},
+	}
+
+	for _, tt := range tests {
+		srv, err := startPprof(tt.addr)
+		if (err == nil) != tt.ok {
+			t.Errorf("startPprof(%q) error = %v, want ok = %v", tt.addr, err, tt.ok)
+		}
+		if srv != nil {
+			srv.Close()
+		}
+	}
+}
+
+func TestStartPprofServes(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	srv, err := startPprof(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	// the server starts in the background
+	var resp *http.Response
+	for range 50 {
+		if resp, err = http.Get("http://" + addr + "/debug/pprof/"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /debug/pprof/ = %s", resp.Status)
+	}
+}
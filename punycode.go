@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// decodePunycode decodes one RFC 3492 label without its "xn--" prefix. It
+// only exists to show what internationalized hostnames really say.
+func decodePunycode(s string) (string, error) {
+	const (
+		base        = 36
+		tmin        = 1
+		tmax        = 26
+		skew        = 38
+		damp        = 700
+		initialBias = 72
+		initialN    = 128
+	)
+
+	var output []rune
+	if i := strings.LastIndexByte(s, '-'); i >= 0 {
+		output = []rune(s[:i])
+		s = s[i+1:]
+	}
+
+	adapt := func(delta, numPoints int, first bool) int {
+		if first {
+			delta /= damp
+		} else {
+			delta /= 2
+		}
+		delta += delta / numPoints
+		k := 0
+		for delta > ((base-tmin)*tmax)/2 {
+			delta /= base - tmin
+			k += base
+		}
+		return k + (base-tmin+1)*delta/(delta+skew)
+	}
+
+	n, bias, i := initialN, initialBias, 0
+	for pos := 0; pos < len(s); {
+		oldi, w := i, 1
+		for k := base; ; k += base {
+			if pos >= len(s) {
+				return "", errors.New("truncated punycode")
+			}
+
+			c := s[pos]
+			pos++
+
+			var digit int
+			switch {
+			case c >= 'a' && c <= 'z':
+				digit = int(c - 'a')
+			case c >= 'A' && c <= 'Z':
+				digit = int(c - 'A')
+			case c >= '0' && c <= '9':
+				digit = int(c-'0') + 26
+			default:
+				return "", errors.New("invalid punycode digit")
+			}
+
+			i += digit * w
+
+			t := k - bias
+			if t < tmin {
+				t = tmin
+			} else if t > tmax {
+				t = tmax
+			}
+			if digit < t {
+				break
+			}
+			w *= base - t
+			if i > utf8.MaxRune || w > utf8.MaxRune {
+				return "", errors.New("punycode overflow")
+			}
+		}
+
+		bias = adapt(i-oldi, len(output)+1, oldi == 0)
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+		if n > utf8.MaxRune {
+			return "", errors.New("punycode overflow")
+		}
+
+		output = append(output[:i], append([]rune{rune(n)}, output[i:]...)...)
+		i++
+	}
+
+	return string(output), nil
+}
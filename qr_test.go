@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+// The tests decode what encodeQR produced with a reader written from the
+// standard, and compare the fixed parts against its tables.
+
+// qrLevelM holds the block structure of level M from ISO/IEC 18004 table 9
+// for the versions the tests cover: error correction codewords per block,
+// then the number of blocks and data codewords per block of both groups.
+var qrLevelM = map[int]struct {
+	ecc            int
+	blocks1, data1 int
+	blocks2, data2 int
+	alignment      []int
+	versionInfo    int
+}{
+	1:  {ecc: 10, blocks1: 1, data1: 16},
+	2:  {ecc: 16, blocks1: 1, data1: 28, alignment: []int{6, 18}},
+	5:  {ecc: 24, blocks1: 2, data1: 43, alignment: []int{6, 30}},
+	6:  {ecc: 16, blocks1: 4, data1: 27, alignment: []int{6, 34}},
+	7:  {ecc: 18, blocks1: 4, data1: 31, alignment: []int{6, 22, 38}, versionInfo: 0x07C94},
+	10: {ecc: 26, blocks1: 4, data1: 43, blocks2: 1, data2: 44, alignment: []int{6, 28, 50}, versionInfo: 0x0A4D3},
+	20: {ecc: 26, blocks1: 3, data1: 41, blocks2: 13, data2: 42, alignment: []int{6, 34, 62, 90}, versionInfo: 0x149A6},
+	27: {ecc: 28, blocks1: 22, data1: 45, blocks2: 3, data2: 46, alignment: []int{6, 34, 62, 90, 118}, versionInfo: 0x1B08E},
+	40: {ecc: 28, blocks1: 18, data1: 47, blocks2: 31, data2: 48, alignment: []int{6, 30, 58, 86, 114, 142, 170}, versionInfo: 0x28C69},
+}
+
+// qrFormatM are the format information strings of level M, by mask.
+var qrFormatM = [8]int{0x5412, 0x5125, 0x5E7C, 0x5B4B, 0x45F9, 0x40CE, 0x4F97, 0x4AA0}
+
+// qrByteCapacity returns how many bytes fit in a version at level M.
+func qrByteCapacity(version int) int {
+	v := qrLevelM[version]
+	count := 8
+	if version >= 10 {
+		count = 16
+	}
+	return (8*(v.blocks1*v.data1+v.blocks2*v.data2) - 4 - count) / 8
+}
+
+func qrTestData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*131 + i/7)
+	}
+	return data
+}
+
+func TestQRVersion(t *testing.T) {
+	for version := range qrLevelM {
+		n := qrByteCapacity(version)
+		if qr, err := encodeQR(qrTestData(n)); err != nil || qr.size != 17+4*version {
+			t.Errorf("%d bytes: want version %d, got %v, %v", n, version, qrSize(qr), err)
+		}
+		if qr, err := encodeQR(qrTestData(n + 1)); version < 40 && (err != nil || qr.size <= 17+4*version) {
+			t.Errorf("%d bytes: want a version above %d, got %v, %v", n+1, version, qrSize(qr), err)
+		} else if version == 40 && err == nil {
+			t.Errorf("%d bytes: want an error, got version %d", n+1, qrSize(qr))
+		}
+	}
+}
+
+func qrSize(qr *qrCode) int {
+	if qr == nil {
+		return 0
+	}
+	return (qr.size - 17) / 4
+}
+
+func TestQRAlignmentPositions(t *testing.T) {
+	for version, v := range qrLevelM {
+		if got := qrAlignmentPositions(version); !slices.Equal(got, v.alignment) {
+			t.Errorf("version %d: alignment at %v, want %v", version, got, v.alignment)
+		}
+	}
+}
+
+func TestQRRoundTrip(t *testing.T) {
+	for version := range qrLevelM {
+		for _, n := range []int{qrByteCapacity(version), qrByteCapacity(version) - 1} {
+			t.Run(fmt.Sprintf("v%d/%d", version, n), func(t *testing.T) {
+				data := qrTestData(n)
+				qr, err := encodeQR(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := decodeQRModules(qr.modules)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("decoded %x, want %x", got, data)
+				}
+			})
+		}
+	}
+}
+
+func TestQRPNG(t *testing.T) {
+	const scale = 3
+	link := "trojan://password@jp.example.com:443?sni=jp.example.com#JP%2001"
+
+	qr, err := encodeQR([]byte(link))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := qr.writePNG(&buf, scale); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := img.Bounds().Dx()/scale - 8
+	modules := make([][]bool, size)
+	for y := range modules {
+		modules[y] = make([]bool, size)
+		for x := range modules[y] {
+			r, _, _, _ := img.At((x+4)*scale+1, (y+4)*scale+1).RGBA()
+			modules[y][x] = r < 0x8000
+		}
+	}
+
+	got, err := decodeQRModules(modules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != link {
+		t.Errorf("decoded %q, want %q", got, link)
+	}
+}
+
+// decodeQRModules reads a level M byte mode symbol.
+func decodeQRModules(m [][]bool) ([]byte, error) {
+	size := len(m)
+	version := (size - 17) / 4
+	spec, ok := qrLevelM[version]
+	if !ok {
+		return nil, fmt.Errorf("version %d has no test table", version)
+	}
+	bit := func(x, y int) int {
+		if m[y][x] {
+			return 1
+		}
+		return 0
+	}
+
+	// finder patterns, with light separators
+	for _, c := range [][2]int{{0, 0}, {size - 7, 0}, {0, size - 7}} {
+		for dy := -1; dy <= 7; dy++ {
+			for dx := -1; dx <= 7; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || y < 0 || x >= size || y >= size {
+					continue
+				}
+				ring := max(abs(dx-3), abs(dy-3))
+				if want := ring != 2 && ring != 4; m[y][x] != want {
+					return nil, fmt.Errorf("finder pattern wrong at %d,%d", x, y)
+				}
+			}
+		}
+	}
+	for i := 8; i < size-8; i++ {
+		if m[6][i] != (i%2 == 0) || m[i][6] != (i%2 == 0) {
+			return nil, fmt.Errorf("timing pattern wrong at %d", i)
+		}
+	}
+	if !m[size-8][8] {
+		return nil, fmt.Errorf("dark module missing")
+	}
+
+	// both copies of the format information must name a level M mask
+	var format1, format2 int
+	for i, p := range [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}} {
+		format1 |= bit(p[0], p[1]) << i
+	}
+	for i := range 8 {
+		format2 |= bit(size-1-i, 8) << i
+	}
+	for i := 8; i < 15; i++ {
+		format2 |= bit(8, size-15+i) << i
+	}
+	mask := slices.Index(qrFormatM[:], format1)
+	if mask < 0 || format1 != format2 {
+		return nil, fmt.Errorf("format information %015b / %015b is not level M", format1, format2)
+	}
+
+	if version >= 7 {
+		var info1, info2 int
+		for i := range 18 {
+			info1 |= bit(size-11+i%3, i/3) << i
+			info2 |= bit(i/3, size-11+i%3) << i
+		}
+		if info1 != spec.versionInfo || info2 != spec.versionInfo {
+			return nil, fmt.Errorf("version information %x / %x, want %x", info1, info2, spec.versionInfo)
+		}
+	}
+
+	function := make([][]bool, size)
+	for y := range function {
+		function[y] = make([]bool, size)
+		for x := range function[y] {
+			function[y][x] = x < 9 && y < 9 || x >= size-8 && y < 9 || x < 9 && y >= size-8 ||
+				x == 6 || y == 6 ||
+				version >= 7 && (x >= size-11 && x < size-8 && y < 6 || y >= size-11 && y < size-8 && x < 6)
+		}
+	}
+	last := len(spec.alignment) - 1
+	for i, ay := range spec.alignment {
+		for j, ax := range spec.alignment {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for y := ay - 2; y <= ay+2; y++ {
+				for x := ax - 2; x <= ax+2; x++ {
+					function[y][x] = true
+				}
+			}
+		}
+	}
+
+	var masked = [8]func(i, j int) bool{
+		func(i, j int) bool { return (i+j)%2 == 0 },
+		func(i, j int) bool { return i%2 == 0 },
+		func(i, j int) bool { return j%3 == 0 },
+		func(i, j int) bool { return (i+j)%3 == 0 },
+		func(i, j int) bool { return (i/2+j/3)%2 == 0 },
+		func(i, j int) bool { return i*j%2+i*j%3 == 0 },
+		func(i, j int) bool { return (i*j%2+i*j%3)%2 == 0 },
+		func(i, j int) bool { return ((i+j)%2+i*j%3)%2 == 0 },
+	}[mask]
+
+	// two module wide columns from the right, upwards first
+	var stream []byte
+	var cur byte
+	n := 0
+	up := true
+	for right := size - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for k := range size {
+			y := k
+			if up {
+				y = size - 1 - k
+			}
+			for x := right; x > right-2; x-- {
+				if function[y][x] {
+					continue
+				}
+				cur = cur<<1 | byte(bit(x, y))
+				if masked(y, x) {
+					cur ^= 1
+				}
+				if n++; n%8 == 0 {
+					stream = append(stream, cur)
+					cur = 0
+				}
+			}
+		}
+		up = !up
+	}
+
+	numBlocks := spec.blocks1 + spec.blocks2
+	total := spec.blocks1*(spec.data1+spec.ecc) + spec.blocks2*(spec.data2+spec.ecc)
+	if len(stream) != total {
+		return nil, fmt.Errorf("read %d codewords, want %d", len(stream), total)
+	}
+
+	blocks := make([][]byte, numBlocks)
+	dataLen := func(b int) int {
+		if b < spec.blocks1 {
+			return spec.data1
+		}
+		return spec.data2
+	}
+	pos := 0
+	for i := range max(spec.data1, spec.data2) {
+		for b := range blocks {
+			if i < dataLen(b) {
+				blocks[b] = append(blocks[b], stream[pos])
+				pos++
+			}
+		}
+	}
+	for range spec.ecc {
+		for b := range blocks {
+			blocks[b] = append(blocks[b], stream[pos])
+			pos++
+		}
+	}
+
+	var data []byte
+	for b, block := range blocks {
+		for k := range spec.ecc {
+			if s := qrTestEval(block, qrTestExp(k)); s != 0 {
+				return nil, fmt.Errorf("block %d: syndrome %d is %d", b, k, s)
+			}
+		}
+		data = append(data, block[:dataLen(b)]...)
+	}
+
+	if data[0]>>4 != 0x4 {
+		return nil, fmt.Errorf("mode %x is not byte mode", data[0]>>4)
+	}
+	read := func(off, n int) int {
+		v := 0
+		for i := off; i < off+n; i++ {
+			v = v<<1 | int(data[i/8]>>(7-i%8)&1)
+		}
+		return v
+	}
+	count := 8
+	if version >= 10 {
+		count = 16
+	}
+	length := read(4, count)
+	if 4+count+8*length > 8*len(data) {
+		return nil, fmt.Errorf("length %d does not fit", length)
+	}
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = byte(read(4+count+8*i, 8))
+	}
+	return out, nil
+}
+
+// qrTestExp returns 2 to the power of k in GF(2^8) modulo 0x11D.
+func qrTestExp(k int) byte {
+	v := 1
+	for range k {
+		v <<= 1
+		if v&0x100 != 0 {
+			v ^= 0x11D
+		}
+	}
+	return byte(v)
+}
+
+// qrTestEval evaluates the polynomial with coefficients p, highest first,
+// at x.
+func qrTestEval(p []byte, x byte) byte {
+	var v byte
+	for _, c := range p {
+		v = qrTestMul(v, x) ^ c
+	}
+	return v
+}
+
+func qrTestMul(a, b byte) byte {
+	var p byte
+	for b != 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1D
+		}
+		b >>= 1
+	}
+	return p
+}
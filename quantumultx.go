@@ -0,0 +1,167 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var quantumultXServerRe = regexp.MustCompile(`(?m)^[ \t]*(shadowsocks|trojan|http|socks5|vmess|vless)[ \t]*=[ \t]*[^,\s=]+:\d+[ \t]*,`)
+
+// isQuantumultXList reports whether body holds Quantumult X server lines,
+// either as a server list or in the [server_local] section of a profile.
+func isQuantumultXList(body []byte) bool {
+	return quantumultXServerRe.Match(body)
+}
+
+// decodeQuantumultX turns Quantumult X server lines such as
+//
+//	shadowsocks=jp.example.com:443, method=aes-128-gcm, password=pw, tag=JP 01
+//
+// into share links. A profile is only read in its [server_local] section.
+func decodeQuantumultX(body []byte) (lines, skipped []string, err error) {
+	text := string(body)
+	profile := strings.Contains(text, "[server_local]")
+	inServers := !profile
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inServers = !profile || line == "[server_local]"
+			continue
+		}
+		if !inServers || line == "" || line[0] == '#' || line[0] == ';' || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		typ, spec, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		link, tag, err := quantumultXLink(strings.ToLower(strings.TrimSpace(typ)), spec)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("server %q: %v", tag, err))
+			continue
+		}
+		lines = append(lines, link)
+	}
+	return lines, skipped, nil
+}
+
+// quantumultXLink builds the share link of a Quantumult X server line and
+// returns its tag, which falls back to host:port.
+func quantumultXLink(typ, spec string) (link, tag string, err error) {
+	fields := strings.Split(spec, ",")
+	endpoint := strings.TrimSpace(fields[0])
+
+	params := make(map[string]string)
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(field, "=")
+		params[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	param := func(key string) string { return params[key] }
+	tag = cmp.Or(param("tag"), endpoint)
+
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return "", tag, fmt.Errorf("invalid address %q", endpoint)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", tag, fmt.Errorf("invalid port %q", portStr)
+	}
+
+	ob := &ServerOutbound{
+		BaseOutbound: BaseOutbound{Tag: tag},
+		Server:       host,
+		ServerPort:   port,
+		Username:     param("username"),
+		Password:     param("password"),
+	}
+	tls := func() *TLSOptions {
+		return &TLSOptions{
+			Enabled:    true,
+			ServerName: param("tls-host"),
+			Insecure:   param("tls-verification") == "false",
+			ALPN:       splitList(param("tls-alpn")),
+		}
+	}
+	overTLS := param("over-tls") == "true"
+	obfs, obfsHost, obfsURI := param("obfs"), param("obfs-host"), cmp.Or(param("obfs-uri"), "/")
+
+	switch typ {
+	case "shadowsocks":
+		ob.Type = "shadowsocks"
+		ob.Method = param("method")
+
+		// the ssr parameters turn the line into an ssr server
+		if protocol := param("ssr-protocol"); protocol != "" {
+			ob.Type = "shadowsocksr"
+			ob.Protocol = protocol
+			ob.ProtocolParam = param("ssr-protocol-param")
+			ob.Obfs.Value = cmp.Or(obfs, "plain")
+			ob.ObfsParam = obfsHost
+			break
+		}
+
+		switch obfs {
+		case "":
+		case "http", "tls":
+			ob.Plugin = "obfs-local"
+			ob.PluginOpts = "obfs=" + obfs
+			if obfsHost != "" {
+				ob.PluginOpts += ";obfs-host=" + obfsHost
+			}
+		case "ws", "wss":
+			ob.Plugin = "v2ray-plugin"
+			parts := []string{"mode=websocket"}
+			if obfs == "wss" {
+				parts = append(parts, "tls")
+			}
+			if obfsHost != "" {
+				parts = append(parts, "host="+obfsHost)
+			}
+			ob.PluginOpts = strings.Join(append(parts, "path="+obfsURI), ";")
+		default:
+			return "", tag, fmt.Errorf("unsupported obfs %q", obfs)
+		}
+
+	case "trojan":
+		ob.Type = "trojan"
+		ob.TLS = tls()
+		switch obfs {
+		case "", "over-tls":
+		case "wss":
+			ob.TLS.ServerName = cmp.Or(ob.TLS.ServerName, obfsHost)
+			ob.Transport = &TransportOptions{Type: "ws", Path: obfsURI}
+			if obfsHost != "" {
+				ob.Transport.Headers = map[string]string{"Host": obfsHost}
+			}
+		default:
+			return "", tag, fmt.Errorf("unsupported obfs %q", obfs)
+		}
+
+	case "http":
+		ob.Type = "http"
+		if overTLS {
+			ob.TLS = tls()
+		}
+
+	case "socks5":
+		if overTLS {
+			return "", tag, errors.New("socks over tls has no sing-box outbound")
+		}
+		ob.Type = "socks"
+
+	default:
+		return "", tag, fmt.Errorf("msbc cannot read %s nodes", typ)
+	}
+
+	link, err = shareLink(ob)
+	return link, tag, err
+}
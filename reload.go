@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type reloadOptions struct {
+	Mode        string
+	ProcessName string
+}
+
+func registerReloadFlags(fs *flag.FlagSet, opts *reloadOptions) {
+	fs.StringVar(&opts.Mode, "reload", "none", "how to make sing-box pick up new configs: none, signal or openwrt")
+	fs.StringVar(&opts.ProcessName, "reload-process", "sing-box", "process name to send SIGHUP to with -reload=signal")
+}
+
+// reloadSingBox tells a running sing-box that its configuration changed.
+// The signal mode works whenever msbc can see the sing-box process, e.g. in
+// a pod with shareProcessNamespace.
+func reloadSingBox(ctx context.Context, opts *reloadOptions) error {
+	switch opts.Mode {
+	case "", "none":
+		return nil
+	case "signal":
+		return signalProcess(opts.ProcessName, syscall.SIGHUP)
+	case "openwrt":
+		return reloadOpenWrt(ctx)
+	case "clash-api":
+		// PUT /configs is accepted by sing-box but does not reload anything
+		return errors.New("sing-box cannot be reloaded through clash_api; use -reload signal, -reload openwrt or restart its service")
+	}
+	return fmt.Errorf("unknown reload mode %q", opts.Mode)
+}
+
+// findProcesses returns the pids of processes called name by scanning /proc.
+func findProcesses(name string) ([]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+
+		comm, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+
+		if strings.TrimSpace(string(comm)) == name {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+func signalProcess(name string, sig os.Signal) error {
+	pids, err := findProcesses(name)
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return fmt.Errorf("no %s process found", name)
+	}
+
+	for _, pid := range pids {
+		proc, err := os.FindProcess(pid)
+		if err != nil {
+			return err
+		}
+		if err := proc.Signal(sig); err != nil {
+			return fmt.Errorf("signal %s (pid %d): %v", name, pid, err)
+		}
+		log.Printf("sent %v to %s (pid %d)", sig, name, pid)
+	}
+	return nil
+}
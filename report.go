@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Report records what a build produced so that other tools (and later runs)
+// can follow nodes by id rather than by their provider-chosen tags.
+type Report struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Sources     []SourceReport `json:"sources"`
+	Nodes       []NodeReport   `json:"nodes"`
+	Rejected    []Rejection    `json:"rejected,omitempty"`
+}
+
+type SourceReport struct {
+	Name     string   `json:"name"`
+	Nodes    int      `json:"nodes"`
+	Warnings []string `json:"warnings,omitempty"`
+	SourceMeta
+}
+
+type NodeReport struct {
+	ID         string `json:"id"`
+	Tag        string `json:"tag"`
+	Region     string `json:"region"`
+	Type       string `json:"type"`
+	Source     string `json:"source"`
+	Server     string `json:"server"`
+	ServerPort int    `json:"server_port"`
+}
+
+func newReport(cfg *Config, payloads []sourcePayload, outbounds []ServerOutbound, rejected []Rejection) *Report {
+	now := time.Now().UTC()
+	report := &Report{
+		GeneratedAt: now,
+		Rejected:    rejected,
+		Sources:     make([]SourceReport, 0, len(payloads)),
+		Nodes:       make([]NodeReport, 0, len(outbounds)),
+	}
+
+	counts := make(map[string]int)
+	for _, ob := range outbounds {
+		counts[ob.Source]++
+	}
+
+	for _, p := range payloads {
+		report.Sources = append(report.Sources, SourceReport{
+			Name:       p.Source.Name,
+			Nodes:      counts[p.Source.Name],
+			Warnings:   expiryWarnings(cfg.Expiry, p.Meta.Usage, now),
+			SourceMeta: p.Meta,
+		})
+	}
+
+	for _, ob := range outbounds {
+		report.Nodes = append(report.Nodes, NodeReport{
+			ID:         ob.ID,
+			Tag:        ob.Tag,
+			Region:     ob.Region,
+			Type:       ob.Type,
+			Source:     ob.Source,
+			Server:     ob.Server,
+			ServerPort: ob.ServerPort,
+		})
+	}
+
+	return report
+}
+
+func writeReport(dir string, report *Report) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, "report.json")
+	if err := writeJSON(path, report); err != nil {
+		return err
+	}
+
+	log.Printf("wrote %s", path)
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ResolveConfig controls the optional resolution stage, which looks up the
+// addresses of every node before the configs are generated.
+//
+// Family "ipv4" or "ipv6" drops nodes without an address of that family,
+// e.g. IPv6-only nodes on a v4-only network. "prefer-ipv4" and "prefer-ipv6"
+// keep every node and only decide which address Replace uses. With Replace
+// the server is rewritten to the chosen address, so sing-box does not depend
+// on DNS to reach it.
+type ResolveConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Family  string `json:"family,omitempty"`
+	Replace bool   `json:"replace,omitempty"`
+}
+
+var resolveFamilies = []string{"", "ipv4", "ipv6", "prefer-ipv4", "prefer-ipv6"}
+
+// resolveOutbounds records the addresses of every node in Addrs. Nodes that
+// are already IP literals are not looked up; nodes that fail to resolve keep
+// an empty list.
+func resolveOutbounds(ctx context.Context, outbounds []ServerOutbound) error {
+	const (
+		timeout     = 5 * time.Second
+		concurrency = 16
+	)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	resolved := make(map[string][]netip.Addr)
+	var mu sync.Mutex
+
+	bar := startProgress("resolving", len(outbounds))
+	defer bar.finish()
+
+	for _, ob := range outbounds {
+		bar.add(1)
+		if _, ok := resolved[ob.Server]; ok {
+			continue
+		}
+		if addr, err := netip.ParseAddr(ob.Server); err == nil {
+			resolved[ob.Server] = []netip.Addr{addr.Unmap()}
+			continue
+		}
+		resolved[ob.Server] = nil
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+
+		go func(host string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			addrs, err := net.DefaultResolver.LookupNetIP(lookupCtx, "ip", host)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("resolve %s failed: %v", host, err)
+				}
+				return
+			}
+
+			for i := range addrs {
+				addrs[i] = addrs[i].Unmap()
+			}
+
+			mu.Lock()
+			resolved[host] = addrs
+			mu.Unlock()
+		}(ob.Server)
+	}
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for i := range outbounds {
+		outbounds[i].Addrs = resolved[outbounds[i].Server]
+	}
+	return nil
+}
+
+// filterFamily applies the family setting to resolved outbounds. Nodes that
+// did not resolve are kept, since their family is unknown.
+func filterFamily(cfg ResolveConfig, outbounds []ServerOutbound) []ServerOutbound {
+	kept := outbounds[:0]
+
+	for _, ob := range outbounds {
+		v4, v6 := splitFamilies(ob.Addrs)
+
+		switch {
+		case cfg.Family == "ipv4" && len(v4) == 0 && len(v6) > 0:
+			log.Printf("%s: no IPv4 address, skipping", ob.Tag)
+			buildTrace.note(&ob, "dropped, resolved to IPv6 only")
+			continue
+		case cfg.Family == "ipv6" && len(v6) == 0 && len(v4) > 0:
+			log.Printf("%s: no IPv6 address, skipping", ob.Tag)
+			buildTrace.note(&ob, "dropped, resolved to IPv4 only")
+			continue
+		}
+
+		if cfg.Replace && len(ob.Addrs) > 0 {
+			from := ob.Server
+			ob.setServerAddr(pickAddr(cfg.Family, v4, v6))
+			buildTrace.note(&ob, "server %s replaced by %s", from, ob.Server)
+		}
+		kept = append(kept, ob)
+	}
+
+	return kept
+}
+
+func splitFamilies(addrs []netip.Addr) (v4, v6 []netip.Addr) {
+	for _, addr := range addrs {
+		if addr.Is4() {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+	return v4, v6
+}
+
+func pickAddr(family string, v4, v6 []netip.Addr) netip.Addr {
+	switch family {
+	case "ipv6", "prefer-ipv6":
+		if len(v6) > 0 {
+			return v6[0]
+		}
+		return v4[0]
+	}
+	if len(v4) > 0 {
+		return v4[0]
+	}
+	return v6[0]
+}
+
+// setServerAddr points the node at addr. TLS keeps verifying the original
+// hostname, which would otherwise be lost with the server field.
+func (ob *ServerOutbound) setServerAddr(addr netip.Addr) {
+	if ob.TLS != nil && ob.TLS.ServerName == "" {
+		if _, err := netip.ParseAddr(ob.Server); err != nil {
+			ob.TLS.ServerName = ob.Server
+		}
+	}
+	ob.Server = addr.String()
+}
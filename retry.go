@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// RetryConfig controls how often a fetch is repeated before the source
+// counts as failed. Attempts includes the first try; the wait doubles from
+// Backoff after every failure, up to MaxBackoff. Transport errors are
+// always retried, HTTP errors only with a status listed in Statuses.
+type RetryConfig struct {
+	Attempts   int      `json:"attempts"`
+	Backoff    Duration `json:"backoff"`
+	MaxBackoff Duration `json:"max_backoff"`
+	Statuses   []int    `json:"statuses"`
+}
+
+func defaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		Attempts:   3,
+		Backoff:    Duration(2 * time.Second),
+		MaxBackoff: Duration(30 * time.Second),
+		Statuses:   []int{408, 429, 500, 502, 503, 504},
+	}
+}
+
+// statusError is a response other than 200 OK.
+type statusError struct {
+	Code   int
+	Status string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected HTTP status: " + e.Status
+}
+
+// retryable reports whether another attempt could succeed where err failed.
+// Local files, offline mode and undecodable bodies fail the same way again.
+func (cfg *RetryConfig) retryable(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return slices.Contains(cfg.Statuses, status.Code)
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// backoff returns the wait before the given retry, counting from 1.
+func (cfg *RetryConfig) backoff(retry int) time.Duration {
+	wait := time.Duration(cfg.Backoff)
+	for range retry - 1 {
+		wait *= 2
+		if wait >= time.Duration(cfg.MaxBackoff) {
+			return time.Duration(cfg.MaxBackoff)
+		}
+	}
+	return min(wait, time.Duration(cfg.MaxBackoff))
+}
+
+// fetchWithRetry is fetchSubscription, repeated as cfg allows.
+func fetchWithRetry(ctx context.Context, client *http.Client, cfg *RetryConfig, src SourceConfig, opts *fetchOptions) ([]byte, http.Header, error) {
+	for attempt := 1; ; attempt++ {
+		body, header, err := fetchSubscription(ctx, client, src.URL, src.Headers, opts)
+		if err == nil || attempt >= cfg.Attempts || !cfg.retryable(err) || ctx.Err() != nil {
+			return body, header, err
+		}
+
+		wait := cfg.backoff(attempt)
+		log.Printf("source %s: attempt %d of %d failed: %v, retrying in %s", src.Name, attempt, cfg.Attempts, err, wait)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
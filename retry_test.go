@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	cfg := &RetryConfig{Backoff: Duration(2 * time.Second), MaxBackoff: Duration(30 * time.Second)}
+
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second}
+	for i, w := range want {
+		if got := cfg.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+
+	// a maximum below the first wait caps it as well
+	cfg.MaxBackoff = Duration(time.Second)
+	if got := cfg.backoff(1); got != time.Second {
+		t.Errorf("backoff(1) = %s, want 1s", got)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	cfg := defaultRetryConfig()
+
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&statusError{Code: 503, Status: "503 Service Unavailable"}, true},
+		{fmt.Errorf("source a: %w", &statusError{Code: 429, Status: "429 Too Many Requests"}), true},
+		{&statusError{Code: 404, Status: "404 Not Found"}, false},
+		{&url.Error{Op: "Get", URL: "https://example.com", Err: errors.New("connection refused")}, true},
+		{os.ErrNotExist, false},
+		{errors.New("invalid subscription"), false},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFetchWithRetry(t *testing.T) {
+	cfg := &RetryConfig{Attempts: 3, Backoff: Duration(time.Millisecond), MaxBackoff: Duration(time.Millisecond), Statuses: []int{503}}
+
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		err      bool
+	}{
+		{"first try", []int{200}, 1, false},
+		{"recovers", []int{503, 503, 200}, 3, false},
+		{"gives up", []int{503, 503, 503, 200}, 3, true},
+		{"not retryable", []int{404, 200}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[attempts])
+				attempts++
+				fmt.Fprint(w, "body")
+			}))
+			defer srv.Close()
+
+			src := SourceConfig{Name: "test", URL: srv.URL}
+			body, _, err := fetchWithRetry(context.Background(), srv.Client(), cfg, src, &fetchOptions{})
+			if (err != nil) != tt.err {
+				t.Errorf("fetchWithRetry() error = %v, want error %v", err, tt.err)
+			}
+			if err == nil && string(body) != "body" {
+				t.Errorf("body = %q", body)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("%d attempts, want %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestFetchWithRetryLocalFile(t *testing.T) {
+	cfg := defaultRetryConfig()
+	src := SourceConfig{Name: "local", URL: filepath.Join(t.TempDir(), "missing.txt")}
+
+	start := time.Now()
+	if _, _, err := fetchWithRetry(context.Background(), http.DefaultClient, &cfg, src, &fetchOptions{}); err == nil {
+		t.Fatal("fetchWithRetry() of a missing file succeeded")
+	}
+	if elapsed := time.Since(start); elapsed >= time.Duration(cfg.Backoff) {
+		t.Errorf("a missing local file was retried, took %s", elapsed)
+	}
+}
+
+func TestFetchWithRetryCanceled(t *testing.T) {
+	cfg := &RetryConfig{Attempts: 3, Backoff: Duration(time.Hour), MaxBackoff: Duration(time.Hour), Statuses: []int{503}}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	src := SourceConfig{Name: "test", URL: srv.URL}
+	if _, _, err := fetchWithRetry(ctx, srv.Client(), cfg, src, &fetchOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("fetchWithRetry() = %v, want the context error", err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// RoutingMarkConfig sets the routing_mark (fwmark) of generated server
+// outbounds, so their traffic can be told apart by policy routing. A region
+// mark wins over a source mark, which wins over Default; 0 sets no mark.
+type RoutingMarkConfig struct {
+	Default uint32            `json:"default,omitempty"`
+	Regions map[string]uint32 `json:"regions,omitempty"`
+	Sources map[string]uint32 `json:"sources,omitempty"`
+}
+
+// routingMark returns the mark for ob. Region names match case-insensitively
+// since they come from provider tags; an exact match wins, then the first
+// region in sorted order, so the same config always gives the same marks.
+func (c *RoutingMarkConfig) routingMark(ob *ServerOutbound) uint32 {
+	if mark, ok := c.Regions[ob.Region]; ok {
+		return mark
+	}
+	for _, region := range slices.Sorted(maps.Keys(c.Regions)) {
+		if strings.EqualFold(region, ob.Region) {
+			return c.Regions[region]
+		}
+	}
+	if mark, ok := c.Sources[ob.Source]; ok {
+		return mark
+	}
+	return c.Default
+}
+
+// applyRoutingMarks runs after groupRegions has assigned the regions.
+func applyRoutingMarks(c *RoutingMarkConfig, outbounds []ServerOutbound) {
+	for i := range outbounds {
+		ob := &outbounds[i]
+
+		ob.RoutingMark = c.routingMark(ob)
+		if ob.RoutingMark != 0 {
+			buildTrace.note(ob, "routing mark %d", ob.RoutingMark)
+			// the configured mark replaces one the subconverter set
+			delete(ob.Extra, "routing_mark")
+		}
+	}
+}
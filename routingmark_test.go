@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestRoutingMark(t *testing.T) {
+	c := &RoutingMarkConfig{
+		Default: 1,
+		Regions: map[string]uint32{"hk": 10, "HK": 11, "Hk": 12, "JAPAN": 20},
+		Sources: map[string]uint32{"work": 30},
+	}
+
+	tests := []struct {
+		region, source string
+		want           uint32
+	}{
+		{"HK", "", 11},
+		{"hk", "work", 10},
+		{"hK", "", 11},
+		{"Japan", "work", 20},
+		{"US", "work", 30},
+		{"US", "home", 1},
+	}
+
+	for _, tt := range tests {
+		ob := &ServerOutbound{Region: tt.region, Source: tt.source}
+		// the same answer every time, whatever order the map is read in
+		for range 20 {
+			if got := c.routingMark(ob); got != tt.want {
+				t.Fatalf("routingMark(%s, %s) = %d, want %d", tt.region, tt.source, got, tt.want)
+			}
+		}
+	}
+}
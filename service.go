@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+const defaultServiceLabel = "io.github.q1an1x.msbc"
+
+type serviceOptions struct {
+	Label    string
+	Interval time.Duration
+	System   bool
+	WorkDir  string
+	Print    bool
+}
+
+func serviceCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	opts := &serviceOptions{}
+
+	fs.StringVar(&opts.Label, "label", defaultServiceLabel, "launchd label of the job")
+	fs.DurationVar(&opts.Interval, "interval", time.Hour, "time between builds")
+	fs.BoolVar(&opts.System, "system", false, "install a system-wide LaunchDaemon instead of a LaunchAgent of the current user (needs root)")
+	fs.StringVar(&opts.WorkDir, "workdir", "", "directory holding msbc.json, the templates and state (default the current directory)")
+	fs.BoolVar(&opts.Print, "print", false, "print the plist instead of installing it")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: msbc service [flags] install [-- build flags]\n")
+		fmt.Fprintf(fs.Output(), "       msbc service [flags] uninstall\n\n")
+		fs.PrintDefaults()
+	}
+
+	return func(ctx context.Context) error {
+		if fs.NArg() < 1 {
+			fs.Usage()
+			return errors.New("expected install or uninstall")
+		}
+
+		switch fs.Arg(0) {
+		case "install":
+			buildArgs := fs.Args()[1:]
+			if len(buildArgs) > 0 && buildArgs[0] == "--" {
+				buildArgs = buildArgs[1:]
+			}
+			return installService(ctx, opts, buildArgs)
+		case "uninstall":
+			if fs.NArg() > 1 {
+				return errors.New("uninstall takes no build flags")
+			}
+			return uninstallService(ctx, opts)
+		}
+		return fmt.Errorf("unknown action %q, expected install or uninstall", fs.Arg(0))
+	}
+}
+
+// launchdJob holds everything the plist refers to. Paths are absolute since
+// launchd starts jobs from / with a minimal environment.
+type launchdJob struct {
+	Label     string
+	Args      []string
+	WorkDir   string
+	Interval  int
+	LogPath   string
+	PlistPath string
+	Domain    string
+}
+
+func newLaunchdJob(opts *serviceOptions, buildArgs []string) (*launchdJob, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return nil, err
+	}
+
+	workDir := opts.WorkDir
+	if workDir == "" {
+		if workDir, err = os.Getwd(); err != nil {
+			return nil, err
+		}
+	}
+	if workDir, err = filepath.Abs(workDir); err != nil {
+		return nil, err
+	}
+
+	if opts.Interval < time.Minute {
+		return nil, fmt.Errorf("-interval %v is shorter than a minute", opts.Interval)
+	}
+
+	job := &launchdJob{
+		Label:    opts.Label,
+		Args:     append([]string{exe, "build"}, buildArgs...),
+		WorkDir:  workDir,
+		Interval: int(opts.Interval / time.Second),
+	}
+
+	if opts.System {
+		job.PlistPath = filepath.Join("/Library/LaunchDaemons", opts.Label+".plist")
+		job.LogPath = "/Library/Logs/msbc/msbc.log"
+		job.Domain = "system"
+	} else {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		job.PlistPath = filepath.Join(home, "Library/LaunchAgents", opts.Label+".plist")
+		job.LogPath = filepath.Join(home, "Library/Logs/msbc/msbc.log")
+		job.Domain = "gui/" + strconv.Itoa(os.Getuid())
+	}
+
+	return job, nil
+}
+
+// installService writes the plist and (re)loads it, so running install
+// again picks up changed flags.
+func installService(ctx context.Context, opts *serviceOptions, buildArgs []string) error {
+	job, err := newLaunchdJob(opts, buildArgs)
+	if err != nil {
+		return err
+	}
+
+	var plist bytes.Buffer
+	if err := writePlist(&plist, job); err != nil {
+		return err
+	}
+
+	if opts.Print {
+		_, err := os.Stdout.Write(plist.Bytes())
+		return err
+	}
+	if runtime.GOOS != "darwin" {
+		return errors.New("launchd services are only supported on macOS, use -print to see the plist")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(job.LogPath), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(job.PlistPath), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(job.PlistPath, plist.Bytes(), 0644); err != nil {
+		return err
+	}
+	log.Printf("wrote %s", job.PlistPath)
+
+	// a job that is already loaded keeps its old definition until booted out
+	_ = launchctl(ctx, "bootout", job.Domain+"/"+job.Label)
+	if err := launchctl(ctx, "bootstrap", job.Domain, job.PlistPath); err != nil {
+		return err
+	}
+
+	log.Printf("loaded %s, building every %v, logging to %s", job.Label, opts.Interval, job.LogPath)
+	return nil
+}
+
+func uninstallService(ctx context.Context, opts *serviceOptions) error {
+	if runtime.GOOS != "darwin" {
+		return errors.New("launchd services are only supported on macOS")
+	}
+
+	job, err := newLaunchdJob(opts, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := launchctl(ctx, "bootout", job.Domain+"/"+job.Label); err != nil {
+		log.Printf("warning: %v", err)
+	}
+
+	if err := os.Remove(job.PlistPath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%s is not installed", job.Label)
+		}
+		return err
+	}
+
+	log.Printf("removed %s", job.PlistPath)
+	return nil
+}
+
+func launchctl(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, "launchctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl %s: %v: %s", args[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func writePlist(w io.Writer, job *launchdJob) error {
+	return plistTemplate.Execute(w, job)
+}
+
+var plistTemplate = template.Must(template.New("plist").Funcs(template.FuncMap{
+	"xml": func(s string) (string, error) {
+		var b bytes.Buffer
+		err := xml.EscapeText(&b, []byte(s))
+		return b.String(), err
+	},
+}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args}}
+		<string>{{xml .}}</string>
+{{- end}}
+	</array>
+	<key>WorkingDirectory</key>
+	<string>{{xml .WorkDir}}</string>
+	<key>StartInterval</key>
+	<integer>{{.Interval}}</integer>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>{{xml .LogPath}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .LogPath}}</string>
+</dict>
+</plist>
+`))
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// shadowsocksMethods are the ciphers sing-box implements.
+var shadowsocksMethods = []string{
+	"2022-blake3-aes-128-gcm", "2022-blake3-aes-256-gcm", "2022-blake3-chacha20-poly1305",
+	"aes-128-gcm", "aes-192-gcm", "aes-256-gcm", "chacha20-ietf-poly1305", "xchacha20-ietf-poly1305",
+	"aes-128-ctr", "aes-192-ctr", "aes-256-ctr", "aes-128-cfb", "aes-192-cfb", "aes-256-cfb",
+	"rc4-md5", "chacha20-ietf", "xchacha20", "none",
+}
+
+// parseShadowsocksURL reads a SIP002 link, whose userinfo is either base64
+// or, as required for the 2022 methods, plain and percent-encoded:
+//
+//	ss://base64url(method:password)@host:port/?plugin=obfs-local%3Bobfs%3Dhttp#tag
+//	ss://2022-blake3-aes-256-gcm:percent-encoded-password@host:port#tag
+//
+// Links in the legacy format that encodes everything before the tag are
+// accepted as well:
+//
+//	ss://base64(method:password@host:port)#tag
+func parseShadowsocksURL(u *url.URL) (*ServerOutbound, error) {
+	if u.User == nil {
+		legacy, err := legacyShadowsocksURL(u)
+		if err != nil {
+			return nil, err
+		}
+		u = legacy
+	}
+
+	host, port, err := linkEndpoint(u)
+	if err != nil {
+		return nil, err
+	}
+
+	method, password, err := shadowsocksCredentials(u.User)
+	if err != nil {
+		return nil, fmt.Errorf("invalid userinfo for %s: %v", host, err)
+	}
+
+	if !slices.Contains(shadowsocksMethods, method) {
+		return nil, fmt.Errorf("unsupported method %q for %s", method, host)
+	}
+
+	ob := &ServerOutbound{
+		BaseOutbound: BaseOutbound{Type: "shadowsocks"},
+		Server:       host,
+		ServerPort:   port,
+		Password:     password,
+		Method:       method,
+	}
+
+	q := u.Query()
+
+	// shadow-tls is not a plugin in sing-box but an outbound of its own
+	if plugin := q.Get("plugin"); plugin != "" {
+		if opts, ok := strings.CutPrefix(plugin, "shadow-tls;"); ok {
+			ob.ShadowTLS, err = parseShadowTLSPlugin(opts)
+		} else {
+			ob.Plugin, ob.PluginOpts, err = convertPlugin(plugin)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", host, err)
+		}
+	}
+	if v := q.Get("shadow-tls"); v != "" {
+		if ob.ShadowTLS, err = parseShadowTLSParam(v); err != nil {
+			return nil, fmt.Errorf("%s: %v", host, err)
+		}
+	}
+
+	return ob, nil
+}
+
+func shadowsocksCredentials(user *url.Userinfo) (string, string, error) {
+	if password, ok := user.Password(); ok {
+		return strings.ToLower(user.Username()), password, nil
+	}
+
+	userinfo, err := decodeBase64Loose(user.Username())
+	if err != nil {
+		return "", "", err
+	}
+
+	method, password, ok := strings.Cut(userinfo, ":")
+	if !ok {
+		return "", "", errors.New("missing password")
+	}
+	return strings.ToLower(method), password, nil
+}
+
+// legacyShadowsocksURL decodes a link in the format that predates SIP002
+// into its SIP002 equivalent. The password may contain "@", so the last one
+// separates it from the endpoint.
+func legacyShadowsocksURL(u *url.URL) (*url.URL, error) {
+	encoded := u.Host + u.Path
+	if u.Opaque != "" {
+		encoded = u.Opaque
+	}
+
+	decoded, err := decodeBase64Loose(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid legacy shadowsocks link: %v", err)
+	}
+
+	i := strings.LastIndex(decoded, "@")
+	if i < 0 {
+		return nil, errors.New("invalid legacy shadowsocks link: missing server")
+	}
+	method, password, ok := strings.Cut(decoded[:i], ":")
+	if !ok {
+		return nil, errors.New("invalid legacy shadowsocks link: missing password")
+	}
+
+	return &url.URL{
+		Scheme:   u.Scheme,
+		User:     url.UserPassword(method, password),
+		Host:     decoded[i+1:],
+		RawQuery: u.RawQuery,
+		Fragment: u.Fragment,
+	}, nil
+}
+
+// decodeBase64Loose accepts the standard and URL-safe alphabets with or
+// without padding, as found in the wild.
+func decodeBase64Loose(s string) (string, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// convertPlugin maps a SIP003 plugin string ("name;opt=v;flag") onto the
+// plugins sing-box implements itself. Anything else is an error, since a
+// node whose plugin is ignored connects but never works.
+func convertPlugin(plugin string) (string, string, error) {
+	name, rawOpts, _ := strings.Cut(plugin, ";")
+
+	var opts []string
+	if rawOpts != "" {
+		opts = strings.Split(rawOpts, ";")
+	}
+
+	switch name {
+	case "obfs-local", "simple-obfs", "obfs":
+		for _, opt := range opts {
+			if v, ok := strings.CutPrefix(opt, "obfs="); ok && v != "http" && v != "tls" {
+				return "", "", fmt.Errorf("unsupported obfs mode %q", v)
+			}
+		}
+		return "obfs-local", strings.Join(opts, ";"), nil
+
+	case "v2ray-plugin":
+		for _, opt := range opts {
+			if v, ok := strings.CutPrefix(opt, "mode="); ok && v != "websocket" {
+				return "", "", fmt.Errorf("unsupported v2ray-plugin mode %q, only websocket is available in sing-box", v)
+			}
+		}
+		return "v2ray-plugin", strings.Join(opts, ";"), nil
+	}
+
+	return "", "", fmt.Errorf("unsupported plugin %q", name)
+}
+
+// shadowsocksLink is the inverse of parseShadowsocksURL. SIP002 leaves the
+// userinfo of the 2022 methods unencoded.
+func shadowsocksLink(ob *ServerOutbound) string {
+	user := url.User(base64.RawURLEncoding.EncodeToString([]byte(ob.Method + ":" + ob.Password)))
+	if strings.HasPrefix(ob.Method, "2022-") {
+		user = url.UserPassword(ob.Method, ob.Password)
+	}
+
+	u := url.URL{
+		Scheme:   "ss",
+		User:     user,
+		Host:     net.JoinHostPort(ob.Server, strconv.Itoa(ob.ServerPort)),
+		Fragment: ob.Tag,
+	}
+
+	q := url.Values{}
+	if ob.Plugin != "" {
+		plugin := ob.Plugin
+		if ob.PluginOpts != "" {
+			plugin += ";" + ob.PluginOpts
+		}
+		q.Set("plugin", plugin)
+	}
+	setShadowTLSParam(q, ob.ShadowTLS)
+	if len(q) > 0 {
+		u.Path = "/"
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String()
+}
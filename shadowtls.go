@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ShadowTLSOptions describes the shadowtls outbound a node is wrapped in.
+// sing-box needs it as a separate outbound that the node detours through;
+// it is written right after the node and named after it.
+type ShadowTLSOptions struct {
+	Version  int
+	Password string
+	// Host is the server the handshake is borrowed from.
+	Host string
+}
+
+type shadowTLSOutbound struct {
+	BaseOutbound
+
+	Server      string      `json:"server"`
+	ServerPort  int         `json:"server_port"`
+	Version     int         `json:"version"`
+	Password    string      `json:"password,omitempty"`
+	TLS         *TLSOptions `json:"tls"`
+	RoutingMark uint32      `json:"routing_mark,omitempty"`
+}
+
+func shadowTLSTag(tag string) string {
+	return tag + "-shadowtls"
+}
+
+func (ob *ServerOutbound) shadowTLSOutbound() shadowTLSOutbound {
+	return shadowTLSOutbound{
+		BaseOutbound: BaseOutbound{Type: "shadowtls", Tag: shadowTLSTag(ob.Tag)},
+		Server:       ob.Server,
+		ServerPort:   ob.ServerPort,
+		Version:      ob.ShadowTLS.Version,
+		Password:     ob.ShadowTLS.Password,
+		TLS:          &TLSOptions{Enabled: true, ServerName: ob.ShadowTLS.Host},
+		RoutingMark:  ob.RoutingMark,
+	}
+}
+
+// expandOutbounds lists the outbounds to write for nodes, adding the
+// shadowtls outbound after every node wrapped in one.
+func expandOutbounds(nodes []ServerOutbound) []any {
+	outbounds := make([]any, 0, len(nodes))
+	for _, ob := range nodes {
+		outbounds = append(outbounds, ob)
+		if ob.ShadowTLS != nil {
+			outbounds = append(outbounds, ob.shadowTLSOutbound())
+		}
+	}
+	return outbounds
+}
+
+// parseShadowTLSParam reads the shadow-tls parameter of a trojan or ss
+// link, base64 encoded JSON as written by Shadowrocket and others:
+//
+//	{"version":"3","password":"secret","host":"cloud.tencent.com"}
+func parseShadowTLSParam(v string) (*ShadowTLSOptions, error) {
+	decoded, err := decodeBase64Loose(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shadow-tls parameter: %v", err)
+	}
+
+	var raw struct {
+		Version  json.Number `json:"version"`
+		Password string      `json:"password"`
+		Host     string      `json:"host"`
+	}
+	if err := json.Unmarshal([]byte(decoded), &raw); err != nil {
+		return nil, fmt.Errorf("invalid shadow-tls parameter: %v", err)
+	}
+
+	return newShadowTLS(raw.Version.String(), raw.Password, raw.Host)
+}
+
+// parseShadowTLSPlugin reads the options of the shadow-tls SIP003 plugin,
+// "host=cloud.tencent.com;password=secret;version=3".
+func parseShadowTLSPlugin(opts string) (*ShadowTLSOptions, error) {
+	values := make(map[string]string)
+	for _, opt := range strings.Split(opts, ";") {
+		key, value, _ := strings.Cut(opt, "=")
+		values[key] = value
+	}
+	return newShadowTLS(values["version"], values["password"], values["host"])
+}
+
+func newShadowTLS(version, password, host string) (*ShadowTLSOptions, error) {
+	v, err := strconv.Atoi(version)
+	if version == "" {
+		v, err = 3, nil
+	}
+	if err != nil || v < 1 || v > 3 {
+		return nil, fmt.Errorf("unsupported shadow-tls version %q", version)
+	}
+	if host == "" {
+		return nil, fmt.Errorf("missing shadow-tls handshake host")
+	}
+	if password == "" && v > 1 {
+		return nil, fmt.Errorf("missing shadow-tls password")
+	}
+	return &ShadowTLSOptions{Version: v, Password: password, Host: host}, nil
+}
+
+// setShadowTLSParam is the inverse of parseShadowTLSParam.
+func setShadowTLSParam(q url.Values, opts *ShadowTLSOptions) {
+	if opts == nil {
+		return
+	}
+	data, _ := json.Marshal(map[string]string{
+		"version":  strconv.Itoa(opts.Version),
+		"password": opts.Password,
+		"host":     opts.Host,
+	})
+	q.Set("shadow-tls", base64.StdEncoding.EncodeToString(data))
+}
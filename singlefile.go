@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type singleFileMode string
+
+const (
+	singleFileOff       singleFileMode = ""
+	singleFileOutbounds singleFileMode = "outbounds"
+	singleFileConfig    singleFileMode = "config"
+)
+
+func (m *singleFileMode) String() string {
+	return string(*m)
+}
+
+func (m *singleFileMode) Set(v string) error {
+	switch v {
+	case "true", "outbounds":
+		*m = singleFileOutbounds
+	case "config":
+		*m = singleFileConfig
+	case "false":
+		*m = singleFileOff
+	default:
+		return fmt.Errorf("unknown single-file mode %q, expected outbounds or config", v)
+	}
+	return nil
+}
+
+// IsBoolFlag lets --single-file be given without a value.
+func (m *singleFileMode) IsBoolFlag() bool {
+	return true
+}
+
+// exportMerged merges every exportable file in srcDir into a single
+// config.json in dstDir. Objects are merged key by key and arrays are
+// concatenated, which matches how sing-box combines files passed with -C.
+func exportMerged(ctx context.Context, srcDir, dstDir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[string]any)
+	var errs ValidationErrors
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".scheme.json") {
+			continue
+		}
+
+		path := filepath.Join(srcDir, name)
+
+		var data []byte
+		if isTemplate(name) {
+			data, err = readTemplate(path)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			if verrs, ok := err.(ValidationErrors); ok {
+				errs = append(errs, verrs...)
+				continue
+			}
+			return err
+		}
+
+		doc, err := decodeDocument(path, data)
+		if err != nil {
+			if verrs, ok := err.(ValidationErrors); ok {
+				errs = append(errs, verrs...)
+				continue
+			}
+			return err
+		}
+
+		obj, ok := doc.(map[string]any)
+		if !ok {
+			errs.add(path, "", nil, "expected object, got %s", jsonTypeName(doc))
+			continue
+		}
+
+		mergeObjects(path, "", merged, obj, &errs)
+	}
+
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return err
+	}
+
+	dstPath := filepath.Join(dstDir, "config.json")
+	if err := writeJSON(dstPath, merged); err != nil {
+		return err
+	}
+
+	log.Printf("exported merged %s -> %s", srcDir, dstPath)
+
+	// sing-box -C would load the files of an earlier split export next to
+	// config.json and fail on the duplicate tags
+	return pruneGenerated(dstDir, map[string]struct{}{"config.json": {}})
+}
+
+func mergeObjects(file, pointer string, dst, src map[string]any, errs *ValidationErrors) {
+	for key, value := range src {
+		existing, exists := dst[key]
+		if !exists {
+			dst[key] = value
+			continue
+		}
+
+		p := pointerJoin(pointer, key)
+
+		switch v := value.(type) {
+		case map[string]any:
+			obj, ok := existing.(map[string]any)
+			if !ok {
+				errs.add(file, p, value, "cannot merge object into %s", jsonTypeName(existing))
+				continue
+			}
+			mergeObjects(file, p, obj, v, errs)
+		case []any:
+			arr, ok := existing.([]any)
+			if !ok {
+				errs.add(file, p, value, "cannot merge array into %s", jsonTypeName(existing))
+				continue
+			}
+			dst[key] = append(arr, v...)
+		default:
+			dst[key] = value
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestExportMergedPrunesSplitExport(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	writeTestFiles(t, src, map[string]string{
+		"dns.json":       `{"dns": {"servers": [{"tag": "local"}]}}`,
+		"outbounds.json": `{"outbounds": [{"type": "direct", "tag": "direct"}]}`,
+	})
+	// maintained by hand next to what msbc writes
+	writeTestFiles(t, dst, map[string]string{
+		"servers-manual.json": `{"outbounds": []}`,
+		"notes.txt":           "",
+	})
+
+	// an earlier export without --single-file
+	if err := exportConfig(t.Context(), src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if err := exportMerged(t.Context(), src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if names, want := dirNames(t, dst), []string{generatedManifest, "config.json", "notes.txt", "servers-manual.json"}; !slices.Equal(names, want) {
+		t.Errorf("export dir holds %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// sip008Document is a SIP008 online configuration, the JSON subscription
+// format of Shadowsocks.
+type sip008Document struct {
+	Version int            `json:"version"`
+	Servers []sip008Server `json:"servers"`
+}
+
+type sip008Server struct {
+	ID         string `json:"id"`
+	Remarks    string `json:"remarks"`
+	Server     string `json:"server"`
+	ServerPort int    `json:"server_port"`
+	Password   string `json:"password"`
+	Method     string `json:"method"`
+	Plugin     string `json:"plugin"`
+	PluginOpts string `json:"plugin_opts"`
+}
+
+// isSIP008 reports whether body is a JSON document with a servers list.
+func isSIP008(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{' {
+		return false
+	}
+	var probe struct {
+		Servers json.RawMessage `json:"servers"`
+	}
+	return json.Unmarshal(body, &probe) == nil && len(probe.Servers) > 0
+}
+
+// decodeSIP008 turns the servers of a SIP008 document into ss links, which
+// carry the plugin and its options the way SIP002 does.
+func decodeSIP008(body []byte) (lines, skipped []string, err error) {
+	var doc sip008Document
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid SIP008 document: %v", err)
+	}
+	if doc.Version != 1 {
+		return nil, nil, fmt.Errorf("unsupported SIP008 version %d", doc.Version)
+	}
+
+	for i, s := range doc.Servers {
+		if s.Server == "" || s.ServerPort == 0 {
+			skipped = append(skipped, fmt.Sprintf("server %d: missing server or server_port", i))
+			continue
+		}
+
+		ob := &ServerOutbound{
+			BaseOutbound: BaseOutbound{Type: "shadowsocks", Tag: s.Remarks},
+			Server:       s.Server,
+			ServerPort:   s.ServerPort,
+			Method:       s.Method,
+			Password:     s.Password,
+			Plugin:       s.Plugin,
+			PluginOpts:   s.PluginOpts,
+		}
+		// the id is a uuid, only good as a last resort for a name
+		if ob.Tag == "" {
+			ob.Tag = net.JoinHostPort(s.Server, strconv.Itoa(s.ServerPort))
+		}
+		lines = append(lines, shadowsocksLink(ob))
+	}
+	return lines, skipped, nil
+}
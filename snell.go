@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// unrepresentable lists node types msbc can read but sing-box has no
+// outbound for, with the reason. Such nodes are kept through parsing and
+// deduplication and rejected by the filter stage, so they show up in the
+// report instead of disappearing with a log line or failing their source.
+var unrepresentable = map[string]string{
+	"snell":   "snell is a Surge protocol without a sing-box outbound",
+	"mieru":   "sing-box has no mieru outbound",
+	"juicity": "sing-box has no juicity outbound",
+	"brook":   "sing-box has no brook outbound",
+}
+
+// parseSnellURL reads a Snell link as written by the tools that export
+// Surge nodes:
+//
+//	snell://psk@host:port?version=4&obfs=http&obfs-host=example.com#tag
+func parseSnellURL(u *url.URL) (*ServerOutbound, error) {
+	host, port, err := linkEndpoint(u)
+	if err != nil {
+		return nil, err
+	}
+
+	ob := &ServerOutbound{
+		BaseOutbound: BaseOutbound{Type: "snell"},
+		Server:       host,
+		ServerPort:   port,
+	}
+	if u.User != nil {
+		ob.Password = u.User.Username()
+	}
+
+	q := u.Query()
+	if v := q.Get("version"); v != "" {
+		if _, err := strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid version %q for %s", v, host)
+		}
+		ob.Version = v
+	}
+
+	return ob, nil
+}
+
+// snellLink is the inverse of parseSnellURL.
+func snellLink(ob *ServerOutbound) string {
+	q := url.Values{}
+	if ob.Version != "" {
+		q.Set("version", ob.Version)
+	}
+
+	u := url.URL{
+		Scheme:   "snell",
+		User:     url.User(ob.Password),
+		Host:     net.JoinHostPort(ob.Server, strconv.Itoa(ob.ServerPort)),
+		RawQuery: q.Encode(),
+		Fragment: ob.Tag,
+	}
+	return u.String()
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestParseSnellURL(t *testing.T) {
+	runLinkTests(t, []linkTest{
+		{
+			name: "full",
+			link: "snell://psk@snell.example.com:443?version=3#SN",
+			want: `{"type": "snell", "tag": "SN", "server": "snell.example.com", "server_port": 443, "password": "psk", "version": "3"}`,
+		},
+		{
+			name: "no psk",
+			link: "snell://snell.example.com:443#SN",
+			want: `{"type": "snell", "tag": "SN", "server": "snell.example.com", "server_port": 443}`,
+		},
+		{name: "bad version", link: "snell://psk@snell.example.com:443?version=v3"},
+		{name: "no port", link: "snell://psk@snell.example.com"},
+	})
+}
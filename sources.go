@@ -0,0 +1,436 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// sourcePayload is the raw body obtained for one source along with the
+// metadata the provider supplied with it.
+type sourcePayload struct {
+	Source SourceConfig
+	Body   []byte
+	Meta   SourceMeta
+	// NotModified is set when the provider confirmed the cached body.
+	NotModified bool
+}
+
+// configuredSources returns the sources from msbc.json, falling back to a
+// single source read from $SERVER_LIST_URL.
+func configuredSources(cfg *Config) ([]SourceConfig, error) {
+	if len(cfg.Sources) > 0 {
+		sources := make([]SourceConfig, 0, len(cfg.Sources))
+		for _, src := range cfg.Sources {
+			if !src.enabled() {
+				log.Printf("source %s is disabled", src.Name)
+				continue
+			}
+			sources = append(sources, src)
+		}
+
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("all sources are disabled")
+		}
+		return sources, nil
+	}
+
+	srvListURL, err := subscriptionURL()
+	if err != nil {
+		return nil, err
+	}
+	return []SourceConfig{{Name: "default", URL: srvListURL}}, nil
+}
+
+// loadSources fetches every source and refreshes its cache. A failing source
+// is skipped and counted towards its circuit breaker; the run only fails
+// when no source produced anything. In offline mode the caches are used
+// instead and a missing cache is an error.
+func loadSources(ctx context.Context, cfg *Config, stateDir string, opts *fetchOptions) ([]sourcePayload, error) {
+	sources, err := configuredSources(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if offlineMode {
+		log.Printf("offline mode, using cached subscriptions")
+		return loadCachedSources(sources, stateDir, true)
+	}
+
+	payloads, failures, err := fetchSources(ctx, cfg, sources, stateDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	payloads = append(payloads, loadStaleSources(sources, payloads, stateDir, time.Duration(cfg.Cache.MaxAge))...)
+
+	if len(payloads) == 0 {
+		return nil, errors.Join(failures...)
+	}
+	return payloads, nil
+}
+
+// fetchSources fetches the given sources, honouring and updating their
+// circuit breakers. Sources that fail or are skipped are returned as
+// failures; err is only set when the run as a whole cannot continue.
+func fetchSources(ctx context.Context, cfg *Config, sources []SourceConfig, stateDir string, opts *fetchOptions) ([]sourcePayload, []error, error) {
+	state, err := loadBreakers(stateDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := newFetchClient(&cfg.Fetch, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var payloads []sourcePayload
+	var failures []error
+
+	bar := startProgress("fetching", len(sources))
+	defer bar.finish()
+
+	// the fetches run concurrently, everything that touches the breakers or
+	// decides the outcome runs in source order once they are done
+	type fetchResult struct {
+		allowed bool
+		cached  SourceMeta
+		body    []byte
+		header  http.Header
+		err     error
+	}
+	results := make([]fetchResult, len(sources))
+	workers := make(chan struct{}, cfg.Fetch.Concurrency)
+	var wg sync.WaitGroup
+
+	for i, src := range sources {
+		if !state.allow(src.Name, cfg.CircuitBreaker, time.Now()) {
+			bar.add(1)
+			continue
+		}
+
+		wg.Go(func() {
+			workers <- struct{}{}
+			defer func() { <-workers }()
+			defer bar.add(1)
+
+			r := fetchResult{allowed: true, cached: loadSourceMeta(stateDir, src.Name)}
+			req := src
+			req.Headers = conditionalHeaders(stateDir, src.Name, sourceHeaders(cfg, src), r.cached)
+			r.body, r.header, r.err = fetchMirrors(ctx, client, &cfg.Retry, req, opts)
+			results[i] = r
+		})
+	}
+	wg.Wait()
+
+	for i, src := range sources {
+		r := results[i]
+		if !r.allowed {
+			failures = append(failures, fmt.Errorf("source %s: circuit open", src.Name))
+			continue
+		}
+		now := time.Now()
+		body, header, err, cached := r.body, r.header, r.err, r.cached
+
+		if errors.Is(err, errNotModified) {
+			state.success(src.Name)
+
+			body, err := loadSourceCache(stateDir, src.Name)
+			if err != nil {
+				return nil, nil, err
+			}
+			// the provider vouched for the cache, which keeps it from aging
+			now := time.Now()
+			os.Chtimes(sourceCachePath(stateDir, src.Name), now, now)
+
+			// a 304 may still carry fresh plan status
+			meta := metaFromHeader(header).merge(cached)
+			if err := saveSourceMeta(stateDir, src.Name, meta); err != nil {
+				return nil, nil, err
+			}
+			payloads = append(payloads, sourcePayload{Source: src, Body: body, Meta: meta, NotModified: true})
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+
+			log.Printf("source %s failed: %v", src.Name, err)
+			state.failure(src.Name, err, cfg.CircuitBreaker, now)
+			failures = append(failures, fmt.Errorf("source %s: %w", src.Name, err))
+			continue
+		}
+
+		state.success(src.Name)
+
+		meta := metaFromHeader(header)
+		if lines, err := decodeLines(body, src.Format); err == nil {
+			meta = meta.merge(metaFromLines(lines))
+		}
+		if meta.UpdateInterval > 0 {
+			log.Printf("source %s: provider suggests refreshing every %s", src.Name, time.Duration(meta.UpdateInterval))
+		}
+
+		if err := saveSourceCache(stateDir, src.Name, body); err != nil {
+			return nil, nil, err
+		}
+		if err := saveSourceMeta(stateDir, src.Name, meta); err != nil {
+			return nil, nil, err
+		}
+		payloads = append(payloads, sourcePayload{Source: src, Body: body, Meta: meta})
+	}
+
+	if err := state.save(stateDir); err != nil {
+		return nil, nil, err
+	}
+	return payloads, failures, nil
+}
+
+// fetchMirrors fetches the url of a source and then its mirrors in order,
+// until one answers with a body that decodes into nodes. The last one is
+// taken as is, like the url of a source without mirrors.
+func fetchMirrors(ctx context.Context, client *http.Client, cfg *RetryConfig, src SourceConfig, opts *fetchOptions) ([]byte, http.Header, error) {
+	urls := append([]string{src.URL}, src.Mirrors...)
+
+	var errs []error
+	for i, u := range urls {
+		req := src
+		req.URL = u
+
+		body, header, err := fetchWithRetry(ctx, client, cfg, req, opts)
+		if err == nil && i < len(urls)-1 {
+			if lines, decodeErr := decodeLines(body, src.Format); decodeErr != nil {
+				err = decodeErr
+			} else if len(lines) == 0 {
+				err = errors.New("empty subscription")
+			}
+		}
+		if err == nil || errors.Is(err, errNotModified) || ctx.Err() != nil {
+			if err == nil && i > 0 {
+				log.Printf("source %s: using mirror %s", src.Name, redactURL(u))
+			}
+			return body, header, err
+		}
+
+		if len(src.Mirrors) > 0 {
+			log.Printf("source %s: %s failed: %v", src.Name, redactURL(u), err)
+		}
+		errs = append(errs, err)
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// sourceHeaders returns the headers a source is requested with: its own,
+// plus the User-Agent of the source or else the global one and the bearer
+// token, unless the headers already set them.
+func sourceHeaders(cfg *Config, src SourceConfig) map[string]string {
+	headers := make(map[string]string, len(src.Headers)+2)
+	if ua := cmp.Or(src.UserAgent, cfg.UserAgent); ua != "" {
+		headers["User-Agent"] = ua
+	}
+	if src.BearerToken != "" {
+		headers["Authorization"] = "Bearer " + src.BearerToken
+	}
+	for name, value := range src.Headers {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+	return headers
+}
+
+// conditionalHeaders adds the validators of the cached body to the headers
+// of a source, so that a provider can answer with 304 when nothing changed.
+// Without a cached body there is nothing to validate.
+func conditionalHeaders(stateDir, name string, headers map[string]string, meta SourceMeta) map[string]string {
+	if meta.ETag == "" && meta.LastModified == "" {
+		return headers
+	}
+	if _, err := os.Stat(sourceCachePath(stateDir, name)); err != nil {
+		return headers
+	}
+
+	headers = maps.Clone(headers)
+	if headers == nil {
+		headers = make(map[string]string, 2)
+	}
+	if meta.ETag != "" {
+		headers["If-None-Match"] = meta.ETag
+	}
+	if meta.LastModified != "" {
+		headers["If-Modified-Since"] = meta.LastModified
+	}
+	return headers
+}
+
+// builtSourcesPath records the hash of every source body that went into the
+// last successful build.
+func builtSourcesPath(stateDir string) string {
+	return filepath.Join(stateDir, "sources", "built.json")
+}
+
+func sourceHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func saveBuiltSources(stateDir string, payloads []sourcePayload) error {
+	hashes := make(map[string]string, len(payloads))
+	for _, p := range payloads {
+		hashes[p.Source.Name] = sourceHash(p.Body)
+	}
+	return writeJSON(builtSourcesPath(stateDir), hashes)
+}
+
+// unchangedSinceBuild reports whether every source was answered with 304
+// and its cached body is the one the last successful build used, so
+// building again would produce the same configs.
+func unchangedSinceBuild(stateDir string, payloads []sourcePayload) bool {
+	data, err := os.ReadFile(builtSourcesPath(stateDir))
+	if err != nil || len(payloads) == 0 {
+		return false
+	}
+	var hashes map[string]string
+	if err := json.Unmarshal(data, &hashes); err != nil {
+		return false
+	}
+
+	for _, p := range payloads {
+		if !p.NotModified || hashes[p.Source.Name] != sourceHash(p.Body) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadStaleSources falls back to the cached body of every source that could
+// not be fetched, as long as the cache is younger than maxAge, so that a
+// provider being down does not take its nodes out of the build.
+func loadStaleSources(sources []SourceConfig, fetched []sourcePayload, stateDir string, maxAge time.Duration) []sourcePayload {
+	if maxAge <= 0 {
+		return nil
+	}
+
+	var stale []sourcePayload
+	for _, src := range sources {
+		if slices.ContainsFunc(fetched, func(p sourcePayload) bool { return p.Source.Name == src.Name }) {
+			continue
+		}
+
+		age, err := sourceCacheAge(stateDir, src.Name)
+		if err != nil {
+			continue
+		}
+		if age > maxAge {
+			log.Printf("source %s: cached subscription is %s old, too old to fall back to", src.Name, age.Round(time.Minute))
+			continue
+		}
+
+		body, err := loadSourceCache(stateDir, src.Name)
+		if err != nil {
+			log.Print(err)
+			continue
+		}
+		log.Printf("source %s: falling back to the cached subscription from %s ago", src.Name, age.Round(time.Minute))
+		stale = append(stale, sourcePayload{Source: src, Body: body, Meta: loadSourceMeta(stateDir, src.Name)})
+	}
+	return stale
+}
+
+// sourceCacheAge returns how long ago the named source was last fetched
+// successfully. A 304 response touches the cache, so it counts as a fetch.
+func sourceCacheAge(stateDir, name string) (time.Duration, error) {
+	info, err := os.Stat(sourceCachePath(stateDir, name))
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(info.ModTime()), nil
+}
+
+// loadCachedSources reads the payloads saved by the last successful fetch
+// of every source. Unless requireAll is set, sources that were never
+// fetched successfully are skipped.
+func loadCachedSources(sources []SourceConfig, stateDir string, requireAll bool) ([]sourcePayload, error) {
+	payloads := make([]sourcePayload, 0, len(sources))
+
+	for _, src := range sources {
+		// local files need no network, the current copy beats the cache
+		var body []byte
+		var err error
+		if path, ok := localSourcePath(src.URL); ok {
+			body, err = readLocalSource(path)
+		} else {
+			body, err = loadSourceCache(stateDir, src.Name)
+		}
+		if err != nil {
+			if requireAll {
+				return nil, err
+			}
+			log.Print(err)
+			continue
+		}
+		payloads = append(payloads, sourcePayload{Source: src, Body: body, Meta: loadSourceMeta(stateDir, src.Name)})
+	}
+	return payloads, nil
+}
+
+// parseSources decodes and parses every payload, tags nodes with the source
+// they came from and deduplicates across sources by priority.
+func parseSources(ctx context.Context, payloads []sourcePayload, sc *SubconverterConfig) ([]ServerOutbound, error) {
+	var all []ServerOutbound
+	priorities := make(map[string]int, len(payloads))
+
+	bar := startProgress("parsing", len(payloads))
+	defer bar.finish()
+
+	for _, p := range payloads {
+		bar.add(1)
+		lines, err := decodeSubscription(p.Body, p.Source.Format)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %v", p.Source.Name, err)
+		}
+
+		priorities[p.Source.Name] = p.Source.Priority
+		for _, note := range p.Meta.Notes {
+			log.Printf("source %s: %s", p.Source.Name, note)
+		}
+
+		lines, foreign := splitForeign(lines, sc)
+		outbounds := parseLines(lines)
+		native := len(outbounds)
+
+		if len(foreign) > 0 {
+			converted, err := convertExternal(ctx, sc, foreign)
+			if err != nil {
+				// the native nodes are still usable
+				log.Printf("warning: source %s: skipping %d links the subconverter could not convert: %v", p.Source.Name, len(foreign), err)
+			} else {
+				setLinkPacketEncodings(converted, linkPacketEncodings(foreign))
+			}
+			outbounds = append(outbounds, converted...)
+		}
+
+		for i, ob := range outbounds {
+			ob.Source = p.Source.Name
+			ob.Tag = p.Source.TagPrefix + ob.Tag
+			if i < native {
+				buildTrace.note(&ob, "parsed from source %s as %q", ob.Source, ob.Tag)
+			} else {
+				buildTrace.note(&ob, "converted by the subconverter from source %s as %q", ob.Source, ob.Tag)
+			}
+			all = append(all, ob)
+		}
+	}
+
+	return dedupOutbounds(all, priorities), nil
+}
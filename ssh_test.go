@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestParseSSHURL(t *testing.T) {
+	runLinkTests(t, []linkTest{
+		{
+			name: "password",
+			link: "ssh://root:pw@ssh.example.com?host_key=ssh-ed25519%20AAAA&host_key_algorithms=ssh-ed25519,rsa-sha2-256&client_version=SSH-2.0-OpenSSH_9.6#SSH",
+			want: `{"type": "ssh", "tag": "SSH", "server": "ssh.example.com", "server_port": 22, "user": "root", "password": "pw",
+				"host_key": ["ssh-ed25519 AAAA"], "host_key_algorithms": ["ssh-ed25519", "rsa-sha2-256"], "client_version": "SSH-2.0-OpenSSH_9.6"}`,
+		},
+		{
+			name: "private key",
+			link: "ssh://root@ssh.example.com:2222?private_key_path=%2Froot%2F.ssh%2Fid_ed25519&private_key_passphrase=pp#SSH",
+			want: `{"type": "ssh", "tag": "SSH", "server": "ssh.example.com", "server_port": 2222, "user": "root",
+				"private_key_path": "/root/.ssh/id_ed25519", "private_key_passphrase": "pp"}`,
+		},
+		{
+			name: "no user",
+			link: "ssh://ssh.example.com#SSH",
+			want: `{"type": "ssh", "tag": "SSH", "server": "ssh.example.com", "server_port": 22}`,
+		},
+		{name: "bad port", link: "ssh://root@ssh.example.com:ssh"},
+	})
+}
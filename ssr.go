@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ssrProtocols and ssrObfs are what sing-box's shadowsocksr outbound
+// implements. Other plugins from the SSR forks have no equivalent.
+var (
+	ssrProtocols = []string{"origin", "verify_sha1", "auth_sha1_v4", "auth_aes128_md5", "auth_aes128_sha1", "auth_chain_a", "auth_chain_b"}
+	ssrObfs      = []string{"plain", "http_simple", "http_post", "random_head", "tls1.2_ticket_auth"}
+)
+
+// parseShadowsocksRURL reads an SSR link, which encodes everything in
+// base64, parameters included:
+//
+//	ssr://base64(host:port:protocol:method:obfs:base64(password)/?obfsparam=base64&protoparam=base64&remarks=base64)
+//
+// The tag comes from remarks.
+func parseShadowsocksRURL(u *url.URL) (*ServerOutbound, error) {
+	encoded := u.Host + u.Path
+	if u.Opaque != "" {
+		encoded = u.Opaque
+	}
+	if u.RawQuery != "" {
+		// only an unencoded parameter part can end up in the query
+		encoded += "?" + u.RawQuery
+	}
+
+	decoded, err := decodeBase64Loose(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssr link: %v", err)
+	}
+
+	head, rawParams, _ := strings.Cut(decoded, "/?")
+	head = strings.TrimSuffix(head, "/")
+
+	// the host may be an IPv6 address, so the fields are taken from the end
+	fields := strings.Split(head, ":")
+	if len(fields) < 6 {
+		return nil, errors.New("invalid ssr link: expected host:port:protocol:method:obfs:password")
+	}
+	n := len(fields)
+	host := strings.Trim(strings.Join(fields[:n-5], ":"), "[]")
+	protocol, method, obfs := fields[n-4], strings.ToLower(fields[n-3]), fields[n-2]
+
+	port, err := strconv.Atoi(fields[n-5])
+	if err != nil {
+		return nil, fmt.Errorf("invalid port for %s: %v", host, err)
+	}
+
+	password, err := decodeBase64Loose(fields[n-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid password for %s: %v", host, err)
+	}
+
+	params, err := url.ParseQuery(rawParams)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parameters for %s: %v", host, err)
+	}
+	param := func(name string) string {
+		v, err := decodeBase64Loose(params.Get(name))
+		if err != nil {
+			return ""
+		}
+		return v
+	}
+
+	// the forks append "_compatible" to plugins that fall back to the plain
+	// variant, which is what sing-box does anyway
+	protocol = strings.TrimSuffix(protocol, "_compatible")
+	obfs = strings.TrimSuffix(obfs, "_compatible")
+
+	if !slices.Contains(shadowsocksMethods, method) {
+		return nil, fmt.Errorf("unsupported method %q for %s", method, host)
+	}
+	if !slices.Contains(ssrProtocols, protocol) {
+		return nil, fmt.Errorf("protocol %q of %s cannot be represented in sing-box", protocol, host)
+	}
+	if !slices.Contains(ssrObfs, obfs) {
+		return nil, fmt.Errorf("obfs %q of %s cannot be represented in sing-box", obfs, host)
+	}
+
+	return &ServerOutbound{
+		BaseOutbound:  BaseOutbound{Type: "shadowsocksr", Tag: strings.TrimSpace(removeEmoji(param("remarks")))},
+		Server:        host,
+		ServerPort:    port,
+		Password:      password,
+		Method:        method,
+		Protocol:      protocol,
+		ProtocolParam: param("protoparam"),
+		Obfs:          ObfsOptions{Value: obfs},
+		ObfsParam:     param("obfsparam"),
+	}, nil
+}
+
+// shadowsocksRLink is the inverse of parseShadowsocksRURL.
+func shadowsocksRLink(ob *ServerOutbound) string {
+	enc := base64.RawURLEncoding.EncodeToString
+
+	// SSR clients expect IPv6 addresses without brackets
+	head := strings.Join([]string{
+		ob.Server, strconv.Itoa(ob.ServerPort),
+		ob.Protocol, ob.Method, ob.Obfs.Value, enc([]byte(ob.Password)),
+	}, ":")
+
+	params := []string{
+		"obfsparam=" + enc([]byte(ob.ObfsParam)),
+		"protoparam=" + enc([]byte(ob.ProtocolParam)),
+		"remarks=" + enc([]byte(ob.Tag)),
+	}
+
+	return "ssr://" + enc([]byte(head+"/?"+strings.Join(params, "&")))
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The staging directory lets an unprivileged build hand its output to a
+// small privileged step. A build exports into a fresh directory inside it and
+// renames that to "pending" once complete; "msbc install" claims pending by
+// renaming it, copies it to the export dir and reloads sing-box. Renames
+// keep either side from ever seeing a half-written set of files.
+const (
+	stagePending    = "pending"
+	stageInstalling = "installing"
+)
+
+// beginStage creates the directory a build exports into.
+func beginStage(stageDir string) (string, error) {
+	if err := os.MkdirAll(stageDir, 0755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(stageDir, ".staging-*")
+}
+
+// commitStage publishes a finished export as the pending one, replacing a
+// previous export that was not installed yet.
+func commitStage(stageDir, dir string) error {
+	if err := os.Chmod(dir, 0755); err != nil {
+		return err
+	}
+
+	pending := filepath.Join(stageDir, stagePending)
+	if err := os.RemoveAll(pending); err != nil {
+		return err
+	}
+	if err := os.Rename(dir, pending); err != nil {
+		return err
+	}
+
+	log.Printf("staged configs in %s", pending)
+	return nil
+}
+
+type installOptions struct {
+	StageDir  string
+	ExportDir string
+	Reload    reloadOptions
+}
+
+func installCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	opts := &installOptions{}
+
+	fs.StringVar(&opts.StageDir, "stage-dir", "", "staging directory written by build -stage-dir (required)")
+	fs.StringVar(&opts.ExportDir, "export-dir", cmp.Or(openwrtExportDir(), defaultExportDir), "directory the staged configs are installed to")
+	registerReloadFlags(fs, &opts.Reload)
+
+	return func(ctx context.Context) error {
+		return install(ctx, opts)
+	}
+}
+
+// install moves the pending export into place. It never touches the
+// network beyond the reload and only accepts plain files, so it is the only
+// part that needs to run privileged.
+func install(ctx context.Context, opts *installOptions) error {
+	if opts.StageDir == "" {
+		return errors.New("-stage-dir is required")
+	}
+
+	pending := filepath.Join(opts.StageDir, stagePending)
+	claimed := filepath.Join(opts.StageDir, stageInstalling)
+
+	// an install that was interrupted is retried before a newer export
+	if _, err := os.Stat(claimed); err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Rename(pending, claimed); err != nil {
+			if os.IsNotExist(err) {
+				log.Printf("nothing staged in %s", opts.StageDir)
+				return nil
+			}
+			return err
+		}
+	}
+
+	// the staged files stay writable by the build user, so they are copied
+	// to storage of our own before anything is checked or installed
+	snapshot, err := os.MkdirTemp("", "msbc-install-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(snapshot)
+
+	if err := copyStaged(claimed, snapshot); err != nil {
+		return err
+	}
+
+	if err := exportConfig(ctx, snapshot, opts.ExportDir); err != nil {
+		return fmt.Errorf("failed to install configs: %v", err)
+	}
+
+	if err := reloadSingBox(ctx, &opts.Reload); err != nil {
+		return fmt.Errorf("failed to reload sing-box: %v", err)
+	}
+
+	return os.RemoveAll(claimed)
+}
+
+// copyStaged copies the staged files in dir to dst. It refuses anything but
+// regular files with the extensions a build produces, so a compromised build
+// cannot use the installer to read through symlinks or drop other kinds of
+// files. Every file is checked again once it is open, as the build user can
+// swap it for a symlink or a hard link to a privileged file at any time.
+func copyStaged(dir, dst string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("refusing to install from %s: not a directory", dir)
+	}
+	owner, hasOwner := fileOwner(info)
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	if opened, err := root.Stat("."); err != nil {
+		return err
+	} else if !os.SameFile(info, opened) {
+		return fmt.Errorf("refusing to install from %s: replaced while installing", dir)
+	}
+
+	entries, err := root.FS().(fs.ReadDirFS).ReadDir(".")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		// the export dir keeps a manifest of its own
+		if name == generatedManifest {
+			continue
+		}
+		if !entry.Type().IsRegular() || !stagedName(name) {
+			return fmt.Errorf("refusing to install %s: not a plain config file", filepath.Join(dir, name))
+		}
+
+		if err := copyStagedFile(root, name, owner, hasOwner, filepath.Join(dst, name)); err != nil {
+			return fmt.Errorf("refusing to install %s: %v", filepath.Join(dir, name), err)
+		}
+	}
+	return nil
+}
+
+// copyStagedFile copies one staged file, making sure the file it opened is
+// the regular file it checked and belongs to the owner of the staging
+// directory.
+func copyStagedFile(root *os.Root, name string, owner uint32, hasOwner bool, dst string) error {
+	checked, err := root.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if !checked.Mode().IsRegular() {
+		return errors.New("not a plain config file")
+	}
+
+	in, err := root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	opened, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(checked, opened) {
+		return errors.New("replaced while installing")
+	}
+	if uid, ok := fileOwner(opened); hasOwner && ok && uid != owner {
+		return errors.New("not owned by the owner of the staging directory")
+	}
+
+	return writeAtomic(dst, 0644, func(out io.Writer) error {
+		_, err := io.Copy(out, in)
+		return err
+	})
+}
+
+// checkStaged refuses anything in dir but the regular files stagedName
+// allows. It is for directories msbc wrote itself; staging directories are
+// read with copyStaged.
+func checkStaged(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !stagedName(entry.Name()) {
+			return fmt.Errorf("refusing to install %s: not a plain config file", filepath.Join(dir, entry.Name()))
+		}
+	}
+	return nil
+}
+
+// stagedName reports whether name has an extension a build produces.
+func stagedName(name string) bool {
+	ext := filepath.Ext(name)
+	return !strings.HasPrefix(name, ".") && (ext == ".json" || ext == ".srs") &&
+		!isTemplate(name) && !strings.HasSuffix(name, ".scheme.json")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyStaged(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		setup func(dir string) error
+		ok    bool
+	}{
+		{"plain files", func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, "outbounds.json"), []byte("{}"), 0644)
+		}, true},
+		{"manifest", func(dir string) error {
+			if err := os.WriteFile(filepath.Join(dir, "outbounds.json"), []byte("{}"), 0644); err != nil {
+				return err
+			}
+			return os.WriteFile(filepath.Join(dir, generatedManifest), []byte("outbounds.json\n"), 0644)
+		}, true},
+		{"symlink", func(dir string) error {
+			return os.Symlink(secret, filepath.Join(dir, "outbounds.json"))
+		}, false},
+		{"symlink inside", func(dir string) error {
+			if err := os.WriteFile(filepath.Join(dir, "a.json"), []byte("{}"), 0644); err != nil {
+				return err
+			}
+			return os.Symlink("a.json", filepath.Join(dir, "b.json"))
+		}, false},
+		{"other extension", func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, "run.sh"), nil, 0755)
+		}, false},
+		{"hidden file", func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, ".x.json"), nil, 0644)
+		}, false},
+		{"template", func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, "config"+templateSuffix), nil, 0644)
+		}, false},
+		{"directory", func(dir string) error {
+			return os.Mkdir(filepath.Join(dir, "sub.json"), 0755)
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, dst := t.TempDir(), t.TempDir()
+			if err := tt.setup(dir); err != nil {
+				t.Fatal(err)
+			}
+
+			err := copyStaged(dir, dst)
+			if tt.ok != (err == nil) {
+				t.Fatalf("copyStaged() error = %v, want ok = %v", err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+
+			if _, err := os.Stat(filepath.Join(dst, "outbounds.json")); err != nil {
+				t.Errorf("staged file not copied: %v", err)
+			}
+		})
+	}
+}
+
+func TestCopyStagedSymlinkedDir(t *testing.T) {
+	real := t.TempDir()
+	if err := os.WriteFile(filepath.Join(real, "outbounds.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(t.TempDir(), stageInstalling)
+	if err := os.Symlink(real, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyStaged(link, t.TempDir()); err == nil {
+		t.Fatal("copyStaged() followed a symlinked staging directory")
+	}
+}
+
+func TestInstall(t *testing.T) {
+	stage, export := t.TempDir(), t.TempDir()
+
+	dir, err := beginStage(stage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "outbounds.json"), []byte(`{"outbounds":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitStage(stage, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &installOptions{StageDir: stage, ExportDir: export}
+	if err := install(t.Context(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(export, "outbounds.json")); err != nil {
+		t.Errorf("config not installed: %v", err)
+	}
+	for _, name := range []string{stagePending, stageInstalling} {
+		if _, err := os.Stat(filepath.Join(stage, name)); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SubconverterConfig points at a subconverter instance that converts the
+// share links msbc cannot parse itself. Only those lines are sent to it,
+// so nodes msbc understands never depend on the external service.
+type SubconverterConfig struct {
+	URL string `json:"url,omitempty"`
+}
+
+// nativeSchemes are the share link schemes parseShareLink converts.
+var nativeSchemes = []string{"trojan", "ss", "ssr", "hysteria", "hysteria2", "hy2", "tuic", "wireguard", "wg", "socks", "socks5", "socks5h", "socks4", "socks4a", "http", "https", "naive+https", "naive+quic", "anytls", "snell", "mierus", "mieru", "juicity", "brook", "ssh"}
+
+// isNativeLink reports whether msbc parses line itself.
+func isNativeLink(line string) bool {
+	scheme, _, ok := strings.Cut(line, "://")
+	return !ok || slices.Contains(nativeSchemes, strings.ToLower(scheme))
+}
+
+// splitForeign separates the lines to hand to the subconverter. Without a
+// subconverter every line stays native and unknown schemes are skipped as
+// before.
+func splitForeign(lines []string, sc *SubconverterConfig) (native, foreign []string) {
+	if sc.URL == "" {
+		return lines, nil
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || isMetadataLine(line) || isNativeLink(line) {
+			native = append(native, line)
+			continue
+		}
+		foreign = append(foreign, line)
+	}
+	return native, foreign
+}
+
+// convertExternal asks the subconverter for the sing-box outbounds of
+// links. Fields msbc does not model are kept verbatim in Extra.
+func convertExternal(ctx context.Context, sc *SubconverterConfig, links []string) ([]ServerOutbound, error) {
+	if err := requireNetwork("converting links through subconverter"); err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	q.Set("target", "singbox")
+	q.Set("list", "true")
+	q.Set("url", strings.Join(links, "|"))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(sc.URL, "/")+"/sub?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		// the request url carries the links and with them their credentials
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return nil, uerr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	var doc struct {
+		Outbounds []map[string]json.RawMessage `json:"outbounds"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+
+	outbounds := make([]ServerOutbound, 0, len(doc.Outbounds))
+	for i, fields := range doc.Outbounds {
+		ob, err := externalOutbound(fields)
+		if err != nil {
+			return nil, fmt.Errorf("outbound %d: %v", i, err)
+		}
+		if ob == nil {
+			continue
+		}
+		outbounds = append(outbounds, *ob)
+	}
+
+	log.Printf("subconverter converted %d of %d links", len(outbounds), len(links))
+	return outbounds, nil
+}
+
+// externalOutbound keeps only what msbc needs to sort, filter and group a
+// node; the rest, including tls, is passed through untouched so nothing the
+// subconverter produced is lost.
+func externalOutbound(fields map[string]json.RawMessage) (*ServerOutbound, error) {
+	ob := &ServerOutbound{Extra: make(map[string]json.RawMessage)}
+
+	for key, raw := range fields {
+		var err error
+		switch key {
+		case "type":
+			err = json.Unmarshal(raw, &ob.Type)
+		case "tag":
+			err = json.Unmarshal(raw, &ob.Tag)
+		case "server":
+			err = json.Unmarshal(raw, &ob.Server)
+		case "server_port":
+			err = json.Unmarshal(raw, &ob.ServerPort)
+		default:
+			ob.Extra[key] = raw
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+
+	// groups such as selector or direct carry no server
+	if ob.Server == "" || ob.ServerPort == 0 {
+		return nil, nil
+	}
+
+	credential := string(ob.Extra["uuid"]) + "\x00" + string(ob.Extra["password"]) + "\x00" + string(ob.Extra["method"])
+	ob.ID = nodeID(ob.Type, ob.Server, ob.ServerPort, credential)
+	return ob, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// writeSubscription re-encodes the final node set, after filtering,
+// deduplication and renaming, as a base64 link list that any client
+// accepting standard subscriptions can consume. Nodes without a share link
+// format are left out.
+func writeSubscription(path string, outbounds []ServerOutbound) error {
+	data, err := encodeBase64Links(outbounds)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return err
+	}
+
+	log.Printf("wrote %s", path)
+	return nil
+}
+
+// qrPrefix starts the name of every QR code msbc writes, so that stale ones
+// can be told apart from other images in the directory.
+const qrPrefix = "qr-"
+
+// writeQRCodes writes the share link of every node as qr-<tag>.png into
+// dir, adding the node id when tags clash, and removes images of nodes that
+// are gone.
+func writeQRCodes(dir string, outbounds []ServerOutbound) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+
+	for _, ob := range outbounds {
+		link, err := shareLink(&ob)
+		if err != nil {
+			continue
+		}
+
+		qr, err := encodeQR([]byte(link))
+		if err != nil {
+			log.Printf("no QR code for %q: %v", ob.Tag, err)
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := qr.writePNG(&buf, 8); err != nil {
+			return err
+		}
+
+		name := qrPrefix + safeFileName(ob.Tag) + ".png"
+		if written[name] {
+			name = qrPrefix + safeFileName(ob.Tag) + "-" + ob.ID + ".png"
+		}
+		if err := writeFileAtomic(filepath.Join(dir, name), buf.Bytes(), 0600); err != nil {
+			return err
+		}
+		written[name] = true
+	}
+
+	stale, err := filepath.Glob(filepath.Join(dir, qrPrefix+"*.png"))
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if !written[filepath.Base(path)] {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("wrote %d QR codes to %s", len(written), dir)
+	return nil
+}
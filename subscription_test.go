@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteQRCodes(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"qr-gone.png", "photo.png"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outbounds := []ServerOutbound{{
+		BaseOutbound: BaseOutbound{Type: "trojan", Tag: "JP 01"},
+		Server:       "jp.example.com",
+		ServerPort:   443,
+		Password:     "password",
+		TLS:          &TLSOptions{Enabled: true},
+	}}
+	if err := writeQRCodes(dir, outbounds); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{"qr-jp-01.png": true, "photo.png": true, "qr-gone.png": false} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if got := err == nil; got != want {
+			t.Errorf("%s exists = %v, want %v", name, got, want)
+		}
+	}
+}
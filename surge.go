@@ -0,0 +1,195 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var surgeProxyRe = regexp.MustCompile(`(?m)^[ \t]*[^=#;\[\s][^=\n]*=\s*(ss|trojan|https?|socks5(-tls)?|snell|tuic(-v5)?|hysteria2|vmess|wireguard)\s*,`)
+
+// isSurgeList reports whether body holds Surge proxy lines, either as a
+// managed proxy list or in the [Proxy] section of a profile.
+func isSurgeList(body []byte) bool {
+	return surgeProxyRe.Match(body)
+}
+
+// decodeSurge turns Surge proxy lines such as
+//
+//	HK 01 = trojan, hk.example.com, 443, password=pw, sni=hk.example.com
+//
+// into share links. A profile is only read in its [Proxy] section.
+func decodeSurge(body []byte) (lines, skipped []string, err error) {
+	text := string(body)
+	profile := strings.Contains(text, "[Proxy]")
+	inProxy := !profile
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inProxy = !profile || line == "[Proxy]"
+			continue
+		}
+		if !inProxy || line == "" || line[0] == '#' || line[0] == ';' || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		name, spec, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+
+		link, err := surgeLink(name, spec)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("proxy %q: %v", name, err))
+			continue
+		}
+		if link != "" {
+			lines = append(lines, link)
+		}
+	}
+	return lines, skipped, nil
+}
+
+// surgeLink builds the share link of a Surge proxy line. The built-in
+// policies yield an empty link.
+func surgeLink(name, spec string) (string, error) {
+	fields := strings.Split(spec, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	typ := strings.ToLower(fields[0])
+	switch typ {
+	case "direct", "reject", "reject-tinygif", "reject-drop", "reject-no-drop":
+		return "", nil
+	}
+	if len(fields) < 3 {
+		return "", errors.New("expected type, server and port")
+	}
+
+	port, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid port %q", fields[2])
+	}
+
+	// http and socks5 take the credentials as positional fields
+	var positional []string
+	params := make(map[string]string)
+	for _, field := range fields[3:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			positional = append(positional, strings.Trim(field, `"`))
+			continue
+		}
+		params[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	param := func(key string) string { return params[key] }
+	flag := func(key string) bool { return params[key] == "true" || params[key] == "1" }
+	credential := func(i int, key string) string {
+		if i < len(positional) {
+			return positional[i]
+		}
+		return params[key]
+	}
+
+	ob := &ServerOutbound{
+		BaseOutbound: BaseOutbound{Tag: name},
+		Server:       fields[1],
+		ServerPort:   port,
+		Password:     param("password"),
+	}
+	tls := func() *TLSOptions {
+		return &TLSOptions{
+			Enabled:    true,
+			ServerName: param("sni"),
+			Insecure:   flag("skip-cert-verify"),
+			ALPN:       splitList(param("alpn")),
+		}
+	}
+
+	switch typ {
+	case "ss":
+		ob.Type = "shadowsocks"
+		ob.Method = param("encrypt-method")
+		if obfs := param("obfs"); obfs != "" {
+			ob.Plugin = "obfs-local"
+			ob.PluginOpts = "obfs=" + obfs
+			if host := param("obfs-host"); host != "" {
+				ob.PluginOpts += ";obfs-host=" + host
+			}
+		}
+
+	case "trojan":
+		ob.Type = "trojan"
+		ob.TLS = tls()
+		if flag("ws") {
+			host := surgeHeaders(param("ws-headers"))["Host"]
+			ob.Transport = &TransportOptions{Type: "ws", Path: cmp.Or(param("ws-path"), "/")}
+			if host != "" {
+				ob.Transport.Headers = map[string]string{"Host": host}
+			}
+		}
+
+	case "http", "https":
+		ob.Type = "http"
+		ob.Username, ob.Password = credential(0, "username"), credential(1, "password")
+		if typ == "https" {
+			ob.TLS = tls()
+		}
+
+	case "socks5":
+		ob.Type = "socks"
+		ob.Username, ob.Password = credential(0, "username"), credential(1, "password")
+
+	case "snell":
+		ob.Type = "snell"
+		ob.Password = param("psk")
+		ob.Version = param("version")
+
+	case "tuic-v5":
+		ob.Type = "tuic"
+		ob.UUID = param("uuid")
+		ob.TLS = tls()
+
+	case "hysteria2":
+		ob.Type = "hysteria2"
+		ob.TLS = tls()
+
+	case "tuic":
+		return "", errors.New("TUIC v4 token nodes are not supported, sing-box only speaks v5")
+	case "socks5-tls":
+		return "", errors.New("socks over tls has no sing-box outbound")
+	default:
+		return "", fmt.Errorf("msbc cannot read %s nodes", typ)
+	}
+
+	// Surge can put shadow-tls, by default version 2, in front of any tcp
+	// proxy; msbc wraps trojan and ss nodes only
+	if v := param("shadow-tls-password"); v != "" {
+		if ob.Type != "trojan" && ob.Type != "shadowsocks" {
+			return "", fmt.Errorf("shadow-tls in front of %s is not supported", typ)
+		}
+		if ob.ShadowTLS, err = newShadowTLS(cmp.Or(param("shadow-tls-version"), "2"), v, param("shadow-tls-sni")); err != nil {
+			return "", err
+		}
+	}
+
+	return shareLink(ob)
+}
+
+// surgeHeaders reads a "Host:cdn.example.com|User-Agent:x" header list.
+func surgeHeaders(v string) map[string]string {
+	headers := make(map[string]string)
+	for _, h := range strings.Split(v, "|") {
+		name, value, ok := strings.Cut(h, ":")
+		if ok {
+			headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return headers
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// templateSuffix marks exported files whose ${VAR} references are expanded
+// on export; "misc.template.json" is written out as "misc.json".
+const templateSuffix = ".template.json"
+
+// expandEnv replaces ${VAR}, ${VAR:-default} and ${VAR-default} references in
+// data with values from the environment. ":-" falls back when the variable is
+// unset or empty, "-" only when it is unset, and "$${" produces a literal
+// "${". Substitution is textual so it also works for numbers and booleans,
+// e.g. "listen_port": ${DNS_PORT:-53}. A value from the environment that
+// lands inside a string literal is JSON-escaped, so a password with a quote
+// or backslash stays one string; defaults are template text and kept as
+// written. Every unresolved reference is reported.
+func expandEnv(file string, data []byte) ([]byte, error) {
+	src := string(data)
+	var b strings.Builder
+	var errs ValidationErrors
+	inString := false
+
+	// the line of a reference comes from its offset, since a reference and
+	// its default may span several lines themselves
+	lineAt := func(offset int) int {
+		line, _ := offsetPosition(data, int64(offset))
+		return line
+	}
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '"':
+			inString = !inString
+		case c == '\\' && inString && i+1 < len(src) && src[i+1] != '$':
+			b.WriteString(src[i : i+2])
+			i++
+			continue
+		}
+
+		if c != '$' || i+1 >= len(src) {
+			b.WriteByte(c)
+			continue
+		}
+
+		if strings.HasPrefix(src[i:], "$${") {
+			b.WriteString("${")
+			i += 2
+			continue
+		}
+
+		if src[i+1] != '{' {
+			b.WriteByte(c)
+			continue
+		}
+
+		end := strings.IndexByte(src[i:], '}')
+		if end < 0 {
+			errs.add(file, "", nil, "line %d: unterminated ${", lineAt(i))
+			b.WriteString(src[i:])
+			break
+		}
+
+		expr := src[i+2 : i+end]
+		start := i
+		i += end
+
+		value, fromEnv, err := resolveEnv(expr)
+		if err != nil {
+			errs.add(file, "", nil, "line %d: %v", lineAt(start), err)
+			continue
+		}
+		if fromEnv && inString {
+			value = jsonEscape(value)
+		}
+		b.WriteString(value)
+	}
+
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// resolveEnv returns the value of a reference and whether it came from the
+// environment rather than from its default.
+func resolveEnv(expr string) (string, bool, error) {
+	name, def, hasDefault := expr, "", false
+	emptyIsUnset := false
+
+	if idx := strings.Index(expr, ":-"); idx >= 0 {
+		name, def, hasDefault, emptyIsUnset = expr[:idx], expr[idx+2:], true, true
+	} else if idx := strings.IndexByte(expr, '-'); idx >= 0 {
+		name, def, hasDefault = expr[:idx], expr[idx+1:], true
+	}
+
+	if !validEnvName(name) {
+		return "", false, fmt.Errorf("invalid variable reference ${%s}", expr)
+	}
+
+	value, ok := os.LookupEnv(name)
+	if ok && (value != "" || !emptyIsUnset) {
+		return value, true, nil
+	}
+
+	if hasDefault {
+		return def, false, nil
+	}
+	return "", false, fmt.Errorf("${%s} is not set and has no default", name)
+}
+
+// jsonEscape escapes s for use between the quotes of a JSON string.
+func jsonEscape(s string) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s) // a string always encodes
+	quoted := strings.TrimSuffix(b.String(), "\n")
+	return quoted[1 : len(quoted)-1]
+}
+
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// readTemplate reads a scheme or template file and expands environment
+// references in it.
+func readTemplate(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return expandEnv(path, data)
+}
+
+// exportName maps a source file name to the name it is exported under.
+func exportName(name string) string {
+	if strings.HasSuffix(name, templateSuffix) {
+		return strings.TrimSuffix(name, templateSuffix) + ".json"
+	}
+	return name
+}
+
+// renderTemplate expands srcPath and writes the result to dstPath, refusing
+// to write anything that is not valid JSON after expansion.
+func renderTemplate(srcPath, dstPath string) error {
+	data, err := readTemplate(srcPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err := decodeDocument(srcPath, data); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(dstPath, data, 0644)
+}
+
+func isTemplate(path string) bool {
+	return strings.HasSuffix(filepath.Base(path), templateSuffix)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("MSBC_TEST_PORT", "1080")
+	t.Setenv("MSBC_TEST_EMPTY", "")
+
+	tests := []struct {
+		in, want string
+	}{
+		{`{"port": ${MSBC_TEST_PORT}}`, `{"port": 1080}`},
+		{`${MSBC_TEST_UNSET:-53}`, `53`},
+		{`${MSBC_TEST_EMPTY:-53}`, `53`},
+		{`${MSBC_TEST_EMPTY-53}`, ``},
+		{`${MSBC_TEST_UNSET-53}`, `53`},
+		{`$${MSBC_TEST_PORT}`, `${MSBC_TEST_PORT}`},
+		{`$MSBC_TEST_PORT $ {x}`, `$MSBC_TEST_PORT $ {x}`},
+	}
+
+	for _, tt := range tests {
+		got, err := expandEnv("test.json", []byte(tt.in))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("expandEnv(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpandEnvEscapesStrings(t *testing.T) {
+	t.Setenv("MSBC_TEST_PASSWORD", `p"a\ss`+"\n<&>")
+	t.Setenv("MSBC_TEST_PORT", "1080")
+
+	tests := []struct {
+		name, in, want string
+	}{
+		{"string", `{"password": "${MSBC_TEST_PASSWORD}"}`, `{"password": "p\"a\\ss\n<&>"}`},
+		{"inside text", `{"uri": "ss://${MSBC_TEST_PASSWORD}@host"}`, `{"uri": "ss://p\"a\\ss\n<&>@host"}`},
+		{"after escaped quote", `{"a": "\"${MSBC_TEST_PASSWORD}"}`, `{"a": "\"p\"a\\ss\n<&>"}`},
+		{"after escaped backslash", `{"a": "\\", "port": ${MSBC_TEST_PORT}}`, `{"a": "\\", "port": 1080}`},
+		{"key", `{"${MSBC_TEST_PORT}": ${MSBC_TEST_PORT}}`, `{"1080": 1080}`},
+		{"default kept", `{"a": "${MSBC_TEST_UNSET:-x\"y}"}`, `{"a": "x\"y"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandEnv("test.json", []byte(tt.in))
+			if err != nil || string(got) != tt.want {
+				t.Fatalf("expandEnv(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+			}
+			if _, err := decodeDocument("test.json", got); err != nil {
+				t.Errorf("expanded document is not valid JSON: %v", err)
+			}
+		})
+	}
+}
+
+func TestExpandEnvErrorLines(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     []string
+	}{
+		{
+			"single line references",
+			"{\n\"a\": ${MSBC_TEST_UNSET},\n\"b\": ${1BAD}\n}",
+			[]string{"line 2: ${MSBC_TEST_UNSET} is not set and has no default", "line 3: invalid variable reference ${1BAD}"},
+		},
+		{
+			"default spanning lines",
+			"{\n\"a\": ${MSBC_TEST_UNSET:-one\ntwo\nthree},\n\"b\": ${MSBC_TEST_UNSET}\n}",
+			[]string{"line 5: ${MSBC_TEST_UNSET} is not set and has no default"},
+		},
+		{
+			"escaped reference before",
+			"$${\n}\n${MSBC_TEST_UNSET}",
+			[]string{"line 3: ${MSBC_TEST_UNSET} is not set and has no default"},
+		},
+		{
+			"unterminated",
+			"{\n\n\"a\": ${MSBC_TEST_UNSET",
+			[]string{"line 3: unterminated ${"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := expandEnv("test.json", []byte(tt.in))
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expandEnv() error = %v, want ValidationErrors", err)
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("expandEnv() = %v, want %q", errs, tt.want)
+			}
+			for i, e := range errs {
+				if e.Message != tt.want[i] {
+					t.Errorf("error %d = %q, want %q", i, e.Message, tt.want[i])
+				}
+			}
+		})
+	}
+}
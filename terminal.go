@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// terminal decorates output when stderr is an interactive terminal: log
+// lines are colored by priority and long stages show a progress bar.
+// Redirected output stays plain, and NO_COLOR turns colors off.
+type terminal struct {
+	mu       sync.Mutex
+	out      *os.File
+	color    bool
+	progress *progress
+}
+
+var stderrTerminal = newTerminal(os.Stderr)
+
+func newTerminal(f *os.File) *terminal {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 || os.Getenv("TERM") == "dumb" {
+		return nil
+	}
+	return &terminal{out: f, color: os.Getenv("NO_COLOR") == ""}
+}
+
+const (
+	colorRed    = "31"
+	colorGreen  = "32"
+	colorYellow = "33"
+)
+
+// Write prints one log line, clearing the progress bar first and drawing it
+// again below the line.
+func (t *terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	line := string(p)
+	if t.color {
+		if code := lineColor(line); code != "" {
+			line = "\033[" + code + "m" + strings.TrimSuffix(line, "\n") + "\033[0m\n"
+		}
+	}
+
+	if t.progress != nil {
+		fmt.Fprint(t.out, "\r\033[K")
+	}
+	fmt.Fprint(t.out, line)
+	t.draw()
+
+	return len(p), nil
+}
+
+func lineColor(line string) string {
+	msg := line
+	if log.Flags()&(log.Ldate|log.Ltime) == log.Ldate|log.Ltime {
+		if parts := strings.SplitN(line, " ", 3); len(parts) == 3 {
+			msg = parts[2]
+		}
+	}
+
+	switch logPriority(msg) {
+	case priorityErr:
+		return colorRed
+	case priorityWarning:
+		return colorYellow
+	}
+	if strings.HasPrefix(msg, "built ") || strings.HasPrefix(msg, "all done") {
+		return colorGreen
+	}
+	return ""
+}
+
+// draw renders the progress bar; callers hold t.mu.
+func (t *terminal) draw() {
+	p := t.progress
+	if p == nil {
+		return
+	}
+
+	const width = 30
+	filled := width
+	if p.total > 0 {
+		filled = width * p.done / p.total
+	}
+	fmt.Fprintf(t.out, "\r\033[K%s [%s%s] %d/%d", p.stage, strings.Repeat("#", filled), strings.Repeat(".", width-filled), p.done, p.total)
+}
+
+// progress counts finished items of a stage. A nil progress, as returned
+// when stderr is not a terminal, ignores every call.
+type progress struct {
+	term  *terminal
+	stage string
+	done  int
+	total int
+}
+
+func startProgress(stage string, total int) *progress {
+	t := stderrTerminal
+	if t == nil || total == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.progress = &progress{term: t, stage: stage, total: total}
+	t.draw()
+	return t.progress
+}
+
+func (p *progress) add(n int) {
+	if p == nil {
+		return
+	}
+
+	p.term.mu.Lock()
+	defer p.term.mu.Unlock()
+
+	p.done += n
+	p.term.draw()
+}
+
+// finish removes the bar so the following log lines start on a clean line.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+
+	p.term.mu.Lock()
+	defer p.term.mu.Unlock()
+
+	if p.term.progress == p {
+		p.term.progress = nil
+		fmt.Fprint(p.term.out, "\r\033[K")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// UTLSOptions is sing-box's tls.utls block, which makes the ClientHello
+// look like the one of a browser.
+type UTLSOptions struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// RealityOptions is sing-box's tls.reality block.
+type RealityOptions struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortID   string `json:"short_id,omitempty"`
+}
+
+var utlsFingerprints = []string{"chrome", "firefox", "edge", "safari", "360", "qq", "ios", "android", "random", "randomized"}
+
+// utlsTypes are the outbounds built on sing-box's TCP TLS client, whose
+// options take utls and ECH blocks; the QUIC based ones and naive bring
+// their own TLS stack.
+var utlsTypes = []string{"trojan", "anytls", "http"}
+
+// TLSConfig holds defaults for the tls options of generated outbounds.
+type TLSConfig struct {
+	// Fingerprint is the utls fingerprint of nodes whose link has no fp
+	// parameter.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// ECH is copied into nodes whose link does not enable ECH, for
+	// providers that moved to Encrypted Client Hello without saying so in
+	// their links.
+	ECH *ECHOptions `json:"ech,omitempty"`
+	// ALPN replaces the alpn of the nodes of a type, e.g. {"hysteria2":
+	// ["h3"]}, whatever their links say.
+	ALPN map[string][]string `json:"alpn,omitempty"`
+	// Fragment splits the ClientHello of matching nodes, which gets
+	// connections through some censoring middleboxes; the first rule
+	// whose Match regexp matches the tag applies.
+	Fragment []FragmentRule `json:"fragment,omitempty"`
+}
+
+// FragmentRule sets the fragment options of the nodes whose tag, as the
+// subscription names it, matches Match; an empty Match matches every node.
+type FragmentRule struct {
+	Match          string   `json:"match,omitempty"`
+	Fragment       bool     `json:"fragment,omitempty"`
+	FallbackDelay  Duration `json:"fallback_delay,omitempty"`
+	RecordFragment bool     `json:"record_fragment,omitempty"`
+}
+
+// fragmentRule returns the first rule matching tag. The patterns were
+// checked when the config was loaded.
+func (c *TLSConfig) fragmentRule(tag string) *FragmentRule {
+	for i := range c.Fragment {
+		rule := &c.Fragment[i]
+		if rule.Match == "" {
+			return rule
+		}
+		if re, err := regexp.Compile(rule.Match); err == nil && re.MatchString(tag) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// parseUTLS reads the fp parameter of a share link. Unknown fingerprints
+// are left out rather than failing the node, sing-box would refuse them.
+func parseUTLS(q url.Values) *UTLSOptions {
+	fp := q.Get("fp")
+	if fp == "" || fp == "none" {
+		return nil
+	}
+	if !slices.Contains(utlsFingerprints, fp) {
+		log.Printf("ignoring unknown utls fingerprint %q", fp)
+		return nil
+	}
+	return &UTLSOptions{Enabled: true, Fingerprint: fp}
+}
+
+// setUTLSParam is the inverse of parseUTLS.
+func setUTLSParam(q url.Values, tls *TLSOptions) {
+	if tls.UTLS != nil && tls.UTLS.Enabled {
+		q.Set("fp", tls.UTLS.Fingerprint)
+	}
+}
+
+// parseALPN reads the comma separated alpn parameter of a share link.
+func parseALPN(q url.Values) []string {
+	if v := q.Get("alpn"); v != "" {
+		return splitList(v)
+	}
+	return nil
+}
+
+// setALPNParam is the inverse of parseALPN.
+func setALPNParam(q url.Values, tls *TLSOptions) {
+	if len(tls.ALPN) > 0 {
+		q.Set("alpn", strings.Join(tls.ALPN, ","))
+	}
+}
+
+// parseReality reads the pbk and sid parameters panels put on REALITY
+// nodes. sing-box only speaks REALITY through utls, so a node without a
+// fingerprint gets chrome's.
+func parseReality(q url.Values, tls *TLSOptions) {
+	pbk := q.Get("pbk")
+	if pbk == "" {
+		return
+	}
+
+	tls.Reality = &RealityOptions{Enabled: true, PublicKey: pbk, ShortID: q.Get("sid")}
+	if tls.UTLS == nil {
+		tls.UTLS = &UTLSOptions{Enabled: true, Fingerprint: "chrome"}
+	}
+	// the spider path only matters to Xray's crawler imitation
+	if q.Has("spx") {
+		log.Printf("ignoring spx %q, sing-box has no spider option", q.Get("spx"))
+	}
+}
+
+// setRealityParam is the inverse of parseReality.
+func setRealityParam(q url.Values, tls *TLSOptions) {
+	if tls.Reality == nil || !tls.Reality.Enabled {
+		return
+	}
+	q.Set("security", "reality")
+	q.Set("pbk", tls.Reality.PublicKey)
+	if tls.Reality.ShortID != "" {
+		q.Set("sid", tls.Reality.ShortID)
+	}
+}
+
+// applyTLSDefaults gives the nodes without a fingerprint or ECH settings of
+// their own the configured ones, and sets the configured alpn and fragment
+// options. It runs before downgradeOutbounds, which leaves out what the
+// target release lacks.
+func applyTLSDefaults(c *TLSConfig, outbounds []ServerOutbound) {
+	for i := range outbounds {
+		ob := &outbounds[i]
+		if ob.TLS == nil || !ob.TLS.Enabled {
+			continue
+		}
+
+		if alpn, ok := c.ALPN[ob.Type]; ok {
+			ob.TLS.ALPN = alpn
+		}
+
+		if !slices.Contains(utlsTypes, ob.Type) {
+			continue
+		}
+
+		if ob.TLS.UTLS == nil && c.Fingerprint != "" {
+			ob.TLS.UTLS = &UTLSOptions{Enabled: true, Fingerprint: c.Fingerprint}
+		}
+		if rule := c.fragmentRule(ob.Tag); rule != nil {
+			ob.TLS.Fragment = rule.Fragment
+			ob.TLS.FragmentFallbackDelay = rule.FallbackDelay
+			ob.TLS.RecordFragment = rule.RecordFragment
+		}
+		if ob.TLS.ECH == nil && c.ECH != nil && c.ECH.Enabled {
+			ech := *c.ECH
+			ob.TLS.ECH = &ech
+			buildTrace.note(ob, "ECH from the config")
+		}
+	}
+}
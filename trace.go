@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const traceFile = "trace.json"
+
+// Trace records what every stage of the last build did to each node, so
+// "msbc explain" can answer why a node looks the way it does or is gone.
+type Trace struct {
+	GeneratedAt time.Time    `json:"generated_at"`
+	Nodes       []*NodeTrace `json:"nodes"`
+
+	mu    sync.Mutex
+	index map[string]*NodeTrace
+}
+
+// NodeTrace is the history of one node. Tags lists every name it had,
+// starting with the one from the subscription.
+type NodeTrace struct {
+	ID         string   `json:"id"`
+	Type       string   `json:"type"`
+	Source     string   `json:"source"`
+	Server     string   `json:"server"`
+	ServerPort int      `json:"server_port"`
+	Tags       []string `json:"tags"`
+	Kept       bool     `json:"kept"`
+	Steps      []string `json:"steps"`
+}
+
+// buildTrace collects the trace of the running build. It is nil outside of
+// builds, and every method ignores a nil trace, so the stages can record
+// unconditionally.
+var buildTrace *Trace
+
+func newTrace() *Trace {
+	return &Trace{index: make(map[string]*NodeTrace)}
+}
+
+func (t *Trace) node(ob *ServerOutbound) *NodeTrace {
+	n, ok := t.index[ob.ID]
+	if !ok {
+		n = &NodeTrace{
+			ID:         ob.ID,
+			Type:       ob.Type,
+			Source:     ob.Source,
+			Server:     ob.Server,
+			ServerPort: ob.ServerPort,
+			Tags:       []string{ob.Tag},
+		}
+		t.index[ob.ID] = n
+		t.Nodes = append(t.Nodes, n)
+	}
+	return n
+}
+
+// note adds a step to the history of ob.
+func (t *Trace) note(ob *ServerOutbound, format string, args ...any) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := t.node(ob)
+	n.Steps = append(n.Steps, fmt.Sprintf(format, args...))
+}
+
+// renamed records that ob, now called ob.Tag, was called from before.
+func (t *Trace) renamed(ob *ServerOutbound, from, why string) {
+	if t == nil || from == ob.Tag {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := t.node(ob)
+	n.Tags = append(n.Tags, ob.Tag)
+	n.Steps = append(n.Steps, fmt.Sprintf("renamed %q to %q (%s)", from, ob.Tag, why))
+}
+
+// finish marks the nodes that made it into the configs and records the
+// groups that list them, directly or through a region group.
+func (t *Trace) finish(outbounds []ServerOutbound, groups []GroupOutbound, selectors []any) {
+	if t == nil {
+		return
+	}
+
+	byTag := make(map[string]*ServerOutbound, len(outbounds))
+	for i := range outbounds {
+		ob := &outbounds[i]
+		byTag[ob.Tag] = ob
+
+		t.mu.Lock()
+		t.node(ob).Kept = true
+		t.mu.Unlock()
+	}
+
+	members := make(map[string][]string, len(groups))
+	for _, g := range groups {
+		members[g.Tag] = g.Outbounds
+		for _, tag := range g.Outbounds {
+			if ob, ok := byTag[tag]; ok {
+				t.note(ob, "member of %s group %q", g.Type, g.Tag)
+			}
+		}
+	}
+
+	for _, s := range selectors {
+		sel, ok := s.(SelectorOutbound)
+		if !ok {
+			continue
+		}
+		for _, tag := range sel.Outbounds {
+			if ob, ok := byTag[tag]; ok {
+				t.note(ob, "offered by selector %q", sel.Tag)
+				continue
+			}
+			for _, member := range members[tag] {
+				if ob, ok := byTag[member]; ok {
+					t.note(ob, "offered by selector %q through group %q", sel.Tag, tag)
+				}
+			}
+		}
+	}
+}
+
+func (t *Trace) save(stateDir string) error {
+	if t == nil {
+		return nil
+	}
+	t.GeneratedAt = time.Now().UTC()
+
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(stateDir, traceFile), t)
+}
+
+func loadTrace(stateDir string) (*Trace, error) {
+	path := filepath.Join(stateDir, traceFile)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no trace at %s, run a build first", path)
+		}
+		return nil, err
+	}
+
+	var t Trace
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &t, nil
+}
+
+// match finds the nodes query refers to: an id, a server, server:port or
+// any tag the node had. Without an exact match, tags containing query
+// match, ignoring case.
+func (t *Trace) match(query string) []*NodeTrace {
+	var exact, partial []*NodeTrace
+
+	for _, n := range t.Nodes {
+		if n.ID == query || strings.EqualFold(n.Server, query) || outboundKey(n.Server, n.ServerPort) == query {
+			exact = append(exact, n)
+			continue
+		}
+
+		found := false
+		for _, tag := range n.Tags {
+			if strings.EqualFold(tag, query) {
+				exact = append(exact, n)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		for _, tag := range n.Tags {
+			if strings.Contains(strings.ToLower(tag), strings.ToLower(query)) {
+				partial = append(partial, n)
+				break
+			}
+		}
+	}
+
+	if len(exact) > 0 {
+		return exact
+	}
+	return partial
+}
+
+type explainOptions struct {
+	StateDir string
+}
+
+func explainCommand(fs *flag.FlagSet) func(ctx context.Context) error {
+	opts := &explainOptions{}
+
+	fs.StringVar(&opts.StateDir, "state-dir", "state", "directory holding the trace of the last build")
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: msbc explain [flags] <tag|server|id>\n\n")
+		fmt.Fprintf(fs.Output(), "shows what the last build did to a node\n\n")
+		fs.PrintDefaults()
+	}
+
+	return func(_ context.Context) error {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return errors.New("expected exactly one tag, server or node id")
+		}
+		return explain(opts, fs.Arg(0))
+	}
+}
+
+func explain(opts *explainOptions, query string) error {
+	t, err := loadTrace(opts.StateDir)
+	if err != nil {
+		return err
+	}
+
+	nodes := t.match(query)
+	if len(nodes) == 0 {
+		return fmt.Errorf("no node matching %q in the build of %s", query, t.GeneratedAt.Local().Format(time.DateTime))
+	}
+
+	for i, n := range nodes {
+		if i > 0 {
+			fmt.Println()
+		}
+
+		fmt.Printf("%s %s %s from source %s\n", n.ID, n.Type, outboundKey(n.Server, n.ServerPort), n.Source)
+		if n.Kept {
+			fmt.Printf("  in the configs as %q\n", n.Tags[len(n.Tags)-1])
+		} else {
+			fmt.Printf("  not in the configs\n")
+		}
+		for _, step := range n.Steps {
+			fmt.Printf("  - %s\n", step)
+		}
+	}
+	return nil
+}
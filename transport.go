@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TransportOptions is sing-box's V2Ray transport block.
+type TransportOptions struct {
+	Type string `json:"type"`
+	// Host is used by the http and httpupgrade transports, the latter
+	// with a single string; websocket takes the host from Headers.
+	Host                []string          `json:"-"`
+	Path                string            `json:"path,omitempty"`
+	Headers             map[string]string `json:"headers,omitempty"`
+	MaxEarlyData        int               `json:"max_early_data,omitempty"`
+	EarlyDataHeaderName string            `json:"early_data_header_name,omitempty"`
+	ServiceName         string            `json:"service_name,omitempty"`
+	IdleTimeout         Duration          `json:"idle_timeout,omitempty"`
+	PingTimeout         Duration          `json:"ping_timeout,omitempty"`
+	PermitWithoutStream bool              `json:"permit_without_stream,omitempty"`
+}
+
+func (t TransportOptions) MarshalJSON() ([]byte, error) {
+	type plain TransportOptions
+
+	var host any
+	switch {
+	case len(t.Host) == 0:
+	case t.Type == "httpupgrade":
+		host = t.Host[0]
+	default:
+		host = t.Host
+	}
+
+	return json.Marshal(struct {
+		plain
+		Host any `json:"host,omitempty"`
+	}{plain(t), host})
+}
+
+// UnmarshalJSON accepts host as a string or a list, as sing-box does.
+func (t *TransportOptions) UnmarshalJSON(data []byte) error {
+	type plain TransportOptions
+
+	v := struct {
+		*plain
+		Host json.RawMessage `json:"host"`
+	}{plain: (*plain)(t)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	if len(v.Host) == 0 {
+		return nil
+	}
+	var host string
+	if err := json.Unmarshal(v.Host, &host); err == nil {
+		if host != "" {
+			t.Host = []string{host}
+		}
+		return nil
+	}
+	return json.Unmarshal(v.Host, &t.Host)
+}
+
+// GRPCConfig holds the health check settings of generated grpc transports,
+// which share links do not carry; zero values keep the sing-box defaults.
+type GRPCConfig struct {
+	IdleTimeout         Duration `json:"idle_timeout,omitempty"`
+	PingTimeout         Duration `json:"ping_timeout,omitempty"`
+	PermitWithoutStream bool     `json:"permit_without_stream,omitempty"`
+}
+
+// applyGRPCDefaults fills in the grpc settings a node does not set itself.
+func applyGRPCDefaults(c *GRPCConfig, outbounds []ServerOutbound) {
+	for i := range outbounds {
+		t := outbounds[i].Transport
+		if t == nil || t.Type != "grpc" {
+			continue
+		}
+		if t.IdleTimeout == 0 {
+			t.IdleTimeout = c.IdleTimeout
+		}
+		if t.PingTimeout == 0 {
+			t.PingTimeout = c.PingTimeout
+		}
+		t.PermitWithoutStream = t.PermitWithoutStream || c.PermitWithoutStream
+	}
+}
+
+// parseTransport reads the transport parameters shared by the V2Ray style
+// links: type=ws&path=/x&host=cdn.example.com or type=grpc&serviceName=svc.
+// Every parser of such links goes through it, so they all emit the same
+// transport block. Plain tcp yields nil.
+func parseTransport(q url.Values) (*TransportOptions, error) {
+	host := q.Get("host")
+
+	switch typ := q.Get("type"); typ {
+	case "", "tcp":
+		return nil, nil
+
+	case "ws":
+		t := &TransportOptions{Type: "ws", Path: q.Get("path")}
+		if host != "" {
+			t.Headers = map[string]string{"Host": host}
+		}
+		// "/path?ed=2048" asks for early data in the protocol header,
+		// as Xray does it; other clients put ed, and eh for the header
+		// name, next to path
+		ed := q.Get("ed")
+		if path, query, ok := strings.Cut(t.Path, "?"); ok {
+			if v, err := url.ParseQuery(query); err == nil && v.Has("ed") {
+				t.Path = path
+				ed = v.Get("ed")
+			}
+		}
+		if ed != "" {
+			size, err := strconv.Atoi(ed)
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("invalid early data size %q", ed)
+			}
+			t.MaxEarlyData = size
+			t.EarlyDataHeaderName = q.Get("eh")
+			if t.EarlyDataHeaderName == "" {
+				t.EarlyDataHeaderName = "Sec-WebSocket-Protocol"
+			}
+		}
+		return t, nil
+
+	// a Host header is dropped by the http client, the host has to go in
+	// its own field for the upgrade request to reach the server behind a
+	// CDN
+	case "httpupgrade":
+		t := &TransportOptions{Type: "httpupgrade", Path: q.Get("path")}
+		if host != "" {
+			t.Host = []string{host}
+		}
+		return t, nil
+
+	case "http", "h2":
+		t := &TransportOptions{Type: "http", Path: q.Get("path")}
+		if host != "" {
+			t.Host = splitList(host)
+		}
+		return t, nil
+
+	case "grpc":
+		// sing-box only speaks gun mode, which multi mode servers accept
+		if mode := q.Get("mode"); mode != "" && mode != "gun" {
+			log.Printf("ignoring grpc mode %q, sing-box only has gun mode", mode)
+		}
+		return &TransportOptions{Type: "grpc", ServiceName: q.Get("serviceName")}, nil
+
+	// the quic transport has no settings of its own, it runs over the
+	// outbound's tls options
+	case "quic":
+		return &TransportOptions{Type: "quic"}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported transport %q", typ)
+	}
+}
+
+// setTransportParams is the inverse of parseTransport.
+func setTransportParams(q url.Values, t *TransportOptions) {
+	if t == nil {
+		return
+	}
+
+	q.Set("type", t.Type)
+	switch t.Type {
+	case "ws":
+		path := t.Path
+		if t.MaxEarlyData > 0 {
+			if t.EarlyDataHeaderName == "Sec-WebSocket-Protocol" {
+				path += "?ed=" + strconv.Itoa(t.MaxEarlyData)
+			} else {
+				q.Set("ed", strconv.Itoa(t.MaxEarlyData))
+				q.Set("eh", t.EarlyDataHeaderName)
+			}
+		}
+		if path != "" {
+			q.Set("path", path)
+		}
+		if host := t.Headers["Host"]; host != "" {
+			q.Set("host", host)
+		}
+	case "httpupgrade", "http":
+		if t.Path != "" {
+			q.Set("path", t.Path)
+		}
+		if len(t.Host) > 0 {
+			q.Set("host", strings.Join(t.Host, ","))
+		}
+	case "grpc":
+		if t.ServiceName != "" {
+			q.Set("serviceName", t.ServiceName)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ValidationError pinpoints a problem inside a JSON document: the file it was
+// read from, the RFC 6901 pointer of the offending node and its value.
+type ValidationError struct {
+	File    string
+	Pointer string
+	Value   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.File + ": "
+	if e.Pointer != "" {
+		msg += e.Pointer + ": "
+	}
+	msg += e.Message
+	if e.Value != "" {
+		msg += " (got " + e.Value + ")"
+	}
+	return msg
+}
+
+// ValidationErrors aggregates every problem found in a single run so that
+// users can fix them all at once instead of one per invocation.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	if len(errs) == 1 {
+		return errs[0].Error()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d validation errors:", len(errs))
+	for _, e := range errs {
+		b.WriteString("\n  ")
+		b.WriteString(e.Error())
+	}
+	return b.String()
+}
+
+// Err returns nil when no errors were collected, so callers can write
+// `return errs.Err()` without tripping over typed nil interfaces.
+func (errs ValidationErrors) Err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (errs *ValidationErrors) add(file, pointer string, value any, format string, args ...any) {
+	*errs = append(*errs, &ValidationError{
+		File:    file,
+		Pointer: pointer,
+		Value:   formatValue(value),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// decodeDocument parses data into a generic tree, turning syntax errors into
+// ValidationErrors that carry the line and column of the failure.
+func decodeDocument(file string, data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			// Offset counts the offending byte as read already
+			line, col := offsetPosition(data, max(syntaxErr.Offset-1, 0))
+			return nil, ValidationErrors{{
+				File:    file,
+				Message: fmt.Sprintf("syntax error at line %d, column %d: %v", line, col, err),
+			}}
+		}
+		return nil, ValidationErrors{{File: file, Message: err.Error()}}
+	}
+
+	return doc, nil
+}
+
+func offsetPosition(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	line, col := 1, 1
+	for _, c := range data[:offset] {
+		if c == '\n' {
+			line++
+			col = 1
+			continue
+		}
+		col++
+	}
+	return line, col
+}
+
+func pointerJoin(pointer string, token any) string {
+	switch t := token.(type) {
+	case int:
+		return pointer + "/" + strconv.Itoa(t)
+	case string:
+		t = strings.ReplaceAll(t, "~", "~0")
+		t = strings.ReplaceAll(t, "/", "~1")
+		return pointer + "/" + t
+	}
+	return pointer
+}
+
+func formatValue(v any) string {
+	if v == nil {
+		return ""
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	const maxLen = 80
+	if len(data) > maxLen {
+		return string(data[:maxLen]) + "..."
+	}
+	return string(data)
+}
+
+func jsonTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// validateOutbounds checks the shape of an {"outbounds": [...]} document as
+// used by scheme files: every entry needs a string type and a unique tag, and
+// selector-like entries must list their members as strings.
+func validateOutbounds(file string, doc any) error {
+	var errs ValidationErrors
+
+	root, ok := doc.(map[string]any)
+	if !ok {
+		errs.add(file, "", nil, "expected object, got %s", jsonTypeName(doc))
+		return errs.Err()
+	}
+
+	list, ok := root["outbounds"].([]any)
+	if !ok {
+		if v, exists := root["outbounds"]; exists {
+			errs.add(file, "/outbounds", v, "expected array")
+		} else {
+			errs.add(file, "/outbounds", nil, "missing required field")
+		}
+		return errs.Err()
+	}
+
+	seen := make(map[string]string)
+
+	for i, item := range list {
+		pointer := pointerJoin("/outbounds", i)
+
+		ob, ok := item.(map[string]any)
+		if !ok {
+			errs.add(file, pointer, item, "expected object")
+			continue
+		}
+
+		typ, ok := ob["type"].(string)
+		if !ok || typ == "" {
+			errs.add(file, pointerJoin(pointer, "type"), ob["type"], "expected non-empty string")
+		}
+
+		tag, ok := ob["tag"].(string)
+		if !ok || tag == "" {
+			errs.add(file, pointerJoin(pointer, "tag"), ob["tag"], "expected non-empty string")
+		} else if prev, dup := seen[tag]; dup {
+			errs.add(file, pointerJoin(pointer, "tag"), tag, "duplicate tag, first defined at %s", prev)
+		} else {
+			seen[tag] = pointerJoin(pointer, "tag")
+		}
+
+		if typ != "selector" && typ != "urltest" {
+			continue
+		}
+
+		members, ok := ob["outbounds"].([]any)
+		if !ok {
+			errs.add(file, pointerJoin(pointer, "outbounds"), ob["outbounds"], "expected array of tags")
+			continue
+		}
+
+		for j, m := range members {
+			if s, ok := m.(string); !ok || s == "" {
+				errs.add(file, pointerJoin(pointerJoin(pointer, "outbounds"), j), m, "expected non-empty string")
+			}
+		}
+	}
+
+	return errs.Err()
+}
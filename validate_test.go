@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPointerJoin(t *testing.T) {
+	tests := []struct {
+		pointer string
+		token   any
+		want    string
+	}{
+		{"", "outbounds", "/outbounds"},
+		{"/outbounds", 3, "/outbounds/3"},
+		{"/route", "a/b", "/route/a~1b"},
+		{"/route", "~home", "/route/~0home"},
+		// "~" is escaped first, so "~1" stays a literal "~1"
+		{"", "~1/~0", "/~01~1~00"},
+		{"/x", "", "/x/"},
+		{"/x", 1.5, "/x"},
+	}
+
+	for _, tt := range tests {
+		if got := pointerJoin(tt.pointer, tt.token); got != tt.want {
+			t.Errorf("pointerJoin(%q, %v) = %q, want %q", tt.pointer, tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	var errs ValidationErrors
+	if err := errs.Err(); err != nil {
+		t.Fatalf("empty Err() = %v, want nil", err)
+	}
+
+	errs.add("a.json", "", nil, "expected object, got %s", "array")
+	if got, want := errs.Error(), "a.json: expected object, got array"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	errs.add("b.json", "/outbounds/0/tag", "proxy", "duplicate tag")
+	errs.add("b.json", "/outbounds/1/port", strings.Repeat("x", 100), "expected number")
+	want := `3 validation errors:
+  a.json: expected object, got array
+  b.json: /outbounds/0/tag: duplicate tag (got "proxy")
+  b.json: /outbounds/1/port: expected number (got "` + strings.Repeat("x", 79) + `...)`
+	if got := errs.Err().Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestDecodeDocument(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"first line", `{"a": }`, "test.json: syntax error at line 1, column 7: invalid character '}' looking for beginning of value"},
+		{"later line", "{\n  \"a\": 1,\n  \"b\": ]\n}", "test.json: syntax error at line 3, column 8: invalid character ']' looking for beginning of value"},
+		{"after crlf", "{\r\n\"a\": 1\r\n\"b\": 2}", "test.json: syntax error at line 3, column 1: invalid character '\"' after object key:value pair"},
+		{"truncated", "{\n\"a\": [1,", "test.json: unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeDocument("test.json", []byte(tt.in))
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("decodeDocument() error = %v, want ValidationErrors", err)
+			}
+			if got := err.Error(); got != tt.want {
+				t.Errorf("decodeDocument() error = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateOutbounds(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     []string
+	}{
+		{
+			name: "valid",
+			in:   `{"outbounds": [{"type": "direct", "tag": "direct"}, {"type": "selector", "tag": "proxy", "outbounds": ["direct"]}]}`,
+		},
+		{
+			name: "not an object",
+			in:   `[]`,
+			want: []string{"s.json: expected object, got array"},
+		},
+		{
+			name: "missing outbounds",
+			in:   `{}`,
+			want: []string{"s.json: /outbounds: missing required field"},
+		},
+		{
+			name: "outbounds not an array",
+			in:   `{"outbounds": {"tag": "direct"}}`,
+			want: []string{`s.json: /outbounds: expected array (got {"tag":"direct"})`},
+		},
+		{
+			name: "every problem reported",
+			in: `{"outbounds": [
+				"direct",
+				{"type": "direct", "tag": "a/b"},
+				{"type": "", "tag": "a/b"},
+				{"type": "urltest", "tag": "auto"},
+				{"type": "selector", "tag": "~sel", "outbounds": ["auto", 1, ""]}
+			]}`,
+			want: []string{
+				`s.json: /outbounds/0: expected object (got "direct")`,
+				`s.json: /outbounds/2/type: expected non-empty string (got "")`,
+				`s.json: /outbounds/2/tag: duplicate tag, first defined at /outbounds/1/tag (got "a/b")`,
+				"s.json: /outbounds/3/outbounds: expected array of tags",
+				"s.json: /outbounds/4/outbounds/1: expected non-empty string (got 1)",
+				`s.json: /outbounds/4/outbounds/2: expected non-empty string (got "")`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := decodeDocument("s.json", []byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = validateOutbounds("s.json", doc)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("validateOutbounds() = %v", err)
+				}
+				return
+			}
+
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("validateOutbounds() error = %v, want ValidationErrors", err)
+			}
+			if len(errs) != len(tt.want) {
+				t.Fatalf("validateOutbounds() = %v, want %q", errs, tt.want)
+			}
+			for i, e := range errs {
+				if got := e.Error(); got != tt.want[i] {
+					t.Errorf("error %d = %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
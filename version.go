@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// targetVersion is the sing-box version the generated configs are meant for.
+// Features newer than the target are left out of the output. The zero value
+// targets the latest release.
+type targetVersion struct {
+	major, minor int
+}
+
+func (v *targetVersion) String() string {
+	if *v == (targetVersion{}) {
+		return ""
+	}
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+func (v *targetVersion) Set(s string) error {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if s == "" || s == "latest" {
+		*v = targetVersion{}
+		return nil
+	}
+
+	parts := strings.SplitN(s, ".", 3)
+	if len(parts) < 2 {
+		return fmt.Errorf("invalid version %q, expected major.minor such as 1.11", s)
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("invalid version %q, expected major.minor such as 1.11", s)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid version %q, expected major.minor such as 1.11", s)
+	}
+
+	*v = targetVersion{major, minor}
+	return nil
+}
+
+// supports reports whether the target has a feature introduced in
+// sing-box major.minor.
+func (v targetVersion) supports(major, minor int) bool {
+	if v == (targetVersion{}) {
+		return true
+	}
+	return v.major > major || v.major == major && v.minor >= minor
+}
+
+// downgradeOutbounds strips options the target version does not understand
+// so sing-box does not refuse to start, and drops nodes of protocols it
+// does not have at all.
+func downgradeOutbounds(outbounds []ServerOutbound, target targetVersion) []ServerOutbound {
+	kept := outbounds[:0]
+
+	for i := range outbounds {
+		ob := &outbounds[i]
+
+		if ob.Type == "anytls" && !target.supports(1, 12) {
+			log.Printf("%s: anytls needs sing-box 1.12, skipping for %s", ob.Tag, target.String())
+			buildTrace.note(ob, "dropped, anytls needs sing-box 1.12")
+			continue
+		}
+
+		// ECH outside the with_ech build tag arrived in 1.12
+		if ob.TLS != nil && ob.TLS.ECH != nil && !target.supports(1, 12) {
+			log.Printf("%s: ECH needs sing-box 1.12, leaving it out for %s", ob.Tag, target.String())
+			ob.TLS.ECH = nil
+			buildTrace.note(ob, "ECH left out for sing-box %s", target.String())
+		}
+
+		if tls := ob.TLS; tls != nil && (tls.Fragment || tls.RecordFragment) && !target.supports(1, 12) {
+			log.Printf("%s: tls fragmentation needs sing-box 1.12, leaving it out for %s", ob.Tag, target.String())
+			tls.Fragment, tls.FragmentFallbackDelay, tls.RecordFragment = false, 0, false
+			buildTrace.note(ob, "tls fragmentation left out for sing-box %s", target.String())
+		}
+
+		if ob.Type == "naive" && !target.supports(1, 13) {
+			naiveAsHTTP(ob, target)
+			buildTrace.note(ob, "written as an http outbound for sing-box %s", target.String())
+		}
+
+		// wireguard moved from outbounds to endpoints in 1.11
+		ob.Endpoint = ob.Type == "wireguard" && target.supports(1, 11)
+
+		kept = append(kept, *ob)
+	}
+	return kept
+}
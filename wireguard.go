@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// wireguardEndpoint is the endpoint form of a wireguard node, which replaced
+// the wireguard outbound in sing-box 1.11.
+type wireguardEndpoint struct {
+	Type        string          `json:"type"`
+	Tag         string          `json:"tag"`
+	MTU         int             `json:"mtu,omitempty"`
+	Address     []string        `json:"address"`
+	PrivateKey  string          `json:"private_key"`
+	Peers       []wireguardPeer `json:"peers"`
+	RoutingMark uint32          `json:"routing_mark,omitempty"`
+}
+
+type wireguardPeer struct {
+	Address                     string   `json:"address"`
+	Port                        int      `json:"port"`
+	PublicKey                   string   `json:"public_key"`
+	PreSharedKey                string   `json:"pre_shared_key,omitempty"`
+	AllowedIPs                  []string `json:"allowed_ips"`
+	PersistentKeepaliveInterval int      `json:"persistent_keepalive_interval,omitempty"`
+	Reserved                    []int    `json:"reserved,omitempty"`
+}
+
+func (ob *ServerOutbound) wireguardEndpoint() wireguardEndpoint {
+	return wireguardEndpoint{
+		Type:       "wireguard",
+		Tag:        ob.Tag,
+		MTU:        ob.MTU,
+		Address:    ob.LocalAddress,
+		PrivateKey: ob.PrivateKey,
+		Peers: []wireguardPeer{{
+			Address:                     ob.Server,
+			Port:                        ob.ServerPort,
+			PublicKey:                   ob.PeerPublicKey,
+			PreSharedKey:                ob.PreSharedKey,
+			AllowedIPs:                  ob.AllowedIPs,
+			PersistentKeepaliveInterval: ob.Keepalive,
+			Reserved:                    ob.Reserved,
+		}},
+		RoutingMark: ob.RoutingMark,
+	}
+}
+
+// splitEndpoints separates the nodes written as endpoints.
+func splitEndpoints(all []ServerOutbound) (outbounds, endpoints []ServerOutbound) {
+	for _, ob := range all {
+		if ob.Endpoint {
+			endpoints = append(endpoints, ob)
+		} else {
+			outbounds = append(outbounds, ob)
+		}
+	}
+	return outbounds, endpoints
+}
+
+// parseWireGuardURL reads the wireguard link format of v2rayN and its
+// relatives, under either scheme:
+//
+//	wireguard://url-encoded-private-key@host:port?publickey=key&presharedkey=key&address=10.0.0.2/32,fd00::2/128&reserved=1,2,3&mtu=1280#tag
+func parseWireGuardURL(u *url.URL) (*ServerOutbound, error) {
+	host, port, err := linkEndpoint(u)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil {
+		return nil, fmt.Errorf("missing private key for %s", host)
+	}
+
+	q := u.Query()
+	param := func(names ...string) string {
+		for _, name := range names {
+			if v := q.Get(name); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	wg := map[string]string{
+		"privatekey":   u.User.Username(),
+		"publickey":    param("publickey", "public_key", "peer_public_key"),
+		"presharedkey": param("presharedkey", "pre_shared_key", "psk"),
+		"address":      param("address", "ip", "local_address"),
+		"allowedips":   param("allowedips", "allowed_ips"),
+		"mtu":          param("mtu"),
+		"reserved":     param("reserved"),
+		"keepalive":    param("keepalive", "persistentkeepalive"),
+	}
+
+	return newWireGuardOutbound(host, port, wg)
+}
+
+// newWireGuardOutbound builds a node from the settings of a link or an INI
+// block, keyed by lowercase INI names.
+func newWireGuardOutbound(host string, port int, wg map[string]string) (*ServerOutbound, error) {
+	ob := &ServerOutbound{
+		BaseOutbound: BaseOutbound{Type: "wireguard"},
+		// written as an endpoint unless the target sing-box predates 1.11
+		Endpoint:      true,
+		Server:        host,
+		ServerPort:    port,
+		PrivateKey:    wg["privatekey"],
+		PeerPublicKey: wg["publickey"],
+		PreSharedKey:  wg["presharedkey"],
+		LocalAddress:  splitList(wg["address"]),
+		AllowedIPs:    splitList(wg["allowedips"]),
+	}
+
+	if !isWireGuardKey(ob.PrivateKey) {
+		return nil, fmt.Errorf("invalid or missing private key for %s", host)
+	}
+	if !isWireGuardKey(ob.PeerPublicKey) {
+		return nil, fmt.Errorf("invalid or missing public key for %s", host)
+	}
+	if ob.PreSharedKey != "" && !isWireGuardKey(ob.PreSharedKey) {
+		return nil, fmt.Errorf("invalid preshared key for %s", host)
+	}
+	if len(ob.LocalAddress) == 0 {
+		return nil, fmt.Errorf("missing address for %s", host)
+	}
+	// a bare address is a single host
+	for i, addr := range ob.LocalAddress {
+		if !strings.Contains(addr, "/") {
+			if strings.Contains(addr, ":") {
+				ob.LocalAddress[i] = addr + "/128"
+			} else {
+				ob.LocalAddress[i] = addr + "/32"
+			}
+		}
+	}
+	if len(ob.AllowedIPs) == 0 {
+		ob.AllowedIPs = []string{"0.0.0.0/0", "::/0"}
+	}
+
+	var err error
+	if v := wg["mtu"]; v != "" {
+		if ob.MTU, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid mtu %q for %s", v, host)
+		}
+	}
+	if v := wg["keepalive"]; v != "" {
+		if ob.Keepalive, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid keepalive %q for %s", v, host)
+		}
+	}
+	if v := wg["reserved"]; v != "" {
+		if ob.Reserved, err = parseReserved(v); err != nil {
+			return nil, fmt.Errorf("invalid reserved %q for %s: %v", v, host, err)
+		}
+	}
+
+	return ob, nil
+}
+
+// parseReserved reads the three reserved bytes, written as a list of
+// numbers or as base64.
+func parseReserved(v string) ([]int, error) {
+	parts := splitList(v)
+	if len(parts) != 3 {
+		data, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(data) != 3 {
+			return nil, fmt.Errorf("expected three bytes")
+		}
+		return []int{int(data[0]), int(data[1]), int(data[2])}, nil
+	}
+
+	reserved := make([]int, 0, 3)
+	for _, part := range parts {
+		b, err := strconv.ParseUint(part, 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		reserved = append(reserved, int(b))
+	}
+	return reserved, nil
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func isWireGuardKey(key string) bool {
+	data, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(data) == 32
+}
+
+// splitWireGuardBlocks takes the WireGuard INI configs out of the lines of
+// a subscription. A block starts at [Interface] and ends before the next
+// share link or [Interface].
+func splitWireGuardBlocks(lines []string) (rest []string, blocks [][]string) {
+	var block []string
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.EqualFold(trimmed, "[Interface]"):
+			if block != nil {
+				blocks = append(blocks, block)
+			}
+			block = []string{trimmed}
+		case block != nil && !strings.Contains(trimmed, "://"):
+			block = append(block, trimmed)
+		default:
+			if block != nil {
+				blocks = append(blocks, block)
+				block = nil
+			}
+			rest = append(rest, line)
+		}
+	}
+	if block != nil {
+		blocks = append(blocks, block)
+	}
+	return rest, blocks
+}
+
+// parseWireGuardINI converts a wg-quick style config into one node per
+// [Peer]. The tag comes from a "# Name = ..." comment, as written by
+// several providers, or else from name or the endpoint; the peers of a
+// config with several are numbered.
+func parseWireGuardINI(lines []string, name string) ([]ServerOutbound, error) {
+	iface := make(map[string]string)
+	var peers []map[string]string
+	section := iface
+
+	for _, line := range lines {
+		if comment, ok := strings.CutPrefix(line, "#"); ok {
+			key, value, ok := strings.Cut(comment, "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "name") {
+				name = strings.TrimSpace(value)
+			}
+			continue
+		}
+
+		switch {
+		case strings.EqualFold(line, "[Interface]"):
+			section = iface
+			continue
+		case strings.EqualFold(line, "[Peer]"):
+			section = make(map[string]string)
+			peers = append(peers, section)
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if section[key] != "" && (key == "address" || key == "allowedips") {
+			value = section[key] + "," + value
+		}
+		section[key] = value
+	}
+
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("wireguard config %s has no [Peer]", name)
+	}
+
+	var outbounds []ServerOutbound
+	for i, peer := range peers {
+		host, portStr, err := net.SplitHostPort(peer["endpoint"])
+		if err != nil {
+			return nil, fmt.Errorf("wireguard config %s: invalid endpoint %q", name, peer["endpoint"])
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("wireguard config %s: invalid endpoint %q", name, peer["endpoint"])
+		}
+
+		settings := map[string]string{
+			"privatekey": iface["privatekey"],
+			"address":    iface["address"],
+			"mtu":        iface["mtu"],
+			"reserved":   iface["reserved"],
+		}
+		for _, key := range []string{"publickey", "presharedkey", "allowedips", "reserved"} {
+			if v := peer[key]; v != "" {
+				settings[key] = v
+			}
+		}
+		settings["keepalive"] = peer["persistentkeepalive"]
+
+		ob, err := newWireGuardOutbound(host, port, settings)
+		if err != nil {
+			return nil, fmt.Errorf("wireguard config %s: %v", name, err)
+		}
+
+		ob.Tag = strings.TrimSpace(removeEmoji(name))
+		if ob.Tag == "" {
+			ob.Tag = "WireGuard " + host
+		}
+		if len(peers) > 1 {
+			ob.Tag = fmt.Sprintf("%s %d", ob.Tag, i+1)
+		}
+		ob.ID = nodeID(ob.Type, ob.Server, ob.ServerPort, ob.credential())
+		outbounds = append(outbounds, *ob)
+	}
+	return outbounds, nil
+}
+
+// loadWireGuardFiles reads the files listed under "wireguard" in the config.
+// A file is either a single INI config, named after the file, or a list of
+// wireguard share links.
+func loadWireGuardFiles(paths []string) ([]ServerOutbound, error) {
+	var outbounds []ServerOutbound
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var lines []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		rest, blocks := splitWireGuardBlocks(lines)
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+		var parsed []ServerOutbound
+		for _, block := range blocks {
+			nodes, err := parseWireGuardINI(block, name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			parsed = append(parsed, nodes...)
+		}
+		parsed = append(parsed, parseLines(rest)...)
+
+		for _, ob := range parsed {
+			ob.Source = path
+			buildTrace.note(&ob, "read from %s as %q", path, ob.Tag)
+			outbounds = append(outbounds, ob)
+		}
+		log.Printf("read %d wireguard nodes from %s", len(parsed), path)
+	}
+	return outbounds, nil
+}
+
+// wireguardLink is the inverse of parseWireGuardURL.
+func wireguardLink(ob *ServerOutbound) string {
+	q := url.Values{}
+	q.Set("publickey", ob.PeerPublicKey)
+	if ob.PreSharedKey != "" {
+		q.Set("presharedkey", ob.PreSharedKey)
+	}
+	q.Set("address", strings.Join(ob.LocalAddress, ","))
+	if len(ob.Reserved) == 3 {
+		q.Set("reserved", fmt.Sprintf("%d,%d,%d", ob.Reserved[0], ob.Reserved[1], ob.Reserved[2]))
+	}
+	if ob.MTU != 0 {
+		q.Set("mtu", strconv.Itoa(ob.MTU))
+	}
+
+	u := url.URL{
+		Scheme:   "wireguard",
+		User:     url.User(ob.PrivateKey),
+		Host:     net.JoinHostPort(ob.Server, strconv.Itoa(ob.ServerPort)),
+		RawQuery: q.Encode(),
+		Fragment: ob.Tag,
+	}
+	return u.String()
+}
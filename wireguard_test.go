@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	testWGPrivateKey = "YWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWE="
+	testWGPublicKey  = "YmJiYmJiYmJiYmJiYmJiYmJiYmJiYmJiYmJiYmJiYmI="
+)
+
+func TestParseWireGuardURL(t *testing.T) {
+	key := url.QueryEscape
+	tests := []struct {
+		name    string
+		link    string
+		want    ServerOutbound
+		wantErr bool
+	}{
+		{
+			name: "full",
+			link: "wireguard://" + key(testWGPrivateKey) + "@203.0.113.1:51820?publickey=" + key(testWGPublicKey) +
+				"&address=10.0.0.2,fd00::2&reserved=1,2,3&mtu=1280&keepalive=25#WG",
+			want: ServerOutbound{
+				BaseOutbound:  BaseOutbound{Type: "wireguard", Tag: "WG"},
+				Endpoint:      true,
+				Server:        "203.0.113.1",
+				ServerPort:    51820,
+				PrivateKey:    testWGPrivateKey,
+				PeerPublicKey: testWGPublicKey,
+				LocalAddress:  []string{"10.0.0.2/32", "fd00::2/128"},
+				AllowedIPs:    []string{"0.0.0.0/0", "::/0"},
+				MTU:           1280,
+				Keepalive:     25,
+				Reserved:      []int{1, 2, 3},
+			},
+		},
+		{
+			name: "wg scheme, base64 reserved",
+			link: "wg://" + key(testWGPrivateKey) + "@wg.example.com:443?public_key=" + key(testWGPublicKey) +
+				"&ip=10.0.0.2/24&allowed_ips=10.0.0.0/8&reserved=AQID",
+			want: ServerOutbound{
+				BaseOutbound:  BaseOutbound{Type: "wireguard"},
+				Endpoint:      true,
+				Server:        "wg.example.com",
+				ServerPort:    443,
+				PrivateKey:    testWGPrivateKey,
+				PeerPublicKey: testWGPublicKey,
+				LocalAddress:  []string{"10.0.0.2/24"},
+				AllowedIPs:    []string{"10.0.0.0/8"},
+				Reserved:      []int{1, 2, 3},
+			},
+		},
+		{name: "no private key", link: "wireguard://203.0.113.1:51820?publickey=" + key(testWGPublicKey) + "&address=10.0.0.2", wantErr: true},
+		{name: "short public key", link: "wireguard://" + key(testWGPrivateKey) + "@203.0.113.1:51820?publickey=YWJj&address=10.0.0.2", wantErr: true},
+		{name: "no address", link: "wireguard://" + key(testWGPrivateKey) + "@203.0.113.1:51820?publickey=" + key(testWGPublicKey), wantErr: true},
+		{name: "bad reserved", link: "wireguard://" + key(testWGPrivateKey) + "@203.0.113.1:51820?publickey=" + key(testWGPublicKey) + "&address=10.0.0.2&reserved=1,2,300", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob, err := parseShareLink(tt.link)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseShareLink() = %+v, want an error", ob)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ob.ID = ""
+			got, _ := json.Marshal(ob)
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) || ob.Endpoint != tt.want.Endpoint {
+				t.Errorf("parseShareLink() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestParseWireGuardINI(t *testing.T) {
+	config := `[Interface]
+# Name = Home
+PrivateKey = ` + testWGPrivateKey + `
+Address = 10.0.0.2/32
+Address = fd00::2/128
+MTU = 1420
+
+[Peer]
+PublicKey = ` + testWGPublicKey + `
+Endpoint = 203.0.113.1:51820
+PersistentKeepalive = 25
+
+[Peer]
+PublicKey = ` + testWGPublicKey + `
+Endpoint = [2001:db8::1]:51820
+AllowedIPs = 10.0.0.0/8`
+
+	rest, blocks := splitWireGuardBlocks(append([]string{"trojan://pw@example.com:443#T"}, strings.Split(config, "\n")...))
+	if len(rest) != 1 || len(blocks) != 1 {
+		t.Fatalf("splitWireGuardBlocks() = %d lines, %d blocks, want 1 and 1", len(rest), len(blocks))
+	}
+
+	outbounds, err := parseWireGuardINI(blocks[0], "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbounds) != 2 {
+		t.Fatalf("got %d nodes, want 2", len(outbounds))
+	}
+
+	for i, want := range []struct {
+		tag, server string
+		keepalive   int
+		allowed     []string
+	}{
+		{"Home 1", "203.0.113.1", 25, []string{"0.0.0.0/0", "::/0"}},
+		{"Home 2", "2001:db8::1", 0, []string{"10.0.0.0/8"}},
+	} {
+		ob := outbounds[i]
+		if ob.Tag != want.tag || ob.Server != want.server || ob.Keepalive != want.keepalive ||
+			!slices.Equal(ob.AllowedIPs, want.allowed) || ob.MTU != 1420 || !ob.Endpoint ||
+			!slices.Equal(ob.LocalAddress, []string{"10.0.0.2/32", "fd00::2/128"}) {
+			t.Errorf("node %d = %+v", i, ob)
+		}
+	}
+}
+
+func TestWireGuardOutputForm(t *testing.T) {
+	link := "wireguard://" + url.QueryEscape(testWGPrivateKey) + "@203.0.113.1:51820?publickey=" +
+		url.QueryEscape(testWGPublicKey) + "&address=10.0.0.2#WG"
+
+	ob, err := parseShareLink(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := encodeSingBox([]ServerOutbound{*ob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var converted struct {
+		Outbounds []map[string]any `json:"outbounds"`
+		Endpoints []map[string]any `json:"endpoints"`
+	}
+	if err := json.Unmarshal(data, &converted); err != nil {
+		t.Fatal(err)
+	}
+	if len(converted.Outbounds) != 0 || len(converted.Endpoints) != 1 || converted.Endpoints[0]["peers"] == nil {
+		t.Errorf("convert wrote %s, want a single wireguard endpoint", data)
+	}
+
+	for _, tt := range []struct {
+		target   targetVersion
+		endpoint bool
+	}{
+		{targetVersion{}, true},
+		{targetVersion{1, 11}, true},
+		{targetVersion{1, 10}, false},
+	} {
+		kept := downgradeOutbounds([]ServerOutbound{*ob}, tt.target)
+		if len(kept) != 1 || kept[0].Endpoint != tt.endpoint {
+			t.Errorf("target %v: endpoint = %v, want %v", tt.target, kept[0].Endpoint, tt.endpoint)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a non-empty line of a YAML document without its comment.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML reads the subset of YAML that Clash configs are written in:
+// block and single-line flow mappings and sequences, plain and quoted
+// scalars and literal blocks. Mappings come back as map[string]any,
+// sequences as []any and scalars as strings, or nil for null. Anchors and
+// tags are not supported.
+func parseYAML(data []byte) (any, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(string(data), "\n") {
+		text := strings.TrimRight(stripYAMLComment(strings.TrimRight(raw, "\r")), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" || trimmed == "..." {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot indent YAML", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+
+	v, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return v, nil
+}
+
+// stripYAMLComment cuts a comment off line. A comment starts with a # at
+// the beginning of the line or after whitespace, outside quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\',
+			quote == '\'' && c == '\'' && i+1 < len(line) && line[i+1] == '\'':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// a quote inside a plain scalar is just a character
+			if i == 0 || strings.IndexByte(" \t[{,:", line[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#':
+			if i == 0 || line[i-1] == ' ' || line[i-1] == '\t' {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+func isYAMLSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) parseBlock(indent int) (any, error) {
+	if isYAMLSeqItem(p.lines[p.pos].text) {
+		return p.parseSeq(indent)
+	}
+	return p.parseMap(indent)
+}
+
+// parseNested reads the block indented deeper than parent, if any.
+func (p *yamlParser) parseNested(parent int) (any, error) {
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > parent {
+		return p.parseBlock(p.lines[p.pos].indent)
+	}
+	return nil, nil
+}
+
+func (p *yamlParser) parseSeq(indent int) (any, error) {
+	seq := []any{}
+	for p.pos < len(p.lines) {
+		line := &p.lines[p.pos]
+		if line.indent != indent || !isYAMLSeqItem(line.text) {
+			break
+		}
+
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		switch {
+		case rest == "":
+			p.pos++
+			v, err := p.parseNested(indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+
+		case isYAMLKey(rest):
+			// "- key: value" starts a mapping indented to its first key
+			line.indent += len(line.text) - len(rest)
+			line.text = rest
+			v, err := p.parseMap(line.indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+
+		default:
+			v, err := parseYAMLFlow(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line.num, err)
+			}
+			p.pos++
+			seq = append(seq, v)
+		}
+	}
+	return seq, nil
+}
+
+func (p *yamlParser) parseMap(indent int) (any, error) {
+	m := make(map[string]any)
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || isYAMLSeqItem(line.text) {
+			break
+		}
+
+		key, value, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected a key", line.num)
+		}
+		p.pos++
+
+		var err error
+		switch {
+		case value == "":
+			// a sequence may sit at the indentation of its key
+			if p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLSeqItem(p.lines[p.pos].text) {
+				m[key], err = p.parseSeq(indent)
+			} else {
+				m[key], err = p.parseNested(indent)
+			}
+		case value[0] == '|' || value[0] == '>':
+			m[key] = p.parseBlockScalar(indent, value[0] == '|')
+		default:
+			m[key], err = parseYAMLFlow(value)
+			if err != nil {
+				err = fmt.Errorf("line %d: %v", line.num, err)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// parseBlockScalar joins the lines indented deeper than parent, with
+// newlines for a literal block and spaces for a folded one. Comment-like
+// content and blank lines inside the block are lost.
+func (p *yamlParser) parseBlockScalar(parent int, literal bool) string {
+	var lines []string
+	for p.pos < len(p.lines) && p.lines[p.pos].indent > parent {
+		lines = append(lines, p.lines[p.pos].text)
+		p.pos++
+	}
+	if literal {
+		return strings.Join(lines, "\n") + "\n"
+	}
+	return strings.Join(lines, " ")
+}
+
+func isYAMLKey(text string) bool {
+	if text[0] == '{' || text[0] == '[' {
+		return false
+	}
+	_, _, ok := splitYAMLKey(text)
+	return ok
+}
+
+// splitYAMLKey splits a "key: value" line. The key may be quoted.
+func splitYAMLKey(text string) (string, string, bool) {
+	if text[0] == '"' || text[0] == '\'' {
+		f := &yamlFlow{s: text}
+		key, err := f.quoted()
+		if err != nil {
+			return "", "", false
+		}
+		rest := strings.TrimLeft(text[f.i:], " ")
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// yamlFlow scans a single-line flow value such as {name: a, port: 443}.
+type yamlFlow struct {
+	s string
+	i int
+}
+
+func parseYAMLFlow(s string) (any, error) {
+	f := &yamlFlow{s: s}
+	v, err := f.value(false)
+	if err != nil {
+		return nil, err
+	}
+	f.skipSpace()
+	if f.i < len(f.s) {
+		return nil, fmt.Errorf("unexpected %q", f.s[f.i:])
+	}
+	return v, nil
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+func (f *yamlFlow) value(inFlow bool) (any, error) {
+	f.skipSpace()
+	if f.i >= len(f.s) {
+		return nil, nil
+	}
+
+	switch f.s[f.i] {
+	case '{':
+		return f.mapping()
+	case '[':
+		return f.sequence()
+	case '"', '\'':
+		return f.quoted()
+	}
+
+	v := f.plain(inFlow, false)
+	if v == "~" || v == "null" || v == "" {
+		return nil, nil
+	}
+	return v, nil
+}
+
+func (f *yamlFlow) mapping() (any, error) {
+	m := make(map[string]any)
+	f.i++
+	for {
+		f.skipSpace()
+		if f.i >= len(f.s) {
+			return nil, fmt.Errorf("unterminated mapping")
+		}
+		if f.s[f.i] == '}' {
+			f.i++
+			return m, nil
+		}
+
+		var key string
+		if c := f.s[f.i]; c == '"' || c == '\'' {
+			k, err := f.quoted()
+			if err != nil {
+				return nil, err
+			}
+			key = k
+		} else {
+			key = f.plain(true, true)
+		}
+
+		f.skipSpace()
+		var v any
+		if f.i < len(f.s) && f.s[f.i] == ':' {
+			f.i++
+			var err error
+			if v, err = f.value(true); err != nil {
+				return nil, err
+			}
+		}
+		m[key] = v
+
+		f.skipSpace()
+		if f.i < len(f.s) && f.s[f.i] == ',' {
+			f.i++
+		} else if f.i >= len(f.s) || f.s[f.i] != '}' {
+			return nil, fmt.Errorf("expected , or } in mapping")
+		}
+	}
+}
+
+func (f *yamlFlow) sequence() (any, error) {
+	seq := []any{}
+	f.i++
+	for {
+		f.skipSpace()
+		if f.i >= len(f.s) {
+			return nil, fmt.Errorf("unterminated sequence")
+		}
+		if f.s[f.i] == ']' {
+			f.i++
+			return seq, nil
+		}
+
+		v, err := f.value(true)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+
+		f.skipSpace()
+		if f.i < len(f.s) && f.s[f.i] == ',' {
+			f.i++
+		} else if f.i >= len(f.s) || f.s[f.i] != ']' {
+			return nil, fmt.Errorf("expected , or ] in sequence")
+		}
+	}
+}
+
+// plain reads an unquoted scalar. Inside a flow collection it ends at
+// , ] or }, and a key also ends at a colon followed by a space or the end.
+func (f *yamlFlow) plain(inFlow, key bool) string {
+	start := f.i
+	for ; f.i < len(f.s); f.i++ {
+		c := f.s[f.i]
+		if inFlow && (c == ',' || c == ']' || c == '}') {
+			break
+		}
+		if key && c == ':' && (f.i+1 == len(f.s) || strings.IndexByte(" ,}", f.s[f.i+1]) >= 0) {
+			break
+		}
+	}
+	return strings.TrimSpace(f.s[start:f.i])
+}
+
+func (f *yamlFlow) quoted() (string, error) {
+	quote := f.s[f.i]
+	f.i++
+
+	var b strings.Builder
+	for f.i < len(f.s) {
+		c := f.s[f.i]
+		f.i++
+
+		switch {
+		case c == quote && quote == '\'' && f.i < len(f.s) && f.s[f.i] == '\'':
+			b.WriteByte('\'')
+			f.i++
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && quote == '"' && f.i < len(f.s):
+			e := f.s[f.i]
+			f.i++
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			case 'u', 'x':
+				n := 4
+				if e == 'x' {
+					n = 2
+				}
+				if f.i+n > len(f.s) {
+					return "", fmt.Errorf("invalid escape")
+				}
+				r, err := strconv.ParseUint(f.s[f.i:f.i+n], 16, 32)
+				if err != nil {
+					return "", fmt.Errorf("invalid escape \\%c%s", e, f.s[f.i:f.i+n])
+				}
+				b.WriteRune(rune(r))
+				f.i += n
+			default:
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"empty", "", `null`},
+		{"scalars", "a: 1\nb: true\nc: ~\nd: null\ne:\n", `{"a": "1", "b": "true", "c": null, "d": null, "e": null}`},
+		{"quoted", "a: 'it''s # here' # comment\nb: \"q\\\"z\\u00e9\"\n\"k: x\": 1", `{"a": "it's # here", "b": "q\"zé", "k: x": "1"}`},
+		{"plain colons", "a: x:y\nb: http://h:80/p#frag", `{"a": "x:y", "b": "http://h:80/p#frag"}`},
+		{"top level sequence", "---\n- a\n- 'b'\n...", `["a", "b"]`},
+		{"sequence at key indent", "proxies:\n- name: x\n  type: ss\n- name: y", `{"proxies": [{"name": "x", "type": "ss"}, {"name": "y"}]}`},
+		{"nested", "a:\n  b:\n    - 1\n    -\n      c: 2\n  d: 3", `{"a": {"b": ["1", {"c": "2"}], "d": "3"}}`},
+		{"flow", "a: {name: n, port: 443, alpn: [h2, 'http/1.1'], empty: {}}", `{"a": {"name": "n", "port": "443", "alpn": ["h2", "http/1.1"], "empty": {}}}`},
+		{"literal block", "a: |\n  l1\n  l2\nb: 1", `{"a": "l1\nl2\n", "b": "1"}`},
+		{"folded block", "a: >\n  f1\n  f2", `{"a": "f1 f2"}`},
+		{"crlf", "a: 1\r\nb: 2\r\n", `{"a": "1", "b": "2"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := parseYAML([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertJSON(t, "parseYAML()", v, tt.want)
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"tab indent", "a:\n\tb: 1", "line 2: tabs cannot indent YAML"},
+		{"unterminated sequence", "a: [1, 2", "line 1: expected , or ] in sequence"},
+		{"unterminated mapping", "a:\n  b: {x: 1", "line 2: expected , or } in mapping"},
+		{"unterminated string", "a: 'x", "line 1: unterminated string"},
+		{"bad escape", `a: "\uzzzz"`, `line 1: invalid escape \uzzzz`},
+		{"not a key", "a: 1\njust text", "line 2: expected a key"},
+		{"dedent", "a:\n  b: 1\n c: 2", "line 3: unexpected indentation"},
+		{"mixed", "- a\nb: 1", "line 2: unexpected indentation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := parseYAML([]byte(tt.in))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("parseYAML() = %v, %v, want error %q", v, err, tt.want)
+			}
+		})
+	}
+}